@@ -0,0 +1,123 @@
+package bot
+
+import (
+	"sort"
+	"strings"
+)
+
+// Permission describes who has been granted a permission, by services account, by hostmask, or by having at least
+// the given prefix mode in the channel in question
+type Permission struct {
+	Accounts  []string
+	Hostmasks []string
+	Mode      string
+}
+
+// ACL maps permission names to who has been granted them
+type ACL map[string]Permission
+
+// SetACL replaces the permissions users are granted
+func (b *Bot) SetACL(acl ACL) {
+	b.aclMutex.Lock()
+	defer b.aclMutex.Unlock()
+	b.acl = acl
+}
+
+// Account returns the services account a user is known to be logged in to, or an empty string
+func (b *Bot) Account(nick string) string {
+	return b.state.getAccount(nick)
+}
+
+// Permissions returns the names of all the permissions granted to a user in a channel.  The source may be a full
+// nick!user@host or just a nick, and the account may be empty to use the last known account of the user.
+func (b *Bot) Permissions(source string, account string, channel string) []string {
+	b.aclMutex.RLock()
+	defer b.aclMutex.RUnlock()
+	permissions := make([]string, 0)
+	for name := range b.acl {
+		if b.granted(b.acl[name], source, account, channel) {
+			permissions = append(permissions, name)
+		}
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+// HasPermission checks if a user has been granted a permission in a channel, an empty permission is granted to
+// everyone
+func (b *Bot) HasPermission(source string, account string, channel string, permission string) bool {
+	if len(permission) == 0 {
+		return true
+	}
+	b.aclMutex.RLock()
+	defer b.aclMutex.RUnlock()
+	granted, ok := b.acl[permission]
+	if !ok {
+		return false
+	}
+	return b.granted(granted, source, account, channel)
+}
+
+func (b *Bot) granted(permission Permission, source string, account string, channel string) bool {
+	nick := strings.SplitN(source, "!", 2)[0]
+	if !strings.Contains(source, "!") {
+		if known := b.state.getSource(nick); len(known) > 0 {
+			source = known
+		}
+	}
+	if len(account) == 0 {
+		account = b.state.getAccount(nick)
+	}
+	if len(account) > 0 {
+		for _, allowed := range permission.Accounts {
+			if strings.EqualFold(allowed, account) {
+				return true
+			}
+		}
+	}
+	for _, mask := range permission.Hostmasks {
+		if MatchMask(mask, source) {
+			return true
+		}
+	}
+	if len(permission.Mode) > 0 && len(channel) > 0 {
+		prefixModes, _ := b.state.prefixes()
+		required := strings.Index(prefixModes, permission.Mode)
+		if required == -1 {
+			return false
+		}
+		for _, mode := range b.state.getModes(channel, nick) {
+			if index := strings.IndexRune(prefixModes, mode); index != -1 && index <= required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchMask matches an IRC style mask, where * matches any number of characters and ? matches a single character,
+// case insensitively
+func MatchMask(mask string, value string) bool {
+	mask = strings.ToLower(mask)
+	value = strings.ToLower(value)
+	maskIndex, valueIndex := 0, 0
+	starMask, starValue := -1, 0
+	for valueIndex < len(value) {
+		if maskIndex < len(mask) && (mask[maskIndex] == '?' || mask[maskIndex] == value[valueIndex]) {
+			maskIndex++
+			valueIndex++
+		} else if maskIndex < len(mask) && mask[maskIndex] == '*' {
+			starMask, starValue = maskIndex, valueIndex
+			maskIndex++
+		} else if starMask != -1 {
+			starValue++
+			maskIndex, valueIndex = starMask+1, starValue
+		} else {
+			return false
+		}
+	}
+	for maskIndex < len(mask) && mask[maskIndex] == '*' {
+		maskIndex++
+	}
+	return maskIndex == len(mask)
+}
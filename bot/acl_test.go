@@ -0,0 +1,116 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"go.uber.org/zap"
+)
+
+func newTestBot(t *testing.T, lines ...string) *Bot {
+	b := &Bot{
+		commands:     newCommandRegistry("!"),
+		acl:          ACL{},
+		rejoinPolicy: DefaultRejoinPolicy,
+		log:          zap.NewNop().Sugar(),
+	}
+	b.state = newState(func() map[string]string {
+		return map[string]string{"PREFIX": "(qaohv)~&@%+", "CHANMODES": "beI,k,l,imnpst"}
+	}, func() string {
+		return "bot"
+	})
+	testFeeder(t, b)(lines...)
+	return b
+}
+
+// testFeeder returns a function that passes lines to the bot's state callbacks as if they came from the server
+func testFeeder(t *testing.T, b *Bot) func(lines ...string) {
+	callbacks := map[string][]func(ircmsg.Message){}
+	b.state.addCallbacks(func(command string, callback func(ircmsg.Message)) {
+		callbacks[command] = append(callbacks[command], callback)
+	})
+	return func(lines ...string) {
+		for _, line := range lines {
+			message := parseTestLine(t, line)
+			for _, callback := range callbacks[message.Command] {
+				callback(message)
+			}
+		}
+	}
+}
+
+func parseTestLine(t *testing.T, line string) ircmsg.Message {
+	message, err := ircmsg.ParseLine(line)
+	if err != nil {
+		t.Error(err)
+	}
+	return message
+}
+
+func TestBot_HasPermission(t *testing.T) {
+	b := newTestBot(t,
+		":bot!bot@bot.host JOIN #test * :bot",
+		":irc.server 353 bot = #test :bot @oper!o@oper.host +voice!v@voice.host user!u@user.host",
+		":account!a@account.host JOIN #test greboid :Real Name",
+		":user!u@user.host MODE #test +b-v+h *!*@spam voice user",
+	)
+	b.SetACL(ACL{
+		"admin":  {Accounts: []string{"Greboid"}},
+		"staff":  {Hostmasks: []string{"*!*@*.host"}, Mode: "o"},
+		"deploy": {Mode: "h"},
+	})
+	tests := []struct {
+		name       string
+		source     string
+		account    string
+		channel    string
+		permission string
+		want       bool
+	}{
+		{name: "no permission required", source: "nobody!n@nowhere", permission: "", want: true},
+		{name: "unknown permission", source: "oper", channel: "#test", permission: "root", want: false},
+		{name: "account from extended join", source: "account", permission: "admin", want: true},
+		{name: "account from tag", source: "stranger!s@stranger", account: "greboid", permission: "admin", want: true},
+		{name: "hostmask", source: "stranger!s@stranger.host", permission: "staff", want: true},
+		{name: "hostmask of known nick", source: "user", permission: "staff", want: true},
+		{name: "hostmask not matching", source: "stranger!s@stranger", permission: "staff", want: false},
+		{name: "op meets mode", source: "oper!o@elsewhere", channel: "#test", permission: "deploy", want: true},
+		{name: "halfop from mode change", source: "user!u@elsewhere", channel: "#test", permission: "deploy", want: true},
+		{name: "devoiced", source: "voice!v@elsewhere", channel: "#test", permission: "deploy", want: false},
+		{name: "mode in other channel", source: "oper!o@elsewhere", channel: "#other", permission: "deploy", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.HasPermission(tt.source, tt.account, tt.channel, tt.permission); got != tt.want {
+				t.Errorf("HasPermission() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := b.Permissions("oper!o@oper.host", "", "#test"); !reflect.DeepEqual(got, []string{"deploy", "staff"}) {
+		t.Errorf("Permissions() = %v, want [deploy staff]", got)
+	}
+}
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		mask  string
+		value string
+		want  bool
+	}{
+		{mask: "*", value: "nick!user@host", want: true},
+		{mask: "nick!*@*", value: "Nick!user@host", want: true},
+		{mask: "*!*@user/*", value: "nick!user@user/greboid", want: true},
+		{mask: "n?ck!*", value: "nick!user@host", want: true},
+		{mask: "n?ck!*", value: "nck!user@host", want: false},
+		{mask: "*@host", value: "nick!user@otherhost.com", want: false},
+		{mask: "*!*@*.example.com", value: "nick!user@irc.example.com", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mask, func(t *testing.T) {
+			if got := MatchMask(tt.mask, tt.value); got != tt.want {
+				t.Errorf("MatchMask(%s, %s) = %v, want %v", tt.mask, tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,9 +1,10 @@
 package bot
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"strings"
+	"sync"
 
 	"github.com/ergochat/irc-go/ircevent"
 	"github.com/ergochat/irc-go/ircmsg"
@@ -11,20 +12,46 @@ import (
 )
 
 type Bot struct {
-	Connection     *irc.Connection
-	channels       []string
-	initialChannel string
-	log            irc.Logger
+	Connection         *irc.Connection
+	configuredChannels []Channel
+	desiredChannels    []*desiredChannel
+	rejoinPolicy       RejoinPolicy
+	channelStore       *channelSaver
+	channelsMutex      sync.Mutex
+	commands           *commandRegistry
+	state              *state
+	acl                ACL
+	aclMutex           sync.RWMutex
+	history            map[string]*historyBuffer
+	historySize        int
+	historyMutex       sync.Mutex
+	finished           chan struct{}
+	log                irc.Logger
+}
+
+// Channel is a channel to join, with an optional key
+type Channel struct {
+	Name string
+	Key  string
 }
 
 func NewBot(server, password, nickname, realname string, useTLS, useSasl bool, saslUser, saslPass string,
-	logger irc.Logger, floodProfile string, initialChannel string) *Bot {
+	logger irc.Logger, floodProfile irc.FloodProfile, initialChannels []Channel) *Bot {
 	connection := irc.NewIRC(server, password, nickname, realname, useTLS, useSasl, saslUser, saslPass, logger, floodProfile)
 	bot := &Bot{
-		Connection:     connection,
-		channels:       []string{},
-		initialChannel: initialChannel,
-		log:            logger,
+		Connection:         connection,
+		configuredChannels: initialChannels,
+		rejoinPolicy:       DefaultRejoinPolicy,
+		commands:           newCommandRegistry("!"),
+		acl:                ACL{},
+		history:            map[string]*historyBuffer{},
+		historySize:        DefaultHistorySize,
+		finished:           make(chan struct{}),
+		log:                logger,
+	}
+	bot.state = newState(connection.ISupport, connection.CurrentNick)
+	for index := range initialChannels {
+		bot.addDesired(initialChannels[index])
 	}
 	bot.addBotCallbacks()
 	return bot
@@ -42,43 +69,99 @@ func (b *Bot) AddCallback(s string, f func(ircmsg.Message)) ircevent.CallbackID
 	return b.Connection.AddCallback(s, f)
 }
 
-func (b *Bot) Start(signals chan os.Signal) error {
-	go func() {
-		<-signals
-		b.Connection.Quit()
-	}()
-	return b.Connection.ConnectAndWaitWithRetry(5)
+// ConnectionState returns the current state of the connection to the server
+func (b *Bot) ConnectionState() irc.StateChange {
+	return b.Connection.State()
+}
+
+// AddStateCallback calls the handler whenever the connection to the server changes state, the returned function
+// removes it
+func (b *Bot) AddStateCallback(handler func(irc.StateChange)) func() {
+	return b.Connection.AddStateCallback(handler)
+}
+
+// Start connects to the server and reconnects whenever the connection is lost, until the context is done or the
+// connection gives up.  Stop closes the connection.
+func (b *Bot) Start(ctx context.Context) error {
+	defer close(b.finished)
+	return b.Connection.Run(ctx)
+}
+
+// Stop quits the server with the quit message and waits for the connection to finish and the channels to be saved,
+// or the context to be done
+func (b *Bot) Stop(ctx context.Context) error {
+	b.Connection.Quit()
+	select {
+	case <-b.finished:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return b.flushChannels(ctx)
 }
 
+// GetChannels returns the channels the bot is currently in, the returned slice is a copy and safe to modify
 func (b *Bot) GetChannels() []string {
-	return b.channels
+	return b.state.getChannels()
+}
+
+// Fold lower cases a nick or channel name using the server's CASEMAPPING, so names the server treats as the same
+// compare equal
+func (b *Bot) Fold(name string) string {
+	return b.state.fold(name)
+}
+
+// ChannelUsers returns the users in a channel, or false if the bot is not in the channel
+func (b *Bot) ChannelUsers(channel string) ([]ChannelUser, bool) {
+	return b.state.getUsers(channel)
+}
+
+// ChannelTopic returns the topic of a channel, or false if the bot is not in the channel
+func (b *Bot) ChannelTopic(channel string) (ChannelTopic, bool) {
+	return b.state.getTopic(channel)
+}
+
+// ChannelModes returns the modes of a channel keyed on the mode character, with the parameter of the mode if it has
+// one, or false if the bot is not in the channel
+func (b *Bot) ChannelModes(channel string) (map[string]string, bool) {
+	return b.state.getChannelModes(channel)
+}
+
+// requestChannelState asks the server for the modes of a channel and the details of its users, using WHOX to get their
+// accounts where supported
+func (b *Bot) requestChannelState(channel string) {
+	if err := b.Connection.SendRawf("MODE %s", channel); err != nil {
+		b.log.Debugf("Unable to request modes for %s: %s", channel, err)
+	}
+	who := fmt.Sprintf("WHO %s", channel)
+	if _, ok := b.Connection.ISupport()["WHOX"]; ok {
+		who = fmt.Sprintf("WHO %s %%tuhnfa,%s", channel, whoToken)
+	}
+	if err := b.Connection.SendRaw(who); err != nil {
+		b.log.Debugf("Unable to request users for %s: %s", channel, err)
+	}
 }
 
 func (b *Bot) addBotCallbacks() {
 	b.Connection.AddConnectCallback(func(message ircmsg.Message) {
+		b.state.reset()
 		b.onConnect(b.Connection)
 	})
-	b.Connection.AddCallback("JOIN", func(message ircmsg.Message) {
-		nuh, err := message.NUH()
-		if err == nil {
-			if nuh.Name == b.Connection.CurrentNick() {
-				b.addToChannels(message.Params[0])
-			}
-		}
-	})
-	b.Connection.AddCallback("KICK", func(message ircmsg.Message) {
-		if message.Params[1] == b.Connection.CurrentNick() {
-			b.removeFromChannels(message.Params[0])
-		}
+	b.state.addCallbacks(func(command string, callback func(ircmsg.Message)) {
+		b.Connection.AddCallback(command, callback)
 	})
-	b.Connection.AddCallback("PART", func(message ircmsg.Message) {
-		nuh, err := message.NUH()
-		if err == nil {
-			if nuh.Name == b.Connection.CurrentNick() {
-				b.removeFromChannels(message.Params[0])
-			}
+	b.Connection.AddCallback("JOIN", func(message ircmsg.Message) {
+		if len(message.Params) > 0 && b.state.isMe(message.Nick()) {
+			b.handleSelfJoin(message.Params[0])
+			go b.requestChannelState(message.Params[0])
 		}
 	})
+	b.Connection.AddCallback("KICK", b.handleKick)
+	for _, numeric := range []string{ircevent.ERR_CHANNELISFULL, ircevent.ERR_INVITEONLYCHAN, ircevent.ERR_BANNEDFROMCHAN,
+		ircevent.ERR_BADCHANNELKEY} {
+		b.Connection.AddCallback(numeric, b.handleJoinFailure)
+	}
+	b.Connection.AddCallback("PRIVMSG", b.handleCommand)
+	b.Connection.AddCallback("PRIVMSG", b.recordHistory)
 }
 
 func (b *Bot) onConnect(c *irc.Connection) {
@@ -92,27 +175,33 @@ func (b *Bot) onConnect(c *irc.Connection) {
 	b.joinChannels(c)
 }
 
-func (b *Bot) joinChannels(c *irc.Connection) {
-	if len(b.initialChannel) == 0 {
-		return
-	}
-	for _, join := range b.getJoinCommands(b.initialChannel) {
-		_ = c.Join(join)
+// ParseChannels parses a comma separated list of channels, each with an optional space separated key
+func ParseChannels(channelString string) (channels []Channel) {
+	for _, channel := range strings.Split(channelString, ",") {
+		parts := strings.Split(channel, " ")
+		if len(parts) == 1 && len(parts[0]) > 0 {
+			channels = append(channels, Channel{Name: parts[0]})
+		} else if len(parts) == 2 {
+			channels = append(channels, Channel{Name: parts[0], Key: parts[1]})
+		}
 	}
+	return
+}
+
+func (b *Bot) getJoinCommands(channelString string) []string {
+	return b.joinCommands(ParseChannels(channelString))
 }
 
-func (b *Bot) getJoinCommands(channelString string) (joinCommands []string) {
+func (b *Bot) joinCommands(channels []Channel) (joinCommands []string) {
 	keyedChannels := make([]string, 0)
 	keys := make([]string, 0)
 	keylessChannels := make([]string, 0)
-	channels := strings.Split(channelString, ",")
 	for index := range channels {
-		parts := strings.Split(channels[index], " ")
-		if len(parts) == 1 {
-			keylessChannels = append(keylessChannels, channels[index])
-		} else if len(parts) == 2 {
-			keyedChannels = append(keyedChannels, parts[0])
-			keys = append(keys, parts[1])
+		if len(channels[index].Key) == 0 {
+			keylessChannels = append(keylessChannels, channels[index].Name)
+		} else {
+			keyedChannels = append(keyedChannels, channels[index].Name)
+			keys = append(keys, channels[index].Key)
 		}
 	}
 	if len(keyedChannels) > 0 {
@@ -123,25 +212,3 @@ func (b *Bot) getJoinCommands(channelString string) (joinCommands []string) {
 	}
 	return
 }
-
-func (b *Bot) addToChannels(channel string) {
-	existing := false
-	for i := range b.channels {
-		if b.channels[i] == channel {
-			existing = true
-			break
-		}
-	}
-	if !existing {
-		b.channels = append(b.channels, channel)
-	}
-}
-
-func (b *Bot) removeFromChannels(channel string) {
-	for i, v := range b.channels {
-		if v == channel {
-			b.channels = append(b.channels[:i], b.channels[i+1:]...)
-			break
-		}
-	}
-}
@@ -0,0 +1,33 @@
+package bot
+
+import "strings"
+
+// foldName lower cases a nick or channel name according to the CASEMAPPING advertised by the server, servers that
+// don't advertise one use rfc1459
+func foldName(casemapping string, name string) string {
+	switch casemapping {
+	case "ascii":
+		return foldASCII(name, "")
+	case "rfc1459-strict":
+		return foldASCII(name, "[]\\")
+	case "", "rfc1459":
+		return foldASCII(name, "[]\\~")
+	default:
+		return strings.ToLower(name)
+	}
+}
+
+// foldASCII lower cases the ASCII letters in a string, and the listed special characters which are treated as the
+// upper case forms of {}|^
+func foldASCII(name string, specials string) string {
+	folded := []byte(name)
+	for index, char := range folded {
+		switch {
+		case char >= 'A' && char <= 'Z':
+			folded[index] = char + 'a' - 'A'
+		case strings.IndexByte(specials, char) != -1:
+			folded[index] = "{}|^"[strings.IndexByte("[]\\~", char)]
+		}
+	}
+	return string(folded)
+}
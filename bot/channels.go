@@ -0,0 +1,270 @@
+package bot
+
+import (
+	"context"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/irc"
+)
+
+// RejoinPolicy controls how the bot gets back into channels it wants to be in.  Failed joins are retried with a
+// delay that doubles after each attempt up to MaxDelay, a MaxAttempts of zero retries forever.
+type RejoinPolicy struct {
+	Kick        bool
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int
+}
+
+// DefaultRejoinPolicy retries failed joins, but doesn't rejoin after being kicked
+var DefaultRejoinPolicy = RejoinPolicy{
+	Delay:    30 * time.Second,
+	MaxDelay: 10 * time.Minute,
+}
+
+// desiredChannel is a channel the bot wants to be in, along with any pending attempt to rejoin it
+type desiredChannel struct {
+	Channel
+	attempts int
+	timer    *time.Timer
+}
+
+func (d *desiredChannel) stopRejoin() {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+}
+
+// SetRejoinPolicy sets how the bot rejoins channels after being kicked or failing to join
+func (b *Bot) SetRejoinPolicy(policy RejoinPolicy) {
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+	b.rejoinPolicy = policy
+}
+
+// JoinChannel joins a channel and adds it to the channels the bot rejoins on reconnect.  The context limits how long
+// the JOIN may wait in the send queue.
+func (b *Bot) JoinChannel(ctx context.Context, name string, key string) error {
+	if err := b.Connection.ValidateChannel(name); err != nil {
+		return err
+	}
+	if err := irc.ValidateKey(key); err != nil {
+		return err
+	}
+	b.channelsMutex.Lock()
+	channel := b.addDesired(Channel{Name: name, Key: key})
+	b.saveChannels()
+	b.channelsMutex.Unlock()
+	return b.Connection.JoinContext(ctx, b.joinCommands([]Channel{channel})[0])
+}
+
+// PartChannel leaves a channel and removes it from the channels the bot rejoins on reconnect.  The context limits how
+// long the PART may wait in the send queue.
+func (b *Bot) PartChannel(ctx context.Context, name string) error {
+	if err := b.Connection.ValidateChannel(name); err != nil {
+		return err
+	}
+	b.channelsMutex.Lock()
+	b.removeDesired(name)
+	b.saveChannels()
+	b.channelsMutex.Unlock()
+	return b.Connection.PartContext(ctx, name)
+}
+
+// SetChannels replaces the configured channels, joining any channels that have been added and leaving any that
+// have been removed.  Channels joined with JoinChannel are unaffected unless they are removed from the config.
+func (b *Bot) SetChannels(channels []Channel) {
+	b.channelsMutex.Lock()
+	added := channelsMissingFrom(channels, b.configuredChannels, b.state.fold)
+	removed := channelsMissingFrom(b.configuredChannels, channels, b.state.fold)
+	b.configuredChannels = channels
+	for index := range added {
+		added[index] = b.addDesired(added[index])
+	}
+	for index := range removed {
+		b.removeDesired(removed[index].Name)
+	}
+	b.saveChannels()
+	b.channelsMutex.Unlock()
+	for _, join := range b.joinCommands(added) {
+		if err := b.Connection.Join(join); err != nil {
+			b.log.Debugf("Unable to join %s: %s", join, err)
+		}
+	}
+	for index := range removed {
+		if err := b.Connection.Part(removed[index].Name); err != nil {
+			b.log.Debugf("Unable to part %s: %s", removed[index].Name, err)
+		}
+	}
+}
+
+// channelsMissingFrom returns the channels that are not in the other list
+func channelsMissingFrom(channels []Channel, other []Channel, fold func(string) string) (missing []Channel) {
+	for index := range channels {
+		found := false
+		for otherIndex := range other {
+			if fold(channels[index].Name) == fold(other[otherIndex].Name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, channels[index])
+		}
+	}
+	return
+}
+
+// isConfigured returns true if the channel is one of the configured channels.  The channels mutex must be held.
+func (b *Bot) isConfigured(name string) bool {
+	return len(channelsMissingFrom([]Channel{{Name: name}}, b.configuredChannels, b.state.fold)) == 0
+}
+
+// findDesired returns the index of the desired channel with the given name, or -1.  The channels mutex must be held.
+func (b *Bot) findDesired(name string) int {
+	for index := range b.desiredChannels {
+		if b.state.fold(b.desiredChannels[index].Name) == b.state.fold(name) {
+			return index
+		}
+	}
+	return -1
+}
+
+// addDesired adds a channel to the desired channels, keeping the existing key if no new one is given, and returns
+// the channel to join.  The channels mutex must be held.
+func (b *Bot) addDesired(channel Channel) Channel {
+	index := b.findDesired(channel.Name)
+	if index == -1 {
+		b.desiredChannels = append(b.desiredChannels, &desiredChannel{Channel: channel})
+		return channel
+	}
+	desired := b.desiredChannels[index]
+	desired.stopRejoin()
+	desired.attempts = 0
+	if len(channel.Key) > 0 {
+		desired.Key = channel.Key
+	}
+	return desired.Channel
+}
+
+// removeDesired removes a channel from the desired channels.  The channels mutex must be held.
+func (b *Bot) removeDesired(name string) {
+	index := b.findDesired(name)
+	if index == -1 {
+		return
+	}
+	b.desiredChannels[index].stopRejoin()
+	b.desiredChannels = append(b.desiredChannels[:index], b.desiredChannels[index+1:]...)
+}
+
+// joinChannels joins every desired channel, cancelling any pending rejoins as they are no longer needed
+func (b *Bot) joinChannels(c *irc.Connection) {
+	b.channelsMutex.Lock()
+	channels := make([]Channel, 0, len(b.desiredChannels))
+	for _, desired := range b.desiredChannels {
+		desired.stopRejoin()
+		desired.attempts = 0
+		channels = append(channels, desired.Channel)
+	}
+	b.channelsMutex.Unlock()
+	for _, join := range b.joinCommands(channels) {
+		_ = c.Join(join)
+	}
+}
+
+// handleSelfJoin resets the rejoin attempts once the bot is back in a channel
+func (b *Bot) handleSelfJoin(channel string) {
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+	if index := b.findDesired(channel); index != -1 {
+		b.desiredChannels[index].stopRejoin()
+		b.desiredChannels[index].attempts = 0
+	}
+}
+
+func (b *Bot) handleKick(message ircmsg.Message) {
+	if len(message.Params) < 2 || !b.state.isMe(message.Params[1]) {
+		return
+	}
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+	index := b.findDesired(message.Params[0])
+	if index == -1 {
+		return
+	}
+	if !b.rejoinPolicy.Kick {
+		b.log.Infof("Kicked from %s by %s", message.Params[0], message.Nick())
+		// Configured channels are still wanted, so they are rejoined on reconnect rather than forgotten
+		if !b.isConfigured(message.Params[0]) {
+			b.removeDesired(message.Params[0])
+			b.saveChannels()
+		}
+		return
+	}
+	b.scheduleRejoin(b.desiredChannels[index], "kicked by "+message.Nick())
+}
+
+// handleJoinFailure retries joining desired channels the server refused to let the bot join
+func (b *Bot) handleJoinFailure(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	reason := message.Command
+	if len(message.Params) > 2 {
+		reason = message.Params[2]
+	}
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+	index := b.findDesired(message.Params[1])
+	if index == -1 {
+		return
+	}
+	b.scheduleRejoin(b.desiredChannels[index], reason)
+}
+
+// scheduleRejoin arranges for the bot to try joining the channel again, unless it has run out of attempts.  The
+// channels mutex must be held.
+func (b *Bot) scheduleRejoin(desired *desiredChannel, reason string) {
+	desired.stopRejoin()
+	desired.attempts++
+	if b.rejoinPolicy.MaxAttempts > 0 && desired.attempts > b.rejoinPolicy.MaxAttempts {
+		b.log.Errorf("Unable to join %s: %s, giving up after %d attempts", desired.Name, reason,
+			b.rejoinPolicy.MaxAttempts)
+		return
+	}
+	delay := rejoinDelay(b.rejoinPolicy, desired.attempts)
+	b.log.Warnf("Unable to join %s: %s, retrying in %s", desired.Name, reason, delay)
+	name := desired.Name
+	desired.timer = time.AfterFunc(delay, func() {
+		b.rejoin(name)
+	})
+}
+
+func (b *Bot) rejoin(name string) {
+	b.channelsMutex.Lock()
+	index := b.findDesired(name)
+	if index == -1 {
+		b.channelsMutex.Unlock()
+		return
+	}
+	b.desiredChannels[index].timer = nil
+	channel := b.desiredChannels[index].Channel
+	b.channelsMutex.Unlock()
+	if err := b.Connection.Join(b.joinCommands([]Channel{channel})[0]); err != nil {
+		b.log.Debugf("Unable to rejoin %s: %s", name, err)
+	}
+}
+
+// rejoinDelay returns the delay before the given attempt, doubling for each attempt up to the maximum delay
+func rejoinDelay(policy RejoinPolicy, attempt int) time.Duration {
+	delay := policy.Delay
+	for index := 1; index < attempt && delay < policy.MaxDelay; index++ {
+		delay *= 2
+	}
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/greboid/irc-bot/v5/irc"
+	"go.uber.org/zap"
+)
+
+func Test_rejoinDelay(t *testing.T) {
+	policy := RejoinPolicy{Delay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: time.Second},
+		{attempt: 2, want: 2 * time.Second},
+		{attempt: 4, want: 8 * time.Second},
+		{attempt: 5, want: 10 * time.Second},
+		{attempt: 1000, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want.String(), func(t *testing.T) {
+			if got := rejoinDelay(policy, tt.attempt); got != tt.want {
+				t.Errorf("rejoinDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// desiredNames returns the names of the desired channels that are waiting to be rejoined, and those that aren't
+func desiredNames(b *Bot) (waiting []string, other []string) {
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+	for _, desired := range b.desiredChannels {
+		if desired.timer != nil {
+			waiting = append(waiting, desired.Name)
+			desired.stopRejoin()
+		} else {
+			other = append(other, desired.Name)
+		}
+	}
+	return
+}
+
+func TestBot_rejoin(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      RejoinPolicy
+		configured  []Channel
+		lines       []string
+		wantWaiting []string
+		wantOther   []string
+	}{
+		{
+			name:      "kicked without rejoin",
+			policy:    RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour},
+			lines:     []string{":op!o@host KICK #Test bot :bye", ":op!o@host KICK #other other"},
+			wantOther: []string{"#other"},
+		},
+		{
+			name:       "kicked from a configured channel without rejoin",
+			policy:     RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour},
+			configured: []Channel{{Name: "#test"}},
+			lines:      []string{":op!o@host KICK #Test bot :bye"},
+			wantOther:  []string{"#test", "#other"},
+		},
+		{
+			name:        "kicked with rejoin",
+			policy:      RejoinPolicy{Kick: true, Delay: time.Hour, MaxDelay: time.Hour},
+			lines:       []string{":op!o@host KICK #TEST BOT :bye"},
+			wantWaiting: []string{"#test"},
+			wantOther:   []string{"#other"},
+		},
+		{
+			name:        "banned",
+			policy:      RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour},
+			lines:       []string{":irc.server 474 bot #test :Cannot join channel (+b)"},
+			wantWaiting: []string{"#test"},
+			wantOther:   []string{"#other"},
+		},
+		{
+			name:      "rejoined",
+			policy:    RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour},
+			lines:     []string{":irc.server 475 bot #test :Cannot join channel (+k)", ":bot!b@host JOIN #test"},
+			wantOther: []string{"#test", "#other"},
+		},
+		{
+			name:      "out of attempts",
+			policy:    RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour, MaxAttempts: 1},
+			lines:     []string{":irc.server 473 bot #test :Cannot join channel (+i)", ":irc.server 473 bot #test :Cannot join channel (+i)"},
+			wantOther: []string{"#test", "#other"},
+		},
+		{
+			name:      "not a desired channel",
+			policy:    RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour},
+			lines:     []string{":irc.server 474 bot #unknown :Cannot join channel (+b)"},
+			wantOther: []string{"#test", "#other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBot(t)
+			b.SetRejoinPolicy(tt.policy)
+			b.configuredChannels = tt.configured
+			b.desiredChannels = []*desiredChannel{{Channel: Channel{Name: "#test"}}, {Channel: Channel{Name: "#other"}}}
+			for _, line := range tt.lines {
+				message := parseTestLine(t, line)
+				switch message.Command {
+				case "KICK":
+					b.handleKick(message)
+				case "JOIN":
+					b.handleSelfJoin(message.Params[0])
+				default:
+					b.handleJoinFailure(message)
+				}
+			}
+			waiting, other := desiredNames(b)
+			if !reflect.DeepEqual(waiting, tt.wantWaiting) {
+				t.Errorf("waiting to rejoin = %v, want %v", waiting, tt.wantWaiting)
+			}
+			if !reflect.DeepEqual(other, tt.wantOther) {
+				t.Errorf("other desired channels = %v, want %v", other, tt.wantOther)
+			}
+		})
+	}
+}
+
+func TestBot_addDesired(t *testing.T) {
+	b := newTestBot(t)
+	b.desiredChannels = []*desiredChannel{{Channel: Channel{Name: "#runtime"}}}
+	b.configuredChannels = nil
+	b.channelsMutex.Lock()
+	b.addDesired(Channel{Name: "#Config", Key: "key"})
+	b.addDesired(Channel{Name: "#config"})
+	b.channelsMutex.Unlock()
+	want := []*desiredChannel{{Channel: Channel{Name: "#runtime"}}, {Channel: Channel{Name: "#Config", Key: "key"}}}
+	if !reflect.DeepEqual(b.desiredChannels, want) {
+		t.Errorf("desiredChannels = %v, want %v", b.desiredChannels, want)
+	}
+}
+
+func TestBot_JoinChannel_Context(t *testing.T) {
+	b := newTestBot(t)
+	b.Connection = irc.NewIRC("irc.example.com:6697", "", "bot", "bot", true, false, "", "",
+		zap.NewNop().Sugar(), irc.FloodProfile{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.JoinChannel(ctx, "#test", ""); !errors.Is(err, context.Canceled) {
+		t.Errorf("JoinChannel() error = %v, want %v", err, context.Canceled)
+	}
+	if err := b.PartChannel(ctx, "#test"); !errors.Is(err, context.Canceled) {
+		t.Errorf("PartChannel() error = %v, want %v", err, context.Canceled)
+	}
+}
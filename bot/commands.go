@@ -0,0 +1,247 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// Command is a chat command handled by a plugin
+type Command struct {
+	Name        string
+	Aliases     []string
+	Usage       string
+	Description string
+	Permission  string
+}
+
+// CommandInvocation is a use of a command, Channel is empty if it was sent privately
+type CommandInvocation struct {
+	Command     string
+	Arguments   []string
+	Message     string
+	Channel     string
+	Nick        string
+	Source      string
+	Account     string
+	Permissions []string
+	Tags        map[string]string
+}
+
+type registeredCommand struct {
+	command Command
+	owner   string
+	handler func(CommandInvocation)
+}
+
+// commandRegistry holds the commands registered by plugins, keyed on their lower cased names and aliases
+type commandRegistry struct {
+	mutex    sync.RWMutex
+	prefix   string
+	commands map[string]*registeredCommand
+}
+
+func newCommandRegistry(prefix string) *commandRegistry {
+	return &commandRegistry{
+		prefix:   prefix,
+		commands: make(map[string]*registeredCommand),
+	}
+}
+
+func (r *commandRegistry) setPrefix(prefix string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.prefix = prefix
+}
+
+func (r *commandRegistry) getPrefix() string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.prefix
+}
+
+// register adds the commands for an owner, failing if any name or alias is already in use.  The returned function
+// removes the commands again.
+func (r *commandRegistry) register(owner string, commands []Command, handler func(CommandInvocation)) (func(), error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var names []string
+	for index := range commands {
+		if len(commands[index].Name) == 0 {
+			return nil, fmt.Errorf("command name must be set")
+		}
+		for _, name := range append([]string{commands[index].Name}, commands[index].Aliases...) {
+			name = strings.ToLower(name)
+			if strings.ContainsAny(name, " \r\n") {
+				return nil, fmt.Errorf("invalid command name: %s", name)
+			}
+			if _, exists := r.commands[name]; exists || name == "help" || contains(names, name) {
+				return nil, fmt.Errorf("command already registered: %s", name)
+			}
+			names = append(names, name)
+		}
+	}
+	for index := range commands {
+		registered := &registeredCommand{
+			command: commands[index],
+			owner:   owner,
+			handler: handler,
+		}
+		for _, name := range append([]string{commands[index].Name}, commands[index].Aliases...) {
+			r.commands[strings.ToLower(name)] = registered
+		}
+	}
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for _, name := range names {
+			delete(r.commands, name)
+		}
+	}, nil
+}
+
+func (r *commandRegistry) get(name string) *registeredCommand {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.commands[strings.ToLower(name)]
+}
+
+// list returns every registered command, sorted by name
+func (r *commandRegistry) list() []Command {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var commands []Command
+	for name, registered := range r.commands {
+		if strings.EqualFold(name, registered.command.Name) {
+			commands = append(commands, registered.command)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// parse extracts the command name and arguments from a message, if it is addressed to the bot with either the command
+// prefix or the bot's nickname.  Private messages don't need to be addressed.
+func (r *commandRegistry) parse(text string, nick string, private bool) (name string, arguments string, ok bool) {
+	prefix := r.getPrefix()
+	switch {
+	case len(prefix) > 0 && strings.HasPrefix(text, prefix):
+		text = text[len(prefix):]
+	case len(nick) > 0 && len(text) > len(nick) && strings.EqualFold(text[:len(nick)], nick) &&
+		(text[len(nick)] == ':' || text[len(nick)] == ','):
+		text = text[len(nick)+1:]
+	case !private:
+		return "", "", false
+	}
+	text = strings.TrimSpace(text)
+	if len(text) == 0 {
+		return "", "", false
+	}
+	parts := strings.SplitN(text, " ", 2)
+	if len(parts) == 2 {
+		arguments = strings.TrimSpace(parts[1])
+	}
+	return strings.ToLower(parts[0]), arguments, true
+}
+
+// RegisterCommands adds commands owned by a plugin, the handler is called each time one is used by someone with the
+// required permission.  The returned function removes the commands.
+func (b *Bot) RegisterCommands(owner string, commands []Command, handler func(CommandInvocation)) (func(), error) {
+	return b.commands.register(owner, commands, handler)
+}
+
+// SetCommandPrefix sets the prefix used to address commands to the bot, commands can always be addressed with the
+// bot's nickname
+func (b *Bot) SetCommandPrefix(prefix string) {
+	b.commands.setPrefix(prefix)
+}
+
+func (b *Bot) handleCommand(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	private := b.state.isMe(message.Params[0])
+	name, arguments, ok := b.commands.parse(message.Params[1], b.CurrentNick(), private)
+	if !ok {
+		return
+	}
+	channel := message.Params[0]
+	if private {
+		channel = ""
+	}
+	if name == "help" {
+		b.sendHelp(message.Nick(), arguments)
+		return
+	}
+	registered := b.commands.get(name)
+	if registered == nil {
+		return
+	}
+	_, account := message.GetTag("account")
+	if !b.HasPermission(message.Source, account, channel, registered.command.Permission) {
+		b.log.Debugf("%s does not have permission to use %s", message.Source, name)
+		return
+	}
+	if len(account) == 0 {
+		account = b.state.getAccount(message.Nick())
+	}
+	registered.handler(CommandInvocation{
+		Command:     registered.command.Name,
+		Arguments:   strings.Fields(arguments),
+		Message:     arguments,
+		Channel:     channel,
+		Nick:        message.Nick(),
+		Source:      message.Source,
+		Account:     account,
+		Permissions: b.Permissions(message.Source, account, channel),
+		Tags:        message.AllTags(),
+	})
+}
+
+func (b *Bot) sendHelp(nick string, name string) {
+	if len(name) == 0 {
+		var names []string
+		for _, command := range b.commands.list() {
+			names = append(names, command.Name)
+		}
+		if len(names) == 0 {
+			b.notice(nick, "No commands available")
+			return
+		}
+		b.notice(nick, fmt.Sprintf("Available commands: %s", strings.Join(names, ", ")))
+		return
+	}
+	registered := b.commands.get(strings.TrimPrefix(strings.Fields(name)[0], b.commands.getPrefix()))
+	if registered == nil {
+		b.notice(nick, fmt.Sprintf("Unknown command: %s", name))
+		return
+	}
+	command := registered.command
+	b.notice(nick, strings.TrimSpace(fmt.Sprintf("Usage: %s %s", command.Name, command.Usage)))
+	if len(command.Description) > 0 {
+		b.notice(nick, command.Description)
+	}
+	if len(command.Aliases) > 0 {
+		b.notice(nick, fmt.Sprintf("Aliases: %s", strings.Join(command.Aliases, ", ")))
+	}
+}
+
+func (b *Bot) notice(target string, message string) {
+	if err := b.Connection.SendMessage("NOTICE", target, message); err != nil {
+		b.log.Errorf("Unable to send notice: %s", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for index := range values {
+		if values[index] == value {
+			return true
+		}
+	}
+	return false
+}
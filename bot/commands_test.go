@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"testing"
+)
+
+func Test_commandRegistry_parse(t *testing.T) {
+	registry := newCommandRegistry("!")
+	tests := []struct {
+		name          string
+		text          string
+		private       bool
+		wantName      string
+		wantArguments string
+		wantOk        bool
+	}{
+		{name: "prefixed", text: "!deploy foo bar", wantName: "deploy", wantArguments: "foo bar", wantOk: true},
+		{name: "prefixed no arguments", text: "!Deploy", wantName: "deploy", wantOk: true},
+		{name: "addressed with colon", text: "bot: deploy foo", wantName: "deploy", wantArguments: "foo", wantOk: true},
+		{name: "addressed with comma", text: "Bot, deploy", wantName: "deploy", wantOk: true},
+		{name: "not addressed", text: "deploy foo", wantOk: false},
+		{name: "nick prefix of word", text: "bots: deploy", wantOk: false},
+		{name: "only prefix", text: "! ", wantOk: false},
+		{name: "private", text: "deploy foo", private: true, wantName: "deploy", wantArguments: "foo", wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotName, gotArguments, gotOk := registry.parse(tt.text, "bot", tt.private)
+			if gotName != tt.wantName || gotArguments != tt.wantArguments || gotOk != tt.wantOk {
+				t.Errorf("parse() = (%s, %s, %v), want (%s, %s, %v)", gotName, gotArguments, gotOk,
+					tt.wantName, tt.wantArguments, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_commandRegistry_register(t *testing.T) {
+	registry := newCommandRegistry("!")
+	unregister, err := registry.register("deployer", []Command{{Name: "deploy", Aliases: []string{"ship"}}}, nil)
+	if err != nil {
+		t.Fatalf("register() error = %v", err)
+	}
+	if _, err := registry.register("other", []Command{{Name: "SHIP"}}, nil); err == nil {
+		t.Errorf("register() allowed duplicate alias")
+	}
+	if _, err := registry.register("other", []Command{{Name: "help"}}, nil); err == nil {
+		t.Errorf("register() allowed help to be replaced")
+	}
+	if registry.get("ship") == nil || registry.get("ship").owner != "deployer" {
+		t.Errorf("get() did not find command by alias")
+	}
+	if commands := registry.list(); len(commands) != 1 || commands[0].Name != "deploy" {
+		t.Errorf("list() = %#+v", commands)
+	}
+	unregister()
+	if registry.get("deploy") != nil || registry.get("ship") != nil {
+		t.Errorf("unregister() did not remove commands")
+	}
+}
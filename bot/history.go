@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/irc"
+)
+
+// DefaultHistorySize is how many messages are kept for each channel to replay to plugins that reconnect
+const DefaultHistorySize = 100
+
+// historyBuffer is a ring buffer holding the most recent messages sent to a channel
+type historyBuffer struct {
+	messages []ircmsg.Message
+	next     int
+	size     int
+}
+
+func newHistoryBuffer(size int, messages []ircmsg.Message) *historyBuffer {
+	buffer := &historyBuffer{size: size}
+	for index := range messages {
+		buffer.add(messages[index])
+	}
+	return buffer
+}
+
+func (h *historyBuffer) add(message ircmsg.Message) {
+	if len(h.messages) < h.size {
+		h.messages = append(h.messages, message)
+		return
+	}
+	h.messages[h.next] = message
+	h.next = (h.next + 1) % h.size
+}
+
+// all returns the buffered messages oldest first
+func (h *historyBuffer) all() []ircmsg.Message {
+	messages := make([]ircmsg.Message, 0, len(h.messages))
+	messages = append(messages, h.messages[h.next:]...)
+	return append(messages, h.messages[:h.next]...)
+}
+
+// MessageTime returns the time the server says a message was sent, or now if it didn't say
+func MessageTime(message ircmsg.Message) time.Time {
+	if ok, serverTime := message.GetTag("time"); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, serverTime); err == nil {
+			return parsed
+		}
+	}
+	return time.Now()
+}
+
+// SetHistorySize sets how many messages are kept for each channel, keeping the newest if it shrinks.  Zero stops
+// keeping history locally, though it is still fetched from servers that support CHATHISTORY.
+func (b *Bot) SetHistorySize(size int) {
+	b.historyMutex.Lock()
+	defer b.historyMutex.Unlock()
+	b.historySize = size
+	for channel, buffer := range b.history {
+		if size < 1 {
+			delete(b.history, channel)
+			continue
+		}
+		messages := buffer.all()
+		if len(messages) > size {
+			messages = messages[len(messages)-size:]
+		}
+		b.history[channel] = newHistoryBuffer(size, messages)
+	}
+}
+
+// recordHistory keeps a message sent to a channel, tagging it with the time it arrived if the server didn't
+func (b *Bot) recordHistory(message ircmsg.Message) {
+	if len(message.Params) < 2 || b.Connection.ValidateChannel(message.Params[0]) != nil {
+		return
+	}
+	b.historyMutex.Lock()
+	defer b.historyMutex.Unlock()
+	if b.historySize < 1 {
+		return
+	}
+	stored := ircmsg.MakeMessage(message.AllTags(), message.Source, message.Command, message.Params...)
+	if ok, _ := stored.GetTag("time"); !ok {
+		stored.SetTag("time", time.Now().UTC().Format(time.RFC3339Nano))
+	}
+	channel := b.state.fold(message.Params[0])
+	buffer, ok := b.history[channel]
+	if !ok {
+		buffer = newHistoryBuffer(b.historySize, nil)
+		b.history[channel] = buffer
+	}
+	buffer.add(stored)
+}
+
+// ChannelHistory returns the messages sent to a channel after the message with the given msgid, or after the given
+// time if afterID is empty, oldest first.  History comes from the server if it supports CHATHISTORY, otherwise from
+// the messages the bot has kept.  Kept messages are all returned if the msgid isn't one of them.  The bot's own
+// messages are never included.
+func (b *Bot) ChannelHistory(ctx context.Context, channel string, afterID string, after time.Time) ([]ircmsg.Message, error) {
+	if b.Connection.HistorySupported() {
+		b.historyMutex.Lock()
+		limit := b.historySize
+		b.historyMutex.Unlock()
+		messages, err := b.Connection.History(ctx, channel, afterID, after, limit)
+		if err == nil {
+			return b.filterHistory(messages), nil
+		}
+		if !errors.Is(err, irc.ErrHistoryUnavailable) {
+			return nil, err
+		}
+		b.log.Debugf("Unable to fetch history for %s from the server, using the local history: %s", channel, err)
+	}
+	return b.filterHistory(b.localHistory(channel, afterID, after)), nil
+}
+
+func (b *Bot) localHistory(channel string, afterID string, after time.Time) []ircmsg.Message {
+	b.historyMutex.Lock()
+	defer b.historyMutex.Unlock()
+	buffer, ok := b.history[b.state.fold(channel)]
+	if !ok {
+		return nil
+	}
+	messages := buffer.all()
+	if len(afterID) > 0 {
+		for index := range messages {
+			if _, msgid := messages[index].GetTag("msgid"); msgid == afterID {
+				return messages[index+1:]
+			}
+		}
+		return messages
+	}
+	var found []ircmsg.Message
+	for index := range messages {
+		if MessageTime(messages[index]).After(after) {
+			found = append(found, messages[index])
+		}
+	}
+	return found
+}
+
+// filterHistory removes everything but channel messages from other users, as plugins don't receive the bot's own
+// messages
+func (b *Bot) filterHistory(messages []ircmsg.Message) []ircmsg.Message {
+	var filtered []ircmsg.Message
+	for _, message := range messages {
+		if message.Command != "PRIVMSG" || len(message.Params) < 2 || b.state.isMe(message.Nick()) {
+			continue
+		}
+		if _, relayedBy := message.GetTag("draft/relaymsg"); b.state.isMe(relayedBy) {
+			continue
+		}
+		filtered = append(filtered, message)
+	}
+	return filtered
+}
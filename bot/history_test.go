@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/irc"
+	"go.uber.org/zap"
+)
+
+func Test_historyBuffer(t *testing.T) {
+	buffer := newHistoryBuffer(3, nil)
+	for _, text := range []string{"one", "two", "three", "four", "five"} {
+		buffer.add(ircmsg.MakeMessage(nil, "nick!user@host", "PRIVMSG", "#test", text))
+	}
+	var got []string
+	for _, message := range buffer.all() {
+		got = append(got, message.Params[1])
+	}
+	if want := []string{"three", "four", "five"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("all() = %v, want %v", got, want)
+	}
+}
+
+func TestBot_ChannelHistory(t *testing.T) {
+	b := newTestBot(t)
+	b.Connection = irc.NewIRC("irc.example.com:6697", "", "bot", "bot", true, false, "", "",
+		zap.NewNop().Sugar(), irc.FloodProfile{})
+	b.history = map[string]*historyBuffer{}
+	b.historySize = 3
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	lines := []string{
+		"@msgid=a;time=2021-01-01T00:00:01.000Z :nick!user@host PRIVMSG #Test :one",
+		"@msgid=b;time=2021-01-01T00:00:02.000Z :nick!user@host PRIVMSG #test :two",
+		"@msgid=c;time=2021-01-01T00:00:03.000Z :bot!bot@host PRIVMSG #test :mine",
+		"@msgid=d;time=2021-01-01T00:00:04.000Z :nick!user@host PRIVMSG #test :three",
+		"@msgid=e;time=2021-01-01T00:00:05.000Z :nick!user@host PRIVMSG bot :private",
+		"@msgid=f;time=2021-01-01T00:00:06.000Z :nick!user@host PRIVMSG #other :elsewhere",
+	}
+	for _, line := range lines {
+		message, err := ircmsg.ParseLine(line)
+		if err != nil {
+			t.Fatalf("ParseLine(%q) error = %v", line, err)
+		}
+		b.recordHistory(message)
+	}
+	tests := []struct {
+		name    string
+		afterID string
+		after   time.Time
+		want    []string
+	}{
+		{name: "after msgid", afterID: "b", want: []string{"three"}},
+		{name: "unknown msgid", afterID: "z", want: []string{"two", "three"}},
+		{name: "after time", after: start.Add(1500 * time.Millisecond), want: []string{"two", "three"}},
+		{name: "nothing newer", after: start.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, err := b.ChannelHistory(context.Background(), "#TEST", tt.afterID, tt.after)
+			if err != nil {
+				t.Fatalf("ChannelHistory() error = %v", err)
+			}
+			var got []string
+			for _, message := range messages {
+				got = append(got, message.Params[1])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChannelHistory() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	b.SetHistorySize(1)
+	if messages, _ := b.ChannelHistory(context.Background(), "#test", "", time.Time{}); len(messages) != 1 || messages[0].Params[1] != "three" {
+		t.Errorf("ChannelHistory() = %v after shrinking, want only the newest message", messages)
+	}
+}
@@ -0,0 +1,580 @@
+package bot
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// user is someone sharing a channel with the bot
+type user struct {
+	nick    string
+	source  string
+	account string
+}
+
+// channelState is a channel the bot is in, with the prefix modes of each user keyed on their case folded nick
+type channelState struct {
+	name  string
+	users map[string]string
+	topic ChannelTopic
+	modes map[rune]string
+}
+
+// ChannelUser is someone in a channel, Modes are their prefix modes ordered by rank
+type ChannelUser struct {
+	Nick    string
+	Source  string
+	Account string
+	Modes   string
+}
+
+// ChannelTopic is the topic of a channel, SetBy and SetAt are empty if the server hasn't told us
+type ChannelTopic struct {
+	Topic string
+	SetBy string
+	SetAt time.Time
+}
+
+// whoToken identifies the WHOX replies requested by the bot
+const whoToken = "42"
+
+// state tracks the users, topics and modes of the channels the bot is in
+type state struct {
+	mutex    sync.RWMutex
+	users    map[string]*user
+	channels map[string]*channelState
+	isupport func() map[string]string
+	nick     func() string
+}
+
+func newState(isupport func() map[string]string, nick func() string) *state {
+	return &state{
+		users:    make(map[string]*user),
+		channels: make(map[string]*channelState),
+		isupport: isupport,
+		nick:     nick,
+	}
+}
+
+// prefixes returns the prefix modes and their matching prefix characters from ISUPPORT, highest rank first
+func (s *state) prefixes() (modes string, chars string) {
+	prefix, ok := s.isupport()["PREFIX"]
+	if !ok || !strings.HasPrefix(prefix, "(") || !strings.Contains(prefix, ")") {
+		return "ov", "@+"
+	}
+	parts := strings.SplitN(prefix[1:], ")", 2)
+	return parts[0], parts[1]
+}
+
+// chanModes returns the type A, B, C and D channel modes from ISUPPORT
+func (s *state) chanModes() []string {
+	modes := strings.Split(s.isupport()["CHANMODES"], ",")
+	if len(modes) < 4 {
+		return []string{"beI", "k", "l", "imnpst"}
+	}
+	return modes
+}
+
+func (s *state) addCallbacks(addCallback func(string, func(ircmsg.Message))) {
+	addCallback("JOIN", s.handleJoin)
+	addCallback("PART", s.handlePart)
+	addCallback("KICK", s.handleKick)
+	addCallback("QUIT", s.handleQuit)
+	addCallback("NICK", s.handleNick)
+	addCallback("MODE", s.handleMode)
+	addCallback("ACCOUNT", s.handleAccount)
+	addCallback("353", s.handleNames)
+	addCallback("352", s.handleWho)
+	addCallback("354", s.handleWhox)
+	addCallback("TOPIC", s.handleTopic)
+	addCallback("331", s.handleNoTopic)
+	addCallback("332", s.handleTopicReply)
+	addCallback("333", s.handleTopicWhoTime)
+	addCallback("324", s.handleChannelModes)
+	addCallback("PRIVMSG", s.handleAccountTag)
+	addCallback("NOTICE", s.handleAccountTag)
+}
+
+// reset forgets all users and channels, used when reconnecting
+func (s *state) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users = make(map[string]*user)
+	s.channels = make(map[string]*channelState)
+}
+
+// fold lower cases a nick or channel name using the server's CASEMAPPING
+func (s *state) fold(name string) string {
+	return foldName(s.isupport()["CASEMAPPING"], name)
+}
+
+func (s *state) isMe(nick string) bool {
+	return s.fold(nick) == s.fold(s.nick())
+}
+
+// getUser returns the user with the given nick, creating them if needed.  The mutex must be held.
+func (s *state) getUser(nick string) *user {
+	existing, ok := s.users[s.fold(nick)]
+	if !ok {
+		existing = &user{nick: nick}
+		s.users[s.fold(nick)] = existing
+	}
+	return existing
+}
+
+// removeUser removes the user from the channel, and forgets them if they share no other channels.  The mutex must
+// be held.
+func (s *state) removeUser(channel string, nick string) {
+	if state, ok := s.channels[s.fold(channel)]; ok {
+		delete(state.users, s.fold(nick))
+	}
+	for _, state := range s.channels {
+		if _, ok := state.users[s.fold(nick)]; ok {
+			return
+		}
+	}
+	delete(s.users, s.fold(nick))
+}
+
+func (s *state) handleJoin(message ircmsg.Message) {
+	if len(message.Params) < 1 {
+		return
+	}
+	nuh, err := message.NUH()
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	channel := s.fold(message.Params[0])
+	if s.isMe(nuh.Name) {
+		s.channels[channel] = &channelState{
+			name:  message.Params[0],
+			users: make(map[string]string),
+			modes: make(map[rune]string),
+		}
+	}
+	state, ok := s.channels[channel]
+	if !ok {
+		return
+	}
+	joined := s.getUser(nuh.Name)
+	joined.source = message.Source
+	if len(message.Params) > 1 {
+		joined.account = normaliseAccount(message.Params[1])
+	} else if ok, account := message.GetTag("account"); ok {
+		joined.account = account
+	}
+	state.users[s.fold(nuh.Name)] = ""
+}
+
+func (s *state) handlePart(message ircmsg.Message) {
+	if len(message.Params) < 1 {
+		return
+	}
+	s.leave(message.Params[0], message.Nick())
+}
+
+func (s *state) handleKick(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	s.leave(message.Params[0], message.Params[1])
+}
+
+func (s *state) leave(channel string, nick string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.isMe(nick) {
+		s.removeUser(channel, nick)
+		return
+	}
+	state, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return
+	}
+	delete(s.channels, s.fold(channel))
+	for nick := range state.users {
+		s.removeUser(channel, nick)
+	}
+}
+
+func (s *state) handleQuit(message ircmsg.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	nick := s.fold(message.Nick())
+	for _, state := range s.channels {
+		delete(state.users, nick)
+	}
+	delete(s.users, nick)
+}
+
+func (s *state) handleNick(message ircmsg.Message) {
+	if len(message.Params) < 1 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	oldNick := s.fold(message.Nick())
+	newNick := message.Params[0]
+	existing, ok := s.users[oldNick]
+	if !ok {
+		return
+	}
+	delete(s.users, oldNick)
+	existing.nick = newNick
+	if nuh, err := message.NUH(); err == nil {
+		nuh.Name = newNick
+		existing.source = nuh.Canonical()
+	}
+	s.users[s.fold(newNick)] = existing
+	for _, state := range s.channels {
+		if modes, ok := state.users[oldNick]; ok {
+			delete(state.users, oldNick)
+			state.users[s.fold(newNick)] = modes
+		}
+	}
+}
+
+func (s *state) handleMode(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	prefixModes, _ := s.prefixes()
+	chanModes := s.chanModes()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.channels[s.fold(message.Params[0])]
+	if !ok {
+		return
+	}
+	state.applyModes(message.Params[1], message.Params[2:], prefixModes, chanModes, s.fold)
+}
+
+func (s *state) handleChannelModes(message ircmsg.Message) {
+	if len(message.Params) < 3 {
+		return
+	}
+	prefixModes, _ := s.prefixes()
+	chanModes := s.chanModes()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.channels[s.fold(message.Params[1])]
+	if !ok {
+		return
+	}
+	state.modes = make(map[rune]string)
+	state.applyModes(message.Params[2], message.Params[3:], prefixModes, chanModes, s.fold)
+}
+
+// applyModes applies a mode change to the channel.  List modes such as bans are not tracked, but their parameters are
+// consumed.
+func (state *channelState) applyModes(change string, params []string, prefixModes string, chanModes []string,
+	fold func(string) string) {
+	adding := true
+	for _, mode := range change {
+		switch {
+		case mode == '+':
+			adding = true
+		case mode == '-':
+			adding = false
+		case strings.ContainsRune(prefixModes, mode):
+			if len(params) == 0 {
+				return
+			}
+			nick := fold(params[0])
+			params = params[1:]
+			if modes, ok := state.users[nick]; ok {
+				state.users[nick] = setPrefixMode(modes, mode, adding, prefixModes)
+			}
+		case strings.ContainsRune(chanModes[0], mode):
+			if len(params) > 0 {
+				params = params[1:]
+			}
+		case strings.ContainsRune(chanModes[1], mode) || (adding && strings.ContainsRune(chanModes[2], mode)):
+			param := ""
+			if len(params) > 0 {
+				param = params[0]
+				params = params[1:]
+			}
+			if adding {
+				state.modes[mode] = param
+			} else {
+				delete(state.modes, mode)
+			}
+		case adding:
+			state.modes[mode] = ""
+		default:
+			delete(state.modes, mode)
+		}
+	}
+}
+
+// setPrefixMode adds or removes a prefix mode, keeping the modes ordered by rank
+func setPrefixMode(modes string, mode rune, adding bool, prefixModes string) string {
+	result := ""
+	for _, prefixMode := range prefixModes {
+		if prefixMode == mode {
+			if adding {
+				result += string(prefixMode)
+			}
+		} else if strings.ContainsRune(modes, prefixMode) {
+			result += string(prefixMode)
+		}
+	}
+	return result
+}
+
+func (s *state) handleNames(message ircmsg.Message) {
+	if len(message.Params) < 4 {
+		return
+	}
+	prefixModes, prefixChars := s.prefixes()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.channels[s.fold(message.Params[2])]
+	if !ok {
+		return
+	}
+	for _, name := range strings.Fields(message.Params[3]) {
+		modes := ""
+		for len(name) > 0 {
+			index := strings.IndexByte(prefixChars, name[0])
+			if index == -1 {
+				break
+			}
+			modes = setPrefixMode(modes, rune(prefixModes[index]), true, prefixModes)
+			name = name[1:]
+		}
+		nuh, err := ircmsg.ParseNUH(name)
+		if err != nil {
+			continue
+		}
+		named := s.getUser(nuh.Name)
+		if len(nuh.User) > 0 {
+			named.source = name
+		}
+		state.users[s.fold(nuh.Name)] = modes
+	}
+}
+
+func (s *state) handleWho(message ircmsg.Message) {
+	if len(message.Params) < 6 {
+		return
+	}
+	s.updateUser(message.Params[5], message.Params[2], message.Params[3], nil)
+}
+
+// handleWhox handles the WHOX replies requested with the %tuhnfa fields when the bot joins a channel
+func (s *state) handleWhox(message ircmsg.Message) {
+	if len(message.Params) < 7 || message.Params[1] != whoToken {
+		return
+	}
+	account := normaliseAccount(message.Params[6])
+	if account == "0" {
+		account = ""
+	}
+	s.updateUser(message.Params[4], message.Params[2], message.Params[3], &account)
+}
+
+// updateUser updates the source and optionally the account of a user the bot already knows about
+func (s *state) updateUser(nick string, username string, host string, account *string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, ok := s.users[s.fold(nick)]
+	if !ok {
+		return
+	}
+	existing.source = nick + "!" + username + "@" + host
+	if account != nil {
+		existing.account = *account
+	}
+}
+
+func (s *state) handleTopic(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	setAt := time.Now()
+	if ok, serverTime := message.GetTag("time"); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, serverTime); err == nil {
+			setAt = parsed
+		}
+	}
+	s.setTopic(message.Params[0], func(topic *ChannelTopic) {
+		*topic = ChannelTopic{
+			Topic: message.Params[1],
+			SetBy: message.Source,
+			SetAt: setAt,
+		}
+	})
+}
+
+func (s *state) handleNoTopic(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	s.setTopic(message.Params[1], func(topic *ChannelTopic) {
+		*topic = ChannelTopic{}
+	})
+}
+
+func (s *state) handleTopicReply(message ircmsg.Message) {
+	if len(message.Params) < 3 {
+		return
+	}
+	s.setTopic(message.Params[1], func(topic *ChannelTopic) {
+		topic.Topic = message.Params[2]
+	})
+}
+
+func (s *state) handleTopicWhoTime(message ircmsg.Message) {
+	if len(message.Params) < 4 {
+		return
+	}
+	s.setTopic(message.Params[1], func(topic *ChannelTopic) {
+		topic.SetBy = message.Params[2]
+		if seconds, err := strconv.ParseInt(message.Params[3], 10, 64); err == nil {
+			topic.SetAt = time.Unix(seconds, 0)
+		}
+	})
+}
+
+func (s *state) setTopic(channel string, update func(topic *ChannelTopic)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if state, ok := s.channels[s.fold(channel)]; ok {
+		update(&state.topic)
+	}
+}
+
+func (s *state) handleAccount(message ircmsg.Message) {
+	if len(message.Params) < 1 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.users[s.fold(message.Nick())]; ok {
+		existing.account = normaliseAccount(message.Params[0])
+	}
+}
+
+func (s *state) handleAccountTag(message ircmsg.Message) {
+	ok, account := message.GetTag("account")
+	if !ok {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.users[s.fold(message.Nick())]; ok {
+		existing.account = account
+		existing.source = message.Source
+	}
+}
+
+// normaliseAccount converts the "*" used for users not logged in to an empty account
+func normaliseAccount(account string) string {
+	if account == "*" {
+		return ""
+	}
+	return account
+}
+
+// getAccount returns the services account of a user, or an empty string if they are not known to be logged in
+func (s *state) getAccount(nick string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if existing, ok := s.users[s.fold(nick)]; ok {
+		return existing.account
+	}
+	return ""
+}
+
+// getSource returns the nick!user@host of a user, or an empty string if it is not known
+func (s *state) getSource(nick string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if existing, ok := s.users[s.fold(nick)]; ok {
+		return existing.source
+	}
+	return ""
+}
+
+// getModes returns the prefix modes a user has in a channel
+func (s *state) getModes(channel string, nick string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if state, ok := s.channels[s.fold(channel)]; ok {
+		return state.users[s.fold(nick)]
+	}
+	return ""
+}
+
+// getChannels returns the names of the channels the bot is in, sorted by name
+func (s *state) getChannels() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	channels := make([]string, 0, len(s.channels))
+	for _, state := range s.channels {
+		channels = append(channels, state.name)
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return s.fold(channels[i]) < s.fold(channels[j])
+	})
+	return channels
+}
+
+// getUsers returns the users in a channel sorted by nick, or false if the bot is not in the channel
+func (s *state) getUsers(channel string) ([]ChannelUser, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	state, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return nil, false
+	}
+	users := make([]ChannelUser, 0, len(state.users))
+	for nick, modes := range state.users {
+		channelUser := ChannelUser{Nick: nick, Modes: modes}
+		if existing, ok := s.users[nick]; ok {
+			channelUser.Nick = existing.nick
+			channelUser.Source = existing.source
+			channelUser.Account = existing.account
+		}
+		users = append(users, channelUser)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return s.fold(users[i].Nick) < s.fold(users[j].Nick)
+	})
+	return users, true
+}
+
+// getTopic returns the topic of a channel, or false if the bot is not in the channel
+func (s *state) getTopic(channel string) (ChannelTopic, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	state, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return ChannelTopic{}, false
+	}
+	return state.topic, true
+}
+
+// getChannelModes returns the modes set on a channel and their parameters, or false if the bot is not in the channel
+func (s *state) getChannelModes(channel string) (map[string]string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	state, ok := s.channels[s.fold(channel)]
+	if !ok {
+		return nil, false
+	}
+	modes := make(map[string]string, len(state.modes))
+	for mode, param := range state.modes {
+		modes[string(mode)] = param
+	}
+	return modes, true
+}
@@ -0,0 +1,281 @@
+package bot
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBot_ChannelUsers(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []ChannelUser
+	}{
+		{
+			name: "names with multiple prefixes",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 353 bot = #test :bot ~@owner!o@owner.host +voice",
+			},
+			want: []ChannelUser{
+				{Nick: "bot", Source: "bot!b@bot.host"},
+				{Nick: "owner", Source: "owner!o@owner.host", Modes: "qo"},
+				{Nick: "voice", Modes: "v"},
+			},
+		},
+		{
+			name: "who fills in sources",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 353 bot = #test :bot voice",
+				":irc.server 352 bot #test v voice.host irc.server voice H :0 Voice",
+			},
+			want: []ChannelUser{
+				{Nick: "bot", Source: "bot!b@bot.host"},
+				{Nick: "voice", Source: "voice!v@voice.host"},
+			},
+		},
+		{
+			name: "whox fills in accounts",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 353 bot = #test :bot user other",
+				":irc.server 354 bot 42 u user.host user H@ greboid",
+				":irc.server 354 bot 42 o other.host other H 0",
+			},
+			want: []ChannelUser{
+				{Nick: "bot", Source: "bot!b@bot.host"},
+				{Nick: "other", Source: "other!o@other.host"},
+				{Nick: "user", Source: "user!u@user.host", Account: "greboid"},
+			},
+		},
+		{
+			name: "join, part, kick, quit and nick",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 353 bot = #test :bot @op parted kicked quit",
+				":parted!p@host PART #test",
+				":op!o@host KICK #test kicked",
+				":quit!q@host QUIT :bye",
+				":op!o@host NICK Renamed",
+				":joined!j@joined.host JOIN #test",
+			},
+			want: []ChannelUser{
+				{Nick: "bot", Source: "bot!b@bot.host"},
+				{Nick: "joined", Source: "joined!j@joined.host"},
+				{Nick: "Renamed", Source: "Renamed!o@host", Modes: "o"},
+			},
+		},
+		{
+			name: "prefix mode changes",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 353 bot = #test :bot @op user",
+				":op!o@host MODE #test -o+vh-x+b op op user *!*@spam",
+			},
+			want: []ChannelUser{
+				{Nick: "bot", Source: "bot!b@bot.host"},
+				{Nick: "op", Modes: "v"},
+				{Nick: "user", Modes: "h"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBot(t, tt.lines...)
+			got, ok := b.ChannelUsers("#TEST")
+			if !ok {
+				t.Fatalf("ChannelUsers() not in channel")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChannelUsers() = %#+v, want %#+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBot_ChannelUsers_NotJoined(t *testing.T) {
+	b := newTestBot(t,
+		":bot!b@bot.host JOIN #test",
+		":bot!b@bot.host PART #test",
+		":irc.server 353 bot = #test :bot user",
+	)
+	if got, ok := b.ChannelUsers("#test"); ok {
+		t.Errorf("ChannelUsers() = %v, want not in channel", got)
+	}
+}
+
+func TestBot_ChannelTopic(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  ChannelTopic
+	}{
+		{
+			name: "topic on join",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 332 bot #test :Welcome to #test",
+				":irc.server 333 bot #test greboid!g@host 1600000000",
+			},
+			want: ChannelTopic{Topic: "Welcome to #test", SetBy: "greboid!g@host", SetAt: time.Unix(1600000000, 0)},
+		},
+		{
+			name: "topic changed",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 332 bot #test :Welcome to #test",
+				"@time=2021-01-02T03:04:05.000Z :op!o@host TOPIC #test :New topic",
+			},
+			want: ChannelTopic{Topic: "New topic", SetBy: "op!o@host", SetAt: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+		{
+			name: "topic cleared",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 332 bot #test :Welcome to #test",
+				":irc.server 331 bot #test :No topic is set",
+			},
+			want: ChannelTopic{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBot(t, tt.lines...)
+			got, ok := b.ChannelTopic("#test")
+			if !ok {
+				t.Fatalf("ChannelTopic() not in channel")
+			}
+			if got.Topic != tt.want.Topic || got.SetBy != tt.want.SetBy || !got.SetAt.Equal(tt.want.SetAt) {
+				t.Errorf("ChannelTopic() = %#+v, want %#+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBot_ChannelModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  map[string]string
+	}{
+		{
+			name: "modes reply",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 324 bot #test +ntkl secret 10",
+			},
+			want: map[string]string{"n": "", "t": "", "k": "secret", "l": "10"},
+		},
+		{
+			name: "mode changes",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":irc.server 324 bot #test +ntkl secret 10",
+				":op!o@host MODE #test -kl+bm-n+o secret *!*@spam bot",
+			},
+			want: map[string]string{"t": "", "m": ""},
+		},
+		{
+			name: "modes reply replaces existing modes",
+			lines: []string{
+				":bot!b@bot.host JOIN #test",
+				":op!o@host MODE #test +is",
+				":irc.server 324 bot #test +n",
+			},
+			want: map[string]string{"n": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBot(t, tt.lines...)
+			got, ok := b.ChannelModes("#test")
+			if !ok {
+				t.Fatalf("ChannelModes() not in channel")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChannelModes() = %#+v, want %#+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_foldName(t *testing.T) {
+	tests := []struct {
+		casemapping string
+		name        string
+		want        string
+	}{
+		{casemapping: "", name: "#Test[]\\~", want: "#test{}|^"},
+		{casemapping: "rfc1459", name: "Nick[]\\~", want: "nick{}|^"},
+		{casemapping: "rfc1459-strict", name: "Nick[]\\~", want: "nick{}|~"},
+		{casemapping: "ascii", name: "Nick[]\\~", want: "nick[]\\~"},
+		{casemapping: "ascii", name: "NÏCK", want: "nÏck"},
+		{casemapping: "rfc7613", name: "NÏCK", want: "nïck"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.casemapping+" "+tt.name, func(t *testing.T) {
+			if got := foldName(tt.casemapping, tt.name); got != tt.want {
+				t.Errorf("foldName() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBot_GetChannels(t *testing.T) {
+	b := newTestBot(t,
+		":bot!b@bot.host JOIN #Zebra",
+		":bot!b@bot.host JOIN #[test]",
+		":bot!b@bot.host JOIN #alpha",
+		":BOT!b@bot.host PART #{TEST}",
+		":op!o@host KICK #ALPHA bot",
+	)
+	channels := b.GetChannels()
+	if !reflect.DeepEqual(channels, []string{"#Zebra"}) {
+		t.Fatalf("GetChannels() = %v, want [#Zebra]", channels)
+	}
+	channels[0] = "#modified"
+	if got := b.GetChannels(); !reflect.DeepEqual(got, []string{"#Zebra"}) {
+		t.Errorf("GetChannels() = %v after modifying returned slice", got)
+	}
+}
+
+func TestBot_ConcurrentAccess(t *testing.T) {
+	b := newTestBot(t)
+	feed := testFeeder(t, b)
+	done := make(chan bool)
+	for writer := 0; writer < 4; writer++ {
+		go func(writer int) {
+			for index := 0; index < 100; index++ {
+				channel := fmt.Sprintf("#channel%d", index%10)
+				feed(
+					":bot!b@bot.host JOIN "+channel,
+					fmt.Sprintf(":user%d!u@host JOIN %s", writer, channel),
+					fmt.Sprintf(":op!o@host MODE %s +o user%d", channel, writer),
+					fmt.Sprintf(":user%d!u@host NICK other%d", writer, writer),
+					fmt.Sprintf(":other%d!u@host NICK user%d", writer, writer),
+					":bot!b@bot.host PART "+channel,
+				)
+			}
+			done <- true
+		}(writer)
+	}
+	for reader := 0; reader < 4; reader++ {
+		go func() {
+			for index := 0; index < 100; index++ {
+				for _, channel := range b.GetChannels() {
+					b.ChannelUsers(channel)
+					b.ChannelModes(channel)
+					b.ChannelTopic(channel)
+				}
+				b.HasPermission("user1!u@host", "", "#channel1", "")
+			}
+			done <- true
+		}()
+	}
+	for index := 0; index < 8; index++ {
+		<-done
+	}
+}
@@ -0,0 +1,180 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/greboid/irc-bot/v5/irc"
+)
+
+// ChannelStore persists the channels joined at runtime so they can be rejoined after a restart
+type ChannelStore interface {
+	Load() ([]Channel, error)
+	Save(channels []Channel) error
+}
+
+// JSONChannelStore is a ChannelStore that keeps the channels in a JSON file
+type JSONChannelStore struct {
+	Path string
+}
+
+type jsonChannelState struct {
+	Channels []jsonChannel `json:"channels"`
+}
+
+type jsonChannel struct {
+	Name string `json:"name"`
+	Key  string `json:"key,omitempty"`
+}
+
+// Load reads the channels from the file, a missing file has no channels
+func (s *JSONChannelStore) Load() ([]Channel, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := jsonChannelState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	channels := make([]Channel, 0, len(state.Channels))
+	for _, channel := range state.Channels {
+		channels = append(channels, Channel{Name: channel.Name, Key: channel.Key})
+	}
+	return channels, nil
+}
+
+// Save replaces the channels in the file, writing to a temporary file first so a crash can't leave it truncated
+func (s *JSONChannelStore) Save(channels []Channel) error {
+	state := jsonChannelState{Channels: make([]jsonChannel, 0, len(channels))}
+	for _, channel := range channels {
+		state.Channels = append(state.Channels, jsonChannel{Name: channel.Name, Key: channel.Key})
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), s.Path)
+}
+
+// SetChannelStore restores the channels saved in the store, and saves the channels joined at runtime to it whenever
+// they change.  Configured channels are not saved, the config remains the source of truth for them.
+func (b *Bot) SetChannelStore(store ChannelStore) error {
+	channels, err := store.Load()
+	if err != nil {
+		return err
+	}
+	b.channelsMutex.Lock()
+	defer b.channelsMutex.Unlock()
+	b.channelStore = newChannelSaver(store, b.log)
+	for index := range channels {
+		b.addDesired(channels[index])
+	}
+	return nil
+}
+
+// saveChannels queues the desired channels that aren't configured to be saved to the store, if there is one.  The
+// channels mutex must be held.
+func (b *Bot) saveChannels() {
+	if b.channelStore == nil {
+		return
+	}
+	var runtime []Channel
+	for _, desired := range b.desiredChannels {
+		if !b.isConfigured(desired.Name) {
+			runtime = append(runtime, desired.Channel)
+		}
+	}
+	b.channelStore.queue(runtime)
+}
+
+// flushChannels waits for any channels queued to be saved to be written, or the context to be done
+func (b *Bot) flushChannels(ctx context.Context) error {
+	b.channelsMutex.Lock()
+	saver := b.channelStore
+	b.channelsMutex.Unlock()
+	if saver == nil {
+		return nil
+	}
+	return saver.flush(ctx)
+}
+
+// channelSaver writes channels to a store in the background, so a slow disk doesn't hold up the IRC callbacks that
+// change them.  Only the latest channels are written if they change again before the store catches up.
+type channelSaver struct {
+	store   ChannelStore
+	log     irc.Logger
+	mutex   sync.Mutex
+	pending []Channel
+	queued  bool
+	signal  chan struct{}
+	saving  sync.WaitGroup
+}
+
+func newChannelSaver(store ChannelStore, log irc.Logger) *channelSaver {
+	saver := &channelSaver{store: store, log: log, signal: make(chan struct{}, 1)}
+	go saver.run()
+	return saver
+}
+
+// queue replaces the channels waiting to be saved
+func (s *channelSaver) queue(channels []Channel) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = channels
+	if s.queued {
+		return
+	}
+	s.queued = true
+	s.saving.Add(1)
+	s.signal <- struct{}{}
+}
+
+func (s *channelSaver) run() {
+	for range s.signal {
+		s.mutex.Lock()
+		channels := s.pending
+		s.queued = false
+		s.mutex.Unlock()
+		if err := s.store.Save(channels); err != nil {
+			s.log.Errorf("Unable to save channels: %s", err)
+		}
+		s.saving.Done()
+	}
+}
+
+// flush waits until the queued channels have been saved, or the context is done
+func (s *channelSaver) flush(ctx context.Context) error {
+	saved := make(chan struct{})
+	go func() {
+		s.saving.Wait()
+		close(saved)
+	}()
+	select {
+	case <-saved:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONChannelStore(t *testing.T) {
+	store := &JSONChannelStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	channels, err := store.Load()
+	if err != nil || len(channels) != 0 {
+		t.Fatalf("Load() = %v, %v, want no channels from a missing file", channels, err)
+	}
+	want := []Channel{{Name: "#spam"}, {Name: "#secret", Key: "hunter2"}}
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if got, err := store.Load(); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %v, %v, want %v", got, err, want)
+	}
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Save() mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestJSONChannelStore_Invalid(t *testing.T) {
+	store := &JSONChannelStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	if err := os.WriteFile(store.Path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(); err == nil {
+		t.Errorf("Load() error = nil, want error for invalid JSON")
+	}
+}
+
+type fakeChannelStore struct {
+	channels []Channel
+}
+
+func (s *fakeChannelStore) Load() ([]Channel, error) {
+	return s.channels, nil
+}
+
+func (s *fakeChannelStore) Save(channels []Channel) error {
+	s.channels = channels
+	return nil
+}
+
+func TestBot_SetChannelStore(t *testing.T) {
+	b := newTestBot(t)
+	b.SetRejoinPolicy(RejoinPolicy{Delay: time.Hour, MaxDelay: time.Hour})
+	b.configuredChannels = []Channel{{Name: "#config"}}
+	b.desiredChannels = []*desiredChannel{{Channel: Channel{Name: "#config"}}}
+	store := &fakeChannelStore{channels: []Channel{{Name: "#runtime", Key: "key"}, {Name: "#kicked"}, {Name: "#CONFIG"}}}
+	if err := b.SetChannelStore(store); err != nil {
+		t.Fatalf("SetChannelStore() error = %v", err)
+	}
+	_, desired := desiredNames(b)
+	if want := []string{"#config", "#runtime", "#kicked"}; !reflect.DeepEqual(desired, want) {
+		t.Errorf("desired channels = %v, want %v", desired, want)
+	}
+	b.handleKick(parseTestLine(t, ":op!o@host KICK #kicked bot"))
+	if err := b.flushChannels(context.Background()); err != nil {
+		t.Fatalf("flushChannels() error = %v", err)
+	}
+	if want := []Channel{{Name: "#runtime", Key: "key"}}; !reflect.DeepEqual(store.channels, want) {
+		t.Errorf("saved channels = %v, want %v", store.channels, want)
+	}
+}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"github.com/greboid/irc-bot/v5/bot"
+	"github.com/greboid/irc-bot/v5/config"
+	"github.com/greboid/irc-bot/v5/irc"
+	"github.com/greboid/irc-bot/v5/lifecycle"
 	"github.com/greboid/irc-bot/v5/rpc"
 	"github.com/kouhin/envflag"
 	"go.uber.org/zap"
@@ -18,20 +24,52 @@ import (
 //go:generate protoc --go-grpc_out=../../rpc -I ../../rpc plugin.proto
 
 var (
-	Server        = flag.String("server", "", "Which IRC server to connect to")
+	ConfigFile    = flag.String("config", "", "Path to a YAML config file, flags and environment variables override values in it")
+	Server        = flag.String("server", "", "Which IRC server to connect to, or a comma separated list to rotate between")
 	Password      = flag.String("password", "", "The server password, if required")
 	TLS           = flag.Bool("tls", true, "Connect with TLS?")
+	TLSCA         = flag.String("tls-ca", "", "PEM bundle of CAs trusted to sign the server's certificate, instead of the system roots")
+	TLSPin        = flag.String("tls-fingerprint", "", "SHA-256 fingerprint of the server's certificate to accept instead of verifying it")
+	TLSInsecure   = flag.Bool("tls-insecure", false, "Don't verify the server's certificate")
+	TLSCert       = flag.String("tls-cert", "", "PEM client certificate for SASL EXTERNAL or CertFP")
+	TLSKey        = flag.String("tls-key", "", "PEM key for the client certificate")
 	Nickname      = flag.String("nick", "", "Nickname to use")
 	Realname      = flag.String("realname", "", "'Real name' to use")
 	Channel       = flag.String("channel", "", "Channels to join on connect, comma separated list (with optional space separated key with each channel)")
 	Debug         = flag.Bool("debug", false, "Enable IRC debug output")
 	SASLAuth      = flag.Bool("sasl-auth", false, "Authenticate via SASL?")
+	SASLMech      = flag.String("sasl-mechanism", irc.SASLPlain, "SASL mechanism: PLAIN, or EXTERNAL to use the client certificate")
 	SASLUser      = flag.String("sasl-user", "", "SASL username")
 	SASLPass      = flag.String("sasl-pass", "", "SASL password")
-	RPCPort       = flag.Int("rpc-port", 8001, "gRPC server port")
-	PluginsString = flag.String("plugins", "", "Comma separated list of plugins, name=token")
-	FloodProfile  = flag.String("flood-profile", "restrictive", "Flood profile: restrictive, unlimited")
+	RPCPort       = flag.Int("rpc-port", 8001, "gRPC server port, 0 to only listen on the Unix socket")
+	RPCCert       = flag.String("rpc-cert", "", "Certificate for the gRPC server, generated and saved with rpc-key if neither exists")
+	RPCKey        = flag.String("rpc-key", "", "Key for the gRPC server's certificate")
+	RPCClientCA   = flag.String("rpc-client-ca", "", "PEM bundle of CAs trusted to sign plugins' client certificates")
+	RPCBind       = flag.String("rpc-bind", "", "Address the gRPC server listens on, all interfaces if empty")
+	RPCSocket     = flag.String("rpc-socket", "", "Path of a Unix socket the gRPC server also listens on")
+	RPCSocketMode = flag.String("rpc-socket-mode", "0660", "File mode of the gRPC Unix socket")
+	PluginsString = flag.String("plugins", "", "Comma separated list of plugins, name=token with optional =scopes")
+	FloodProfile  = flag.String("flood-profile", "restrictive", "Flood profile: restrictive, unlimited or one defined in the config file")
 	WebPort       = flag.Int("web-port", 8000, "Web port for http server")
+	QueueLimit    = flag.Int("queue-limit", 100, "Maximum lines each plugin may have waiting to be sent, 0 is unlimited")
+	HistorySize   = flag.Int("history-size", bot.DefaultHistorySize, "Messages kept per channel to replay to plugins, 0 to keep none")
+	EventBuffer   = flag.Int("event-buffer", rpc.DefaultEventBuffer, "Messages each plugin stream may have waiting before the overflow policy applies")
+	Overflow      = flag.String("overflow-policy", string(rpc.OverflowDropOldest), "What to do when a plugin stream falls behind: drop-oldest, drop-newest or disconnect")
+	CommandPrefix = flag.String("command-prefix", "!", "Prefix used to address commands to the bot")
+	RejoinOnKick  = flag.Bool("rejoin-on-kick", false, "Rejoin channels after being kicked")
+	RejoinDelay   = flag.Duration("rejoin-delay", bot.DefaultRejoinPolicy.Delay, "Delay before retrying a failed join, doubled after each attempt")
+	RejoinMax     = flag.Duration("rejoin-max-delay", bot.DefaultRejoinPolicy.MaxDelay, "Maximum delay between attempts to join a channel")
+	RejoinTries   = flag.Int("rejoin-max-attempts", 0, "Maximum attempts to join a channel, 0 retries forever")
+	ReconnDelay   = flag.Duration("reconnect-delay", irc.DefaultBackoff.Delay, "Delay before retrying a failed connection to the server")
+	ReconnMax     = flag.Duration("reconnect-max-delay", irc.DefaultBackoff.MaxDelay, "Maximum delay between attempts to connect to the server")
+	ReconnFactor  = flag.Float64("reconnect-multiplier", irc.DefaultBackoff.Multiplier, "Multiplier applied to the reconnect delay after each failed attempt")
+	ReconnJitter  = flag.Float64("reconnect-jitter", irc.DefaultBackoff.Jitter, "Fraction of the reconnect delay randomly taken off each attempt, between 0 and 1")
+	ReconnTries   = flag.Int("reconnect-max-attempts", 0, "Maximum attempts to connect to the server before exiting, 0 retries forever")
+	Membership    = flag.String("channel-membership", config.MembershipConfig, "Channels to join on startup: config, or persisted to also rejoin channels joined by plugins")
+	StateFile     = flag.String("state-file", "state.json", "File used to persist channels joined by plugins")
+	QuitMessage   = flag.String("quit-message", "", "Message sent when quitting IRC")
+	ShutdownWait  = flag.Duration("shutdown-timeout", lifecycle.DefaultTimeout, "Maximum time to wait for plugins, webhooks and IRC to finish when shutting down")
+	BufferDir     = flag.String("buffer-dir", "", "Directory used to buffer items for plugins while they are offline")
 )
 
 func main() {
@@ -39,7 +77,12 @@ func main() {
 		fmt.Printf("Unable to load config: %s", err.Error())
 		return
 	}
-	err, log := CreateLogger(*Debug)
+	conf, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Unable to load config: %s", err.Error())
+		return
+	}
+	err, log := CreateLogger(conf.Debug)
 	if err != nil {
 		fmt.Printf("Unable to create logger: %s", err.Error())
 		return
@@ -48,27 +91,242 @@ func main() {
 		err = log.Sync()
 	}()
 	log.Info("Starting bot")
-	if len(*Server) == 0 {
-		log.Fatal("Server is mandatory")
+	if err := conf.Validate(); err != nil {
+		log.Fatalf("Invalid config: %s", err)
 	}
-	rpcServer, err := rpc.NewGrpcServer(*RPCPort, *PluginsString, *WebPort, log)
+	floodProfile, err := conf.GetFloodProfile()
+	if err != nil {
+		log.Fatalf("Invalid config: %s", err)
+	}
+	rpcServer, err := rpc.NewGrpcServer(conf.RPCPort, conf.GetPlugins(), conf.WebPort, log)
 	if err != nil {
 		log.Fatalf("Unable to create GRPC server: %s", err)
 	}
-	ircBot := bot.NewBot(*Server, *Password, *Nickname, *Realname, *TLS, *SASLAuth, *SASLUser, *SASLPass, log,
-		*FloodProfile, *Channel)
+	if len(conf.RPCCert) > 0 {
+		if err := rpcServer.SetCertificate(conf.RPCCert, conf.RPCKey); err != nil {
+			log.Fatalf("Unable to load RPC certificate: %s", err)
+		}
+	}
+	rpcServer.SetBindAddress(conf.RPCBind)
+	if len(conf.RPCSocket) > 0 {
+		socketMode, err := conf.GetSocketMode()
+		if err != nil {
+			log.Fatalf("Invalid config: %s", err)
+		}
+		rpcServer.SetSocket(conf.RPCSocket, socketMode)
+	}
+	if len(conf.RPCClientCA) > 0 {
+		if err := rpcServer.SetClientCA(conf.RPCClientCA); err != nil {
+			log.Fatalf("Unable to load RPC client CA: %s", err)
+		}
+	}
+	servers := conf.GetServers()
+	ircBot := bot.NewBot(servers[0], conf.Password, conf.Nickname, conf.Realname, conf.TLS, conf.SASL.Enabled,
+		conf.SASL.Username, conf.SASL.Password, log, floodProfile, conf.GetChannels())
+	if err := ircBot.Connection.SetTLS(conf.GetTLSOptions()); err != nil {
+		log.Fatalf("Unable to configure TLS: %s", err)
+	}
+	if err := ircBot.Connection.SetSASLMechanism(conf.SASL.Mechanism); err != nil {
+		log.Fatalf("Invalid config: %s", err)
+	}
+	ircBot.Connection.SetQuitMessage(conf.QuitMessage)
+	ircBot.Connection.SetServers(servers)
+	ircBot.Connection.SetBackoff(conf.GetBackoff())
+	ircBot.SetCommandPrefix(conf.CommandPrefix)
+	ircBot.SetACL(conf.GetACL())
+	ircBot.SetRejoinPolicy(conf.GetRejoinPolicy())
+	ircBot.Connection.SetQueueLimit(conf.QueueLimit)
+	ircBot.SetHistorySize(conf.HistorySize)
+	if store := conf.GetChannelStore(); store != nil {
+		if err := ircBot.SetChannelStore(store); err != nil {
+			log.Fatalf("Unable to load channels: %s", err)
+		}
+	}
+	if len(conf.BufferDir) > 0 {
+		if err := rpcServer.SetBufferDir(conf.BufferDir); err != nil {
+			log.Fatalf("Unable to load plugin buffers: %s", err)
+		}
+	}
+	rpcServer.SetEventBuffer(conf.EventBuffer, rpc.OverflowPolicy(conf.Overflow))
+	reload := reloader(rpcServer, ircBot)
+	rpcServer.SetReloadHandler(reload)
+	manager := lifecycle.New(log, conf.StopTimeout)
+	manager.OnShutdown("rpc", rpcServer.Shutdown)
+	manager.OnShutdown("irc", ircBot.Stop)
 	go func() {
 		rpcServer.StartGRPC(ircBot)
 	}()
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
-	err = ircBot.Start(signals)
-	if err != nil {
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if err := reload(); err != nil {
+				log.Errorf("Unable to reload config: %s", err)
+			}
+		}
+	}()
+	ircErr := make(chan error, 1)
+	go func() {
+		ircErr <- ircBot.Start(manager.Context())
+		manager.Stop()
+	}()
+	if err := manager.Wait(); err != nil {
+		log.Fatalf("Unable to shut down cleanly: %s", err)
+	}
+	if err := <-ircErr; err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatal(err)
 	}
 	log.Info("Exiting")
 }
 
+// reloader returns a function that re-reads the config and applies the plugins and channels from it
+func reloader(rpcServer *rpc.GrpcServer, ircBot *bot.Bot) func() error {
+	mutex := sync.Mutex{}
+	return func() error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		conf, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if err := conf.Validate(); err != nil {
+			return err
+		}
+		rpcServer.SetPlugins(conf.GetPlugins())
+		rpcServer.SetEventBuffer(conf.EventBuffer, rpc.OverflowPolicy(conf.Overflow))
+		ircBot.SetChannels(conf.GetChannels())
+		ircBot.SetCommandPrefix(conf.CommandPrefix)
+		ircBot.SetACL(conf.GetACL())
+		ircBot.SetRejoinPolicy(conf.GetRejoinPolicy())
+		ircBot.Connection.SetBackoff(conf.GetBackoff())
+		ircBot.Connection.SetQueueLimit(conf.QueueLimit)
+		ircBot.SetHistorySize(conf.HistorySize)
+		return nil
+	}
+}
+
+// loadConfig builds the config from the flag defaults, then the config file if there is one, then any flags or
+// environment variables that have been set
+func loadConfig() (*config.Config, error) {
+	conf := &config.Config{}
+	if err := applyFlags(conf, flag.VisitAll); err != nil {
+		return nil, err
+	}
+	if len(*ConfigFile) == 0 {
+		return conf, nil
+	}
+	if err := config.Load(*ConfigFile, conf); err != nil {
+		return nil, err
+	}
+	if err := applyFlags(conf, flag.Visit); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func applyFlags(conf *config.Config, visit func(func(*flag.Flag))) (err error) {
+	visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "server":
+			conf.Server = *Server
+		case "password":
+			conf.Password = *Password
+		case "tls":
+			conf.TLS = *TLS
+		case "tls-ca":
+			conf.TLSCA = *TLSCA
+		case "tls-fingerprint":
+			conf.TLSPin = *TLSPin
+		case "tls-insecure":
+			conf.TLSInsecure = *TLSInsecure
+		case "tls-cert":
+			conf.TLSCert = *TLSCert
+		case "tls-key":
+			conf.TLSKey = *TLSKey
+		case "nick":
+			conf.Nickname = *Nickname
+		case "realname":
+			conf.Realname = *Realname
+		case "channel":
+			conf.SetChannels(bot.ParseChannels(*Channel))
+		case "debug":
+			conf.Debug = *Debug
+		case "sasl-auth":
+			conf.SASL.Enabled = *SASLAuth
+		case "sasl-mechanism":
+			conf.SASL.Mechanism = *SASLMech
+		case "sasl-user":
+			conf.SASL.Username = *SASLUser
+		case "sasl-pass":
+			conf.SASL.Password = *SASLPass
+		case "rpc-port":
+			conf.RPCPort = *RPCPort
+		case "rpc-cert":
+			conf.RPCCert = *RPCCert
+		case "rpc-key":
+			conf.RPCKey = *RPCKey
+		case "rpc-client-ca":
+			conf.RPCClientCA = *RPCClientCA
+		case "rpc-bind":
+			conf.RPCBind = *RPCBind
+		case "rpc-socket":
+			conf.RPCSocket = *RPCSocket
+		case "rpc-socket-mode":
+			conf.RPCSocketMode = *RPCSocketMode
+		case "plugins":
+			plugins, parseErr := rpc.ParsePluginString(*PluginsString)
+			if parseErr != nil {
+				err = fmt.Errorf("plugins: %w", parseErr)
+				return
+			}
+			conf.SetPlugins(plugins)
+		case "flood-profile":
+			conf.FloodProfile = *FloodProfile
+		case "queue-limit":
+			conf.QueueLimit = *QueueLimit
+		case "history-size":
+			conf.HistorySize = *HistorySize
+		case "event-buffer":
+			conf.EventBuffer = *EventBuffer
+		case "overflow-policy":
+			conf.Overflow = *Overflow
+		case "web-port":
+			conf.WebPort = *WebPort
+		case "command-prefix":
+			conf.CommandPrefix = *CommandPrefix
+		case "rejoin-on-kick":
+			conf.Rejoin.Kick = *RejoinOnKick
+		case "rejoin-delay":
+			conf.Rejoin.Delay = *RejoinDelay
+		case "rejoin-max-delay":
+			conf.Rejoin.MaxDelay = *RejoinMax
+		case "rejoin-max-attempts":
+			conf.Rejoin.MaxAttempts = *RejoinTries
+		case "reconnect-delay":
+			conf.Reconnect.Delay = *ReconnDelay
+		case "reconnect-max-delay":
+			conf.Reconnect.MaxDelay = *ReconnMax
+		case "reconnect-multiplier":
+			conf.Reconnect.Multiplier = *ReconnFactor
+		case "reconnect-jitter":
+			conf.Reconnect.Jitter = *ReconnJitter
+		case "reconnect-max-attempts":
+			conf.Reconnect.MaxAttempts = *ReconnTries
+		case "channel-membership":
+			conf.Membership = *Membership
+		case "state-file":
+			conf.StateFile = *StateFile
+		case "quit-message":
+			conf.QuitMessage = *QuitMessage
+		case "shutdown-timeout":
+			conf.StopTimeout = *ShutdownWait
+		case "buffer-dir":
+			conf.BufferDir = *BufferDir
+		}
+	})
+	return
+}
+
 func CreateLogger(debug bool) (error, *zap.SugaredLogger) {
 	zapConfig := zap.NewDevelopmentConfig()
 	zapConfig.DisableCaller = !debug
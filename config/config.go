@@ -0,0 +1,468 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/greboid/irc-bot/v5/bot"
+	"github.com/greboid/irc-bot/v5/irc"
+	"github.com/greboid/irc-bot/v5/rpc"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// MembershipConfig only joins the configured channels on startup
+	MembershipConfig = "config"
+	// MembershipPersisted also rejoins the channels plugins had joined before the bot was restarted
+	MembershipPersisted = "persisted"
+)
+
+// Config describes everything needed to run the bot
+type Config struct {
+	Server        string                  `yaml:"server"`
+	Password      string                  `yaml:"password"`
+	TLS           bool                    `yaml:"tls"`
+	TLSCA         string                  `yaml:"tls-ca"`
+	TLSPin        string                  `yaml:"tls-fingerprint"`
+	TLSInsecure   bool                    `yaml:"tls-insecure"`
+	TLSCert       string                  `yaml:"tls-cert"`
+	TLSKey        string                  `yaml:"tls-key"`
+	Nickname      string                  `yaml:"nick"`
+	Realname      string                  `yaml:"realname"`
+	Debug         bool                    `yaml:"debug"`
+	SASL          SASL                    `yaml:"sasl"`
+	Channels      []Channel               `yaml:"channels"`
+	Plugins       []Plugin                `yaml:"plugins"`
+	FloodProfile  string                  `yaml:"flood-profile"`
+	FloodProfiles map[string]FloodProfile `yaml:"flood-profiles"`
+	QueueLimit    int                     `yaml:"queue-limit"`
+	HistorySize   int                     `yaml:"history-size"`
+	EventBuffer   int                     `yaml:"event-buffer"`
+	Overflow      string                  `yaml:"overflow-policy"`
+	CommandPrefix string                  `yaml:"command-prefix"`
+	ACL           map[string]Permission   `yaml:"acl"`
+	Rejoin        Rejoin                  `yaml:"rejoin"`
+	Reconnect     Reconnect               `yaml:"reconnect"`
+	Membership    string                  `yaml:"channel-membership"`
+	StateFile     string                  `yaml:"state-file"`
+	BufferDir     string                  `yaml:"buffer-dir"`
+	QuitMessage   string                  `yaml:"quit-message"`
+	StopTimeout   time.Duration           `yaml:"shutdown-timeout"`
+	RPCPort       int                     `yaml:"rpc-port"`
+	RPCCert       string                  `yaml:"rpc-cert"`
+	RPCKey        string                  `yaml:"rpc-key"`
+	RPCClientCA   string                  `yaml:"rpc-client-ca"`
+	RPCBind       string                  `yaml:"rpc-bind"`
+	RPCSocket     string                  `yaml:"rpc-socket"`
+	RPCSocketMode string                  `yaml:"rpc-socket-mode"`
+	WebPort       int                     `yaml:"web-port"`
+}
+
+// SASL describes the credentials used to authenticate with SASL, the EXTERNAL mechanism uses the TLS client
+// certificate instead of a username and password
+type SASL struct {
+	Enabled   bool   `yaml:"enabled"`
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"user"`
+	Password  string `yaml:"pass"`
+}
+
+// Channel is a channel to join on connect, with an optional key
+type Channel struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+// Plugin describes a plugin allowed to connect, if no permissions are given it may call every RPC.  Buffer is how
+// many items are kept on disk for the plugin while it is offline, zero disables buffering.  Identity is the common
+// name of a client certificate the plugin may authenticate with instead of a token, and UID the user it may connect
+// to the Unix socket as instead.
+type Plugin struct {
+	Name        string              `yaml:"name"`
+	Token       string              `yaml:"token"`
+	Identity    string              `yaml:"identity"`
+	UID         *int                `yaml:"uid"`
+	Permissions map[string][]string `yaml:"permissions"`
+	Buffer      int                 `yaml:"buffer"`
+}
+
+// Permission describes who is granted a permission, by services account, hostmask or minimum channel mode
+type Permission struct {
+	Accounts  []string `yaml:"accounts"`
+	Hostmasks []string `yaml:"hostmasks"`
+	Mode      string   `yaml:"mode"`
+}
+
+// Rejoin describes how the bot gets back into channels after being kicked or failing to join, max-attempts of zero
+// retries forever
+type Rejoin struct {
+	Kick        bool          `yaml:"kick"`
+	Delay       time.Duration `yaml:"delay"`
+	MaxDelay    time.Duration `yaml:"max-delay"`
+	MaxAttempts int           `yaml:"max-attempts"`
+}
+
+// Reconnect describes how long the bot waits between attempts to connect to the server, the delay is multiplied by
+// multiplier after each failed attempt and reduced by up to jitter (a fraction of it) at random.  Max-attempts of
+// zero retries forever.
+type Reconnect struct {
+	Delay       time.Duration `yaml:"delay"`
+	MaxDelay    time.Duration `yaml:"max-delay"`
+	Multiplier  float64       `yaml:"multiplier"`
+	Jitter      float64       `yaml:"jitter"`
+	MaxAttempts int           `yaml:"max-attempts"`
+}
+
+// FloodProfile describes a user defined flood profile
+type FloodProfile struct {
+	Burst         int           `yaml:"burst"`
+	Interval      time.Duration `yaml:"interval"`
+	BytesPerToken int           `yaml:"bytes-per-token"`
+}
+
+// ValidationError describes a problem with the value of a key in the config
+type ValidationError struct {
+	Key     string
+	Message string
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", v.Key, v.Message)
+}
+
+// Load reads a YAML config file into the given config, keys not present in the file are left unchanged
+func Load(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the config is usable, returning an error naming the first offending key
+func (c *Config) Validate() error {
+	if len(c.Server) == 0 {
+		return &ValidationError{Key: "server", Message: "must be set"}
+	}
+	for _, server := range c.GetServers() {
+		if len(server) == 0 || strings.ContainsAny(server, " \r\n") {
+			return &ValidationError{Key: "server", Message: "must be a comma separated list of servers"}
+		}
+	}
+	if (len(c.TLSCert) > 0) != (len(c.TLSKey) > 0) {
+		return &ValidationError{Key: "tls-cert", Message: "tls-cert and tls-key must be set together"}
+	}
+	if len(c.TLSPin) > 0 {
+		if _, err := irc.ParseFingerprint(c.TLSPin); err != nil {
+			return &ValidationError{Key: "tls-fingerprint", Message: err.Error()}
+		}
+	}
+	if !c.TLS && (len(c.TLSCA) > 0 || len(c.TLSPin) > 0 || c.TLSInsecure || len(c.TLSCert) > 0) {
+		return &ValidationError{Key: "tls", Message: "must be enabled to use the other TLS settings"}
+	}
+	switch strings.ToUpper(c.SASL.Mechanism) {
+	case "", irc.SASLPlain:
+		if c.SASL.Enabled && (len(c.SASL.Username) == 0 || len(c.SASL.Password) == 0) {
+			return &ValidationError{Key: "sasl", Message: "user and pass must be set when enabled"}
+		}
+	case irc.SASLExternal:
+		if c.SASL.Enabled && len(c.TLSCert) == 0 {
+			return &ValidationError{Key: "sasl.mechanism", Message: "tls-cert must be set to use EXTERNAL"}
+		}
+	default:
+		return &ValidationError{Key: "sasl.mechanism", Message: "must be PLAIN or EXTERNAL"}
+	}
+	for index, channel := range c.Channels {
+		if len(channel.Name) == 0 {
+			return &ValidationError{Key: fmt.Sprintf("channels[%d].name", index), Message: "must be set"}
+		}
+		if strings.ContainsAny(channel.Name, " ,") || strings.ContainsAny(channel.Key, " ,") {
+			return &ValidationError{Key: fmt.Sprintf("channels[%d]", index), Message: "may not contain spaces or commas"}
+		}
+	}
+	names := map[string]bool{}
+	tokens := map[string]bool{}
+	identities := map[string]bool{}
+	uids := map[int]bool{}
+	for index, plugin := range c.Plugins {
+		if len(plugin.Name) == 0 {
+			return &ValidationError{Key: fmt.Sprintf("plugins[%d].name", index), Message: "must be set"}
+		}
+		if names[plugin.Name] {
+			return &ValidationError{Key: fmt.Sprintf("plugins[%d].name", index), Message: "duplicate plugin name"}
+		}
+		names[plugin.Name] = true
+		if len(plugin.Token) == 0 && len(plugin.Identity) == 0 && plugin.UID == nil {
+			return &ValidationError{Key: fmt.Sprintf("plugins[%d].token", index), Message: "must be set"}
+		}
+		if len(plugin.Token) > 0 && tokens[plugin.Token] {
+			return &ValidationError{Key: fmt.Sprintf("plugins[%d].token", index), Message: "duplicate plugin token"}
+		}
+		tokens[plugin.Token] = true
+		if len(plugin.Identity) > 0 {
+			if identities[plugin.Identity] {
+				return &ValidationError{Key: fmt.Sprintf("plugins[%d].identity", index), Message: "duplicate plugin identity"}
+			}
+			identities[plugin.Identity] = true
+			if len(c.RPCClientCA) == 0 {
+				return &ValidationError{Key: "rpc-client-ca", Message: "must be set when plugins have an identity"}
+			}
+		}
+		if plugin.UID != nil {
+			if *plugin.UID < 0 {
+				return &ValidationError{Key: fmt.Sprintf("plugins[%d].uid", index), Message: "may not be negative"}
+			}
+			if uids[*plugin.UID] {
+				return &ValidationError{Key: fmt.Sprintf("plugins[%d].uid", index), Message: "duplicate plugin uid"}
+			}
+			uids[*plugin.UID] = true
+			if len(c.RPCSocket) == 0 {
+				return &ValidationError{Key: "rpc-socket", Message: "must be set when plugins have a uid"}
+			}
+		}
+		for scope := range plugin.Permissions {
+			if !rpc.Scope(scope).Valid() {
+				return &ValidationError{Key: fmt.Sprintf("plugins[%d].permissions.%s", index, scope), Message: "unknown scope"}
+			}
+		}
+		if plugin.Buffer < 0 {
+			return &ValidationError{Key: fmt.Sprintf("plugins[%d].buffer", index), Message: "may not be negative"}
+		}
+		if plugin.Buffer > 0 && len(c.BufferDir) == 0 {
+			return &ValidationError{Key: "buffer-dir", Message: "must be set when plugins have a buffer"}
+		}
+	}
+	for name, profile := range c.FloodProfiles {
+		if profile.Interval < 0 {
+			return &ValidationError{Key: fmt.Sprintf("flood-profiles.%s.interval", name), Message: "may not be negative"}
+		}
+		if profile.Interval > 0 && profile.Burst < 1 {
+			return &ValidationError{Key: fmt.Sprintf("flood-profiles.%s.burst", name), Message: "must be at least 1"}
+		}
+		if profile.BytesPerToken < 0 {
+			return &ValidationError{Key: fmt.Sprintf("flood-profiles.%s.bytes-per-token", name), Message: "may not be negative"}
+		}
+	}
+	if _, err := c.GetFloodProfile(); err != nil {
+		return &ValidationError{Key: "flood-profile", Message: err.Error()}
+	}
+	if c.QueueLimit < 0 {
+		return &ValidationError{Key: "queue-limit", Message: "may not be negative"}
+	}
+	if c.HistorySize < 0 {
+		return &ValidationError{Key: "history-size", Message: "may not be negative"}
+	}
+	if c.EventBuffer < 0 {
+		return &ValidationError{Key: "event-buffer", Message: "may not be negative"}
+	}
+	if len(c.Overflow) > 0 && !rpc.OverflowPolicy(c.Overflow).Valid() {
+		return &ValidationError{Key: "overflow-policy", Message: "must be drop-oldest, drop-newest or disconnect"}
+	}
+	for name, permission := range c.ACL {
+		if len(name) == 0 || strings.ContainsAny(name, " ,") {
+			return &ValidationError{Key: fmt.Sprintf("acl.%s", name), Message: "invalid permission name"}
+		}
+		if len(permission.Mode) > 1 {
+			return &ValidationError{Key: fmt.Sprintf("acl.%s.mode", name), Message: "must be a single mode letter"}
+		}
+	}
+	if c.Rejoin.Delay <= 0 {
+		return &ValidationError{Key: "rejoin.delay", Message: "must be positive"}
+	}
+	if c.Rejoin.MaxDelay < c.Rejoin.Delay {
+		return &ValidationError{Key: "rejoin.max-delay", Message: "may not be less than delay"}
+	}
+	if c.Rejoin.MaxAttempts < 0 {
+		return &ValidationError{Key: "rejoin.max-attempts", Message: "may not be negative"}
+	}
+	if c.Reconnect.Delay <= 0 {
+		return &ValidationError{Key: "reconnect.delay", Message: "must be positive"}
+	}
+	if c.Reconnect.MaxDelay < c.Reconnect.Delay {
+		return &ValidationError{Key: "reconnect.max-delay", Message: "may not be less than delay"}
+	}
+	if c.Reconnect.Multiplier < 1 {
+		return &ValidationError{Key: "reconnect.multiplier", Message: "may not be less than 1"}
+	}
+	if c.Reconnect.Jitter < 0 || c.Reconnect.Jitter > 1 {
+		return &ValidationError{Key: "reconnect.jitter", Message: "must be between 0 and 1"}
+	}
+	if c.Reconnect.MaxAttempts < 0 {
+		return &ValidationError{Key: "reconnect.max-attempts", Message: "may not be negative"}
+	}
+	switch c.Membership {
+	case MembershipConfig:
+	case MembershipPersisted:
+		if len(c.StateFile) == 0 {
+			return &ValidationError{Key: "state-file", Message: "must be set when channel membership is persisted"}
+		}
+	default:
+		return &ValidationError{Key: "channel-membership", Message: "must be config or persisted"}
+	}
+	if strings.ContainsAny(c.QuitMessage, "\r\n\x00") {
+		return &ValidationError{Key: "quit-message", Message: "may not contain line breaks"}
+	}
+	if c.StopTimeout < 0 {
+		return &ValidationError{Key: "shutdown-timeout", Message: "may not be negative"}
+	}
+	if strings.ContainsAny(c.CommandPrefix, " \r\n") {
+		return &ValidationError{Key: "command-prefix", Message: "may not contain whitespace"}
+	}
+	if (c.RPCPort != 0 || len(c.RPCSocket) == 0) && (c.RPCPort < 1 || c.RPCPort > 65535) {
+		return &ValidationError{Key: "rpc-port", Message: "must be between 1 and 65535, or 0 if rpc-socket is set"}
+	}
+	if _, err := c.GetSocketMode(); err != nil {
+		return &ValidationError{Key: "rpc-socket-mode", Message: err.Error()}
+	}
+	if (len(c.RPCCert) > 0) != (len(c.RPCKey) > 0) {
+		return &ValidationError{Key: "rpc-cert", Message: "rpc-cert and rpc-key must be set together"}
+	}
+	if c.WebPort < 1 || c.WebPort > 65535 {
+		return &ValidationError{Key: "web-port", Message: "must be between 1 and 65535"}
+	}
+	return nil
+}
+
+// GetFloodProfile returns the selected flood profile, user defined profiles take precedence over built-in ones
+func (c *Config) GetFloodProfile() (irc.FloodProfile, error) {
+	if profile, ok := c.FloodProfiles[c.FloodProfile]; ok {
+		return irc.FloodProfile{Burst: profile.Burst, Interval: profile.Interval, BytesPerToken: profile.BytesPerToken}, nil
+	}
+	if profile, ok := irc.FloodProfiles[c.FloodProfile]; ok {
+		return profile, nil
+	}
+	return irc.FloodProfile{}, fmt.Errorf("unknown profile: %s", c.FloodProfile)
+}
+
+// GetServers returns the servers to connect to, the bot moves on to the next one each time connecting fails
+func (c *Config) GetServers() []string {
+	servers := strings.Split(c.Server, ",")
+	for index := range servers {
+		servers[index] = strings.TrimSpace(servers[index])
+	}
+	return servers
+}
+
+// GetBackoff returns how long the bot waits between attempts to connect
+func (c *Config) GetBackoff() irc.Backoff {
+	return irc.Backoff{
+		Delay:       c.Reconnect.Delay,
+		MaxDelay:    c.Reconnect.MaxDelay,
+		Multiplier:  c.Reconnect.Multiplier,
+		Jitter:      c.Reconnect.Jitter,
+		MaxAttempts: c.Reconnect.MaxAttempts,
+	}
+}
+
+// GetTLSOptions returns how the server's certificate is verified and the client certificate to present
+func (c *Config) GetTLSOptions() irc.TLSOptions {
+	return irc.TLSOptions{
+		CAFile:      c.TLSCA,
+		Fingerprint: c.TLSPin,
+		Insecure:    c.TLSInsecure,
+		CertFile:    c.TLSCert,
+		KeyFile:     c.TLSKey,
+	}
+}
+
+// GetSocketMode returns the file mode of the RPC socket
+func (c *Config) GetSocketMode() (os.FileMode, error) {
+	if len(c.RPCSocketMode) == 0 {
+		return rpc.DefaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(c.RPCSocketMode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("must be an octal file mode")
+	}
+	return os.FileMode(mode), nil
+}
+
+// GetChannels returns the channels to join on connect
+func (c *Config) GetChannels() []bot.Channel {
+	channels := make([]bot.Channel, 0, len(c.Channels))
+	for _, channel := range c.Channels {
+		channels = append(channels, bot.Channel{Name: channel.Name, Key: channel.Key})
+	}
+	return channels
+}
+
+// SetChannels replaces the channels to join on connect
+func (c *Config) SetChannels(channels []bot.Channel) {
+	c.Channels = make([]Channel, 0, len(channels))
+	for _, channel := range channels {
+		c.Channels = append(c.Channels, Channel{Name: channel.Name, Key: channel.Key})
+	}
+}
+
+// GetACL returns the permissions granted to users
+func (c *Config) GetACL() bot.ACL {
+	acl := bot.ACL{}
+	for name, permission := range c.ACL {
+		acl[name] = bot.Permission{
+			Accounts:  permission.Accounts,
+			Hostmasks: permission.Hostmasks,
+			Mode:      permission.Mode,
+		}
+	}
+	return acl
+}
+
+// GetRejoinPolicy returns how the bot rejoins channels
+func (c *Config) GetRejoinPolicy() bot.RejoinPolicy {
+	return bot.RejoinPolicy{
+		Kick:        c.Rejoin.Kick,
+		Delay:       c.Rejoin.Delay,
+		MaxDelay:    c.Rejoin.MaxDelay,
+		MaxAttempts: c.Rejoin.MaxAttempts,
+	}
+}
+
+// GetChannelStore returns the store used to persist channels joined at runtime, or nil if membership is config only
+func (c *Config) GetChannelStore() bot.ChannelStore {
+	if c.Membership != MembershipPersisted {
+		return nil
+	}
+	return &bot.JSONChannelStore{Path: c.StateFile}
+}
+
+// GetPlugins returns the plugins allowed to connect
+func (c *Config) GetPlugins() []rpc.Plugin {
+	plugins := make([]rpc.Plugin, 0, len(c.Plugins))
+	for _, plugin := range c.Plugins {
+		rpcPlugin := rpc.Plugin{Name: plugin.Name, Token: plugin.Token, Identity: plugin.Identity, UID: plugin.UID, Buffer: plugin.Buffer}
+		if plugin.Permissions != nil {
+			rpcPlugin.Permissions = rpc.Permissions{}
+			for scope, targets := range plugin.Permissions {
+				rpcPlugin.Permissions[rpc.Scope(scope)] = targets
+			}
+		}
+		plugins = append(plugins, rpcPlugin)
+	}
+	return plugins
+}
+
+// SetPlugins replaces the plugins allowed to connect
+func (c *Config) SetPlugins(plugins []rpc.Plugin) {
+	c.Plugins = make([]Plugin, 0, len(plugins))
+	for _, plugin := range plugins {
+		configPlugin := Plugin{Name: plugin.Name, Token: plugin.Token, Identity: plugin.Identity, UID: plugin.UID, Buffer: plugin.Buffer}
+		if plugin.Permissions != nil {
+			configPlugin.Permissions = map[string][]string{}
+			for scope, targets := range plugin.Permissions {
+				configPlugin.Permissions[string(scope)] = targets
+			}
+		}
+		c.Plugins = append(c.Plugins, configPlugin)
+	}
+}
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/greboid/irc-bot/v5/irc"
+	"github.com/greboid/irc-bot/v5/rpc"
+)
+
+func validConfig() *Config {
+	return &Config{
+		Server:       "irc.example.tld:6697",
+		FloodProfile: "restrictive",
+		Rejoin:       Rejoin{Delay: 30 * time.Second, MaxDelay: 10 * time.Minute},
+		Reconnect:    Reconnect{Delay: 5 * time.Second, MaxDelay: 5 * time.Minute, Multiplier: 2, Jitter: 0.2},
+		Membership:   MembershipConfig,
+		RPCPort:      8001,
+		WebPort:      8000,
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := os.WriteFile(path, []byte(`
+server: irc.example.tld:6697
+nick: bot
+channels:
+  - name: "#spam"
+  - name: "#secret"
+    key: hunter2
+plugins:
+  - name: webhook
+    token: abc
+    permissions:
+      send: ["#spam"]
+      http: []
+  - name: github
+    token: def
+flood-profile: slow
+flood-profiles:
+  slow:
+    burst: 1
+    interval: 5s
+    bytes-per-token: 100
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := validConfig()
+	if err := Load(path, conf); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if conf.Nickname != "bot" || conf.RPCPort != 8001 {
+		t.Errorf("Load() did not merge config, got %#+v", conf)
+	}
+	wantPlugins := []rpc.Plugin{
+		{Name: "webhook", Token: "abc", Permissions: rpc.Permissions{rpc.ScopeSend: {"#spam"}, rpc.ScopeHTTP: {}}},
+		{Name: "github", Token: "def"},
+	}
+	if got := conf.GetPlugins(); !reflect.DeepEqual(got, wantPlugins) {
+		t.Errorf("GetPlugins() = %#+v, want %#+v", got, wantPlugins)
+	}
+	if got := conf.GetServers(); !reflect.DeepEqual(got, []string{"irc.example.tld:6697"}) {
+		t.Errorf("GetServers() = %v", got)
+	}
+	if got := conf.GetChannels(); len(got) != 2 || got[1].Key != "hunter2" {
+		t.Errorf("GetChannels() = %#+v", got)
+	}
+	wantProfile := irc.FloodProfile{Burst: 1, Interval: 5 * time.Second, BytesPerToken: 100}
+	if got, _ := conf.GetFloodProfile(); got != wantProfile {
+		t.Errorf("GetFloodProfile() = %#+v, want %#+v", got, wantProfile)
+	}
+}
+
+func TestLoad_UnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("server: irc.example.tld\nservre: typo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := Load(path, validConfig())
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Load() error = %v, want error on line 2", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Config)
+		wantKey string
+	}{
+		{
+			name:    "valid",
+			modify:  func(*Config) {},
+			wantKey: "",
+		},
+		{
+			name:    "missing server",
+			modify:  func(c *Config) { c.Server = "" },
+			wantKey: "server",
+		},
+		{
+			name:    "server list",
+			modify:  func(c *Config) { c.Server = "irc.example.tld:6697, irc2.example.tld:6697" },
+			wantKey: "",
+		},
+		{
+			name:    "server list with empty entry",
+			modify:  func(c *Config) { c.Server = "irc.example.tld:6697,,irc2.example.tld:6697" },
+			wantKey: "server",
+		},
+		{
+			name: "sasl external with client certificate",
+			modify: func(c *Config) {
+				c.TLS, c.TLSCert, c.TLSKey = true, "cert.pem", "key.pem"
+				c.SASL = SASL{Enabled: true, Mechanism: "external"}
+			},
+			wantKey: "",
+		},
+		{
+			name:    "sasl external without client certificate",
+			modify:  func(c *Config) { c.TLS, c.SASL = true, SASL{Enabled: true, Mechanism: "EXTERNAL"} },
+			wantKey: "sasl.mechanism",
+		},
+		{
+			name:    "sasl plain without password",
+			modify:  func(c *Config) { c.SASL = SASL{Enabled: true, Mechanism: "PLAIN", Username: "bot"} },
+			wantKey: "sasl",
+		},
+		{
+			name:    "unknown sasl mechanism",
+			modify:  func(c *Config) { c.SASL = SASL{Enabled: true, Mechanism: "SCRAM-SHA-256"} },
+			wantKey: "sasl.mechanism",
+		},
+		{
+			name:    "client certificate without key",
+			modify:  func(c *Config) { c.TLS, c.TLSCert = true, "cert.pem" },
+			wantKey: "tls-cert",
+		},
+		{
+			name:    "invalid fingerprint",
+			modify:  func(c *Config) { c.TLS, c.TLSPin = true, "abc" },
+			wantKey: "tls-fingerprint",
+		},
+		{
+			name:    "tls settings without tls",
+			modify:  func(c *Config) { c.TLSCA = "ca.pem" },
+			wantKey: "tls",
+		},
+		{
+			name:    "unnamed channel",
+			modify:  func(c *Config) { c.Channels = []Channel{{Name: "#test"}, {Key: "key"}} },
+			wantKey: "channels[1].name",
+		},
+		{
+			name:    "plugin without token",
+			modify:  func(c *Config) { c.Plugins = []Plugin{{Name: "webhook"}} },
+			wantKey: "plugins[0].token",
+		},
+		{
+			name: "plugin with unknown scope",
+			modify: func(c *Config) {
+				c.Plugins = []Plugin{{Name: "webhook", Token: "abc", Permissions: map[string][]string{"superuser": nil}}}
+			},
+			wantKey: "plugins[0].permissions.superuser",
+		},
+		{
+			name: "plugin with identity instead of token",
+			modify: func(c *Config) {
+				c.RPCClientCA = "ca.pem"
+				c.Plugins = []Plugin{{Name: "webhook", Identity: "webhook"}, {Name: "github", Identity: "github"}}
+			},
+			wantKey: "",
+		},
+		{
+			name:    "plugin identity without client ca",
+			modify:  func(c *Config) { c.Plugins = []Plugin{{Name: "webhook", Identity: "webhook"}} },
+			wantKey: "rpc-client-ca",
+		},
+		{
+			name: "duplicate plugin identity",
+			modify: func(c *Config) {
+				c.RPCClientCA = "ca.pem"
+				c.Plugins = []Plugin{{Name: "webhook", Identity: "plugin"}, {Name: "github", Identity: "plugin"}}
+			},
+			wantKey: "plugins[1].identity",
+		},
+		{
+			name: "plugin with uid and socket only",
+			modify: func(c *Config) {
+				uid := 1000
+				c.RPCPort, c.RPCSocket, c.RPCSocketMode = 0, "/run/bot/rpc.sock", "0600"
+				c.Plugins = []Plugin{{Name: "webhook", UID: &uid}}
+			},
+			wantKey: "",
+		},
+		{
+			name: "plugin uid without socket",
+			modify: func(c *Config) {
+				uid := 1000
+				c.Plugins = []Plugin{{Name: "webhook", UID: &uid}}
+			},
+			wantKey: "rpc-socket",
+		},
+		{
+			name:    "no rpc port without socket",
+			modify:  func(c *Config) { c.RPCPort = 0 },
+			wantKey: "rpc-port",
+		},
+		{
+			name:    "invalid socket mode",
+			modify:  func(c *Config) { c.RPCSocket, c.RPCSocketMode = "/run/bot/rpc.sock", "rw-rw----" },
+			wantKey: "rpc-socket-mode",
+		},
+		{
+			name:    "rpc certificate without key",
+			modify:  func(c *Config) { c.RPCCert = "rpc.pem" },
+			wantKey: "rpc-cert",
+		},
+		{
+			name:    "plugin with negative buffer",
+			modify:  func(c *Config) { c.Plugins = []Plugin{{Name: "webhook", Token: "abc", Buffer: -1}} },
+			wantKey: "plugins[0].buffer",
+		},
+		{
+			name:    "plugin buffer without directory",
+			modify:  func(c *Config) { c.Plugins = []Plugin{{Name: "webhook", Token: "abc", Buffer: 100}} },
+			wantKey: "buffer-dir",
+		},
+		{
+			name:    "unknown flood profile",
+			modify:  func(c *Config) { c.FloodProfile = "fast" },
+			wantKey: "flood-profile",
+		},
+		{
+			name: "custom flood profile without burst",
+			modify: func(c *Config) {
+				c.FloodProfiles = map[string]FloodProfile{"fast": {Interval: time.Second}}
+			},
+			wantKey: "flood-profiles.fast.burst",
+		},
+		{
+			name:    "rejoin max delay less than delay",
+			modify:  func(c *Config) { c.Rejoin.MaxDelay = time.Second },
+			wantKey: "rejoin.max-delay",
+		},
+		{
+			name:    "reconnect max delay less than delay",
+			modify:  func(c *Config) { c.Reconnect.MaxDelay = time.Second },
+			wantKey: "reconnect.max-delay",
+		},
+		{
+			name:    "reconnect multiplier less than one",
+			modify:  func(c *Config) { c.Reconnect.Multiplier = 0.5 },
+			wantKey: "reconnect.multiplier",
+		},
+		{
+			name:    "reconnect jitter more than one",
+			modify:  func(c *Config) { c.Reconnect.Jitter = 1.5 },
+			wantKey: "reconnect.jitter",
+		},
+		{
+			name:    "negative reconnect attempts",
+			modify:  func(c *Config) { c.Reconnect.MaxAttempts = -1 },
+			wantKey: "reconnect.max-attempts",
+		},
+		{
+			name:    "negative queue limit",
+			modify:  func(c *Config) { c.QueueLimit = -1 },
+			wantKey: "queue-limit",
+		},
+		{
+			name:    "negative history size",
+			modify:  func(c *Config) { c.HistorySize = -1 },
+			wantKey: "history-size",
+		},
+		{
+			name:    "unknown overflow policy",
+			modify:  func(c *Config) { c.Overflow = "block" },
+			wantKey: "overflow-policy",
+		},
+		{
+			name:    "quit message with line break",
+			modify:  func(c *Config) { c.QuitMessage = "bye\r\nPRIVMSG #test :hi" },
+			wantKey: "quit-message",
+		},
+		{
+			name:    "negative shutdown timeout",
+			modify:  func(c *Config) { c.StopTimeout = -time.Second },
+			wantKey: "shutdown-timeout",
+		},
+		{
+			name:    "persisted membership without state file",
+			modify:  func(c *Config) { c.Membership = MembershipPersisted },
+			wantKey: "state-file",
+		},
+		{
+			name:    "unknown membership",
+			modify:  func(c *Config) { c.Membership = "sometimes" },
+			wantKey: "channel-membership",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := validConfig()
+			tt.modify(conf)
+			err := conf.Validate()
+			if tt.wantKey == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			validationErr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("Validate() error = %v, want ValidationError", err)
+			}
+			if validationErr.Key != tt.wantKey {
+				t.Errorf("Validate() key = %s, want %s", validationErr.Key, tt.wantKey)
+			}
+		})
+	}
+}
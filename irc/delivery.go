@@ -0,0 +1,261 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// confirmTimeout is how long to wait for the server to echo a message before giving up on confirming it
+const confirmTimeout = 10 * time.Second
+
+// ErrRejected matches errors returned when the server refuses to deliver a message
+var ErrRejected = errors.New("message rejected")
+
+// RejectedError is returned when the server refuses to deliver a message, Reply is the numeric or FAIL it replied
+// with
+type RejectedError struct {
+	Reply   string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrRejected, e.Reply, e.Message)
+}
+
+func (e *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// Delivery describes what the server did with a message.  Messages are only confirmed if the server supports
+// echo-message or labeled-response, and echoed or acknowledged every line.  MsgIDs holds the IDs the server gave
+// the lines, if it supports message-ids.
+type Delivery struct {
+	Confirmed bool
+	MsgIDs    []string
+	lines     int
+}
+
+func (d *Delivery) add(result deliveryResult) {
+	d.Confirmed = result.confirmed && (d.lines == 0 || d.Confirmed)
+	d.lines++
+	if len(result.msgid) > 0 {
+		d.MsgIDs = append(d.MsgIDs, result.msgid)
+	}
+}
+
+type deliveryResult struct {
+	confirmed bool
+	msgid     string
+	err       error
+}
+
+// pendingEcho is a line waiting to be echoed back by a server that supports echo-message but not labeled-response
+type pendingEcho struct {
+	command string
+	target  string
+	text    string
+	result  chan deliveryResult
+}
+
+// echoTracker matches lines echoed back by the server to the lines waiting for them, oldest first
+type echoTracker struct {
+	mutex   sync.Mutex
+	pending []*pendingEcho
+}
+
+func (t *echoTracker) add(command string, target string, text string) *pendingEcho {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	pending := &pendingEcho{command: command, target: target, text: text, result: make(chan deliveryResult, 1)}
+	t.pending = append(t.pending, pending)
+	return pending
+}
+
+func (t *echoTracker) remove(pending *pendingEcho) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for index := range t.pending {
+		if t.pending[index] == pending {
+			t.pending = append(t.pending[:index], t.pending[index+1:]...)
+			return
+		}
+	}
+}
+
+// resolve gives the result to the oldest line matching, returning false if no line matches
+func (t *echoTracker) resolve(match func(*pendingEcho) bool, result deliveryResult) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for index := range t.pending {
+		if match(t.pending[index]) {
+			t.pending[index].result <- result
+			t.pending = append(t.pending[:index], t.pending[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *echoTracker) handleEcho(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	_, msgid := message.GetTag("msgid")
+	t.resolve(func(pending *pendingEcho) bool {
+		return pending.command == message.Command && strings.EqualFold(pending.target, message.Params[0]) &&
+			pending.text == message.Params[1]
+	}, deliveryResult{confirmed: true, msgid: msgid})
+}
+
+// handleError fails the oldest line sent to the target of an error numeric with a command that can cause it.
+// Labelled numerics are left to the labelled line they reply to.
+func (t *echoTracker) handleError(message ircmsg.Message) {
+	if labelled, _ := message.GetTag("label"); labelled || len(message.Params) < 2 {
+		return
+	}
+	commands := echoErrors[message.Command]
+	t.resolve(func(pending *pendingEcho) bool {
+		return commands[pending.command] && strings.EqualFold(pending.target, message.Params[1])
+	}, deliveryResult{err: rejected(message)})
+}
+
+// clear gives up on every waiting line, they may or may not have been delivered
+func (t *echoTracker) clear() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, pending := range t.pending {
+		pending.result <- deliveryResult{}
+	}
+	t.pending = nil
+}
+
+// echoCommands are the commands echoed back by servers supporting echo-message
+var echoCommands = map[string]bool{"PRIVMSG": true, "NOTICE": true, "TAGMSG": true}
+
+// echoErrors are the numerics sent in reply to a message that can't be delivered, with its target as the second
+// parameter, and the commands that can cause them.  Servers never reply to a NOTICE with an error.
+var echoErrors = map[string]map[string]bool{
+	ircevent.ERR_NOSUCHNICK:       {"PRIVMSG": true, "TAGMSG": true},
+	ircevent.ERR_NOSUCHCHANNEL:    {"PRIVMSG": true, "TAGMSG": true},
+	ircevent.ERR_CANNOTSENDTOCHAN: {"PRIVMSG": true, "TAGMSG": true},
+}
+
+// isEcho returns true if the message is the server echoing back a message the bot sent, directly or as a relay
+func (irc *Connection) isEcho(message ircmsg.Message) bool {
+	if _, ok := irc.AcknowledgedCaps()["echo-message"]; !ok {
+		return false
+	}
+	nick := irc.CurrentNick()
+	if strings.EqualFold(message.Nick(), nick) {
+		return true
+	}
+	_, relayedBy := message.GetTag("draft/relaymsg")
+	return strings.EqualFold(relayedBy, nick)
+}
+
+// sendTracked queues a line, returning a function that waits for the server to confirm it was delivered.  Lines
+// are labelled if the server supports labeled-response, otherwise they are matched to their echo.  If the server
+// supports neither, or the line is the start of a batch that can't be matched without a label, the wait returns
+// straight away without confirming it.
+func (irc *Connection) sendTracked(ctx context.Context, line string) (func(context.Context) deliveryResult, error) {
+	message, err := ircmsg.ParseLine(line)
+	if err != nil {
+		return nil, err
+	}
+	caps := irc.AcknowledgedCaps()
+	if _, ok := caps["labeled-response"]; ok {
+		results := make(chan deliveryResult, 1)
+		err := irc.queue.enqueueWith(ctx, line, func(string) error {
+			return irc.connection.SendWithLabel(func(batch *ircevent.Batch) {
+				results <- labelledResult(batch)
+			}, message.AllTags(), message.Command, message.Params...)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) deliveryResult {
+			return waitForDelivery(ctx, results)
+		}, nil
+	}
+	command := strings.ToUpper(message.Command)
+	if command == "RELAYMSG" && len(message.Params) == 3 {
+		// Relayed messages are echoed as a PRIVMSG from the relayed nickname
+		message.Params = []string{message.Params[0], message.Params[2]}
+		command = "PRIVMSG"
+	}
+	if _, ok := caps["echo-message"]; !ok || !echoCommands[command] || len(message.Params) < 2 {
+		return func(context.Context) deliveryResult { return deliveryResult{} }, irc.queue.enqueue(ctx, line)
+	}
+	pending := irc.echoes.add(command, message.Params[0], message.Params[1])
+	if err := irc.queue.enqueue(ctx, line); err != nil {
+		irc.echoes.remove(pending)
+		return nil, err
+	}
+	return func(ctx context.Context) deliveryResult {
+		defer irc.echoes.remove(pending)
+		return waitForDelivery(ctx, pending.result)
+	}, nil
+}
+
+// waitForDelivery waits for the result, giving up without confirming delivery if the context is done or the server
+// doesn't reply in time.  The line has already been sent so it isn't an error if it can't be confirmed.
+func waitForDelivery(ctx context.Context, results chan deliveryResult) deliveryResult {
+	timer := time.NewTimer(confirmTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-results:
+		return result
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return deliveryResult{}
+}
+
+// labelledResult works out what happened to a labelled line from the server's response, a nil response means the
+// server didn't reply
+func labelledResult(batch *ircevent.Batch) (result deliveryResult) {
+	if batch == nil {
+		return
+	}
+	message := batch.Message
+	switch {
+	case message.Command == "FAIL" || isErrorNumeric(message.Command):
+		result.err = rejected(message)
+		return
+	case echoCommands[message.Command], message.Command == "ACK",
+		message.Command == "BATCH" && len(message.Params) > 1 && message.Params[1] == "draft/multiline":
+		result.confirmed = true
+		_, result.msgid = message.GetTag("msgid")
+	}
+	for _, item := range batch.Items {
+		itemResult := labelledResult(item)
+		if itemResult.err != nil {
+			return itemResult
+		}
+		if itemResult.confirmed && !result.confirmed {
+			result = itemResult
+		}
+	}
+	return
+}
+
+func rejected(message ircmsg.Message) *RejectedError {
+	text := ""
+	if len(message.Params) > 0 {
+		text = message.Params[len(message.Params)-1]
+	}
+	return &RejectedError{Reply: message.Command, Message: text}
+}
+
+// isErrorNumeric returns true for numerics in the error range, 400 to 599
+func isErrorNumeric(command string) bool {
+	return len(command) == 3 && (command[0] == '4' || command[0] == '5') &&
+		command[1] >= '0' && command[1] <= '9' && command[2] >= '0' && command[2] <= '9'
+}
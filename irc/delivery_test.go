@@ -0,0 +1,148 @@
+package irc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func parseLine(t *testing.T, line string) ircmsg.Message {
+	message, err := ircmsg.ParseLine(line)
+	if err != nil {
+		t.Fatalf("ParseLine(%q) error = %v", line, err)
+	}
+	return message
+}
+
+func Test_labelledResult(t *testing.T) {
+	tests := []struct {
+		name          string
+		batch         func(t *testing.T) *ircevent.Batch
+		wantConfirmed bool
+		wantMsgID     string
+		wantReply     string
+	}{
+		{
+			name:  "no response",
+			batch: func(*testing.T) *ircevent.Batch { return nil },
+		},
+		{
+			name: "echo",
+			batch: func(t *testing.T) *ircevent.Batch {
+				return &ircevent.Batch{Message: parseLine(t, "@label=1;msgid=abc :bot!bot@host PRIVMSG #test :hello")}
+			},
+			wantConfirmed: true,
+			wantMsgID:     "abc",
+		},
+		{
+			name: "acknowledged",
+			batch: func(t *testing.T) *ircevent.Batch {
+				return &ircevent.Batch{Message: parseLine(t, "@label=1 :server ACK")}
+			},
+			wantConfirmed: true,
+		},
+		{
+			name: "cannot send to channel",
+			batch: func(t *testing.T) *ircevent.Batch {
+				return &ircevent.Batch{Message: parseLine(t, "@label=1 :server 404 bot #test :Cannot send to channel")}
+			},
+			wantReply: "404",
+		},
+		{
+			name: "echo with away reply",
+			batch: func(t *testing.T) *ircevent.Batch {
+				return &ircevent.Batch{
+					Message: parseLine(t, "@label=1 :server BATCH +x labeled-response"),
+					Items: []*ircevent.Batch{
+						{Message: parseLine(t, "@batch=x :server 301 bot user :Gone away")},
+						{Message: parseLine(t, "@batch=x;msgid=def :bot!bot@host PRIVMSG user :hello")},
+					},
+				}
+			},
+			wantConfirmed: true,
+			wantMsgID:     "def",
+		},
+		{
+			name: "multiline echo",
+			batch: func(t *testing.T) *ircevent.Batch {
+				return &ircevent.Batch{
+					Message: parseLine(t, "@label=1;msgid=ghi :bot!bot@host BATCH +y draft/multiline #test"),
+					Items: []*ircevent.Batch{
+						{Message: parseLine(t, "@batch=y :bot!bot@host PRIVMSG #test :first")},
+						{Message: parseLine(t, "@batch=y :bot!bot@host PRIVMSG #test :second")},
+					},
+				}
+			},
+			wantConfirmed: true,
+			wantMsgID:     "ghi",
+		},
+		{
+			name: "standard reply failure",
+			batch: func(t *testing.T) *ircevent.Batch {
+				return &ircevent.Batch{Message: parseLine(t, "@label=1 :server FAIL PRIVMSG INVALID_TARGET #test :Not allowed")}
+			},
+			wantReply: "FAIL",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := labelledResult(tt.batch(t))
+			if result.confirmed != tt.wantConfirmed || result.msgid != tt.wantMsgID {
+				t.Errorf("labelledResult() = %v, %v, want %v, %v", result.confirmed, result.msgid, tt.wantConfirmed, tt.wantMsgID)
+			}
+			var rejected *RejectedError
+			if len(tt.wantReply) == 0 && result.err != nil {
+				t.Errorf("labelledResult() error = %v, want nil", result.err)
+			}
+			if len(tt.wantReply) > 0 && (!errors.As(result.err, &rejected) || rejected.Reply != tt.wantReply) {
+				t.Errorf("labelledResult() error = %v, want a %s rejection", result.err, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestEchoTracker(t *testing.T) {
+	tracker := &echoTracker{}
+	first := tracker.add("PRIVMSG", "#test", "hello")
+	second := tracker.add("PRIVMSG", "#test", "hello")
+	other := tracker.add("PRIVMSG", "#other", "hello")
+	notice := tracker.add("NOTICE", "someone", "hello")
+	tracker.handleEcho(parseLine(t, "@msgid=1 :bot!bot@host PRIVMSG #Test :hello"))
+	if result := <-first.result; !result.confirmed || result.msgid != "1" {
+		t.Errorf("first result = %+v, want confirmed with msgid 1", result)
+	}
+	tracker.handleError(parseLine(t, ":server 404 bot #other :Cannot send to channel"))
+	if result := <-other.result; !errors.Is(result.err, ErrRejected) {
+		t.Errorf("other result = %+v, want rejected", result)
+	}
+	tracker.handleEcho(parseLine(t, ":bot!bot@host PRIVMSG #test :different"))
+	tracker.handleError(parseLine(t, "@label=1 :server 404 bot #test :Cannot send to channel"))
+	tracker.handleError(parseLine(t, ":server 401 bot someone :No such nick"))
+	select {
+	case result := <-second.result:
+		t.Errorf("second result = %+v, want it to wait for its own echo", result)
+	case result := <-notice.result:
+		t.Errorf("notice result = %+v, want NOTICEs not to be failed by errors", result)
+	default:
+	}
+	tracker.clear()
+	if result := <-second.result; result.confirmed || result.err != nil {
+		t.Errorf("second result = %+v, want unconfirmed once cleared", result)
+	}
+}
+
+func TestDelivery_add(t *testing.T) {
+	delivery := Delivery{}
+	delivery.add(deliveryResult{confirmed: true, msgid: "1"})
+	delivery.add(deliveryResult{confirmed: true})
+	if !delivery.Confirmed || !reflect.DeepEqual(delivery.MsgIDs, []string{"1"}) {
+		t.Errorf("Delivery = %+v, want confirmed with one msgid", delivery)
+	}
+	delivery.add(deliveryResult{confirmed: false, msgid: "3"})
+	if delivery.Confirmed || !reflect.DeepEqual(delivery.MsgIDs, []string{"1", "3"}) {
+		t.Errorf("Delivery = %+v, want unconfirmed once a line isn't confirmed", delivery)
+	}
+}
@@ -0,0 +1,117 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// historyTimeout is how long to wait for the server to reply to a CHATHISTORY request
+	historyTimeout = 30 * time.Second
+	// defaultHistoryLimit is how many messages are requested if no limit is given
+	defaultHistoryLimit = 100
+	// historyTimeFormat is the format of timestamps sent in CHATHISTORY requests
+	historyTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// ErrHistoryUnavailable is returned when the server can't replay a channel's history
+var ErrHistoryUnavailable = errors.New("history unavailable")
+
+// HistorySupported returns true if the server can replay history with CHATHISTORY.  Requests need labeled-response
+// so the replayed messages aren't mistaken for new ones.
+func (irc *Connection) HistorySupported() bool {
+	caps := irc.AcknowledgedCaps()
+	for _, name := range []string{"draft/chathistory", "labeled-response", "batch"} {
+		if _, ok := caps[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// History asks the server for up to limit messages sent to the target after the message with the given msgid, or
+// after the given time if afterID is empty, oldest first.  The limit is capped at the one the server advertises.
+func (irc *Connection) History(ctx context.Context, target string, afterID string, after time.Time, limit int) ([]ircmsg.Message, error) {
+	if !irc.HistorySupported() {
+		return nil, ErrHistoryUnavailable
+	}
+	if err := irc.ValidateTarget(target); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(afterID, " "+lineBreakers) {
+		return nil, fmt.Errorf("%w: msgid may not contain spaces or line breaks", ErrInvalidText)
+	}
+	point := historyPoint(afterID, after)
+	count := strconv.Itoa(historyLimit(irc.ISupport()["CHATHISTORY"], limit))
+	results := make(chan *ircevent.Batch, 1)
+	line := strings.Join([]string{"CHATHISTORY", "AFTER", target, point, count}, " ")
+	err := irc.queue.enqueueWith(ctx, line, func(string) error {
+		return irc.connection.SendWithLabel(func(batch *ircevent.Batch) {
+			results <- batch
+		}, nil, "CHATHISTORY", "AFTER", target, point, count)
+	})
+	if err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(historyTimeout)
+	defer timer.Stop()
+	select {
+	case batch := <-results:
+		return historyMessages(batch)
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: no reply from the server", ErrHistoryUnavailable)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// historyPoint returns the CHATHISTORY selector for a msgid, or a time if there is no msgid
+func historyPoint(afterID string, after time.Time) string {
+	if len(afterID) > 0 {
+		return "msgid=" + afterID
+	}
+	return "timestamp=" + after.UTC().Format(historyTimeFormat)
+}
+
+// historyLimit caps the number of messages requested at the limit in the server's CHATHISTORY token, where zero
+// means it doesn't have one
+func historyLimit(value string, limit int) int {
+	if limit < 1 {
+		limit = defaultHistoryLimit
+	}
+	if maximum, err := strconv.Atoi(value); err == nil && maximum > 0 && limit > maximum {
+		return maximum
+	}
+	return limit
+}
+
+// historyMessages returns the messages in the server's reply to a CHATHISTORY request, flattening any nested
+// batches, or an error if the server failed the request or didn't reply
+func historyMessages(batch *ircevent.Batch) ([]ircmsg.Message, error) {
+	if batch == nil {
+		return nil, fmt.Errorf("%w: no reply from the server", ErrHistoryUnavailable)
+	}
+	message := batch.Message
+	if message.Command == "FAIL" || isErrorNumeric(message.Command) {
+		return nil, fmt.Errorf("%w: %s", ErrHistoryUnavailable, rejected(message))
+	}
+	if message.Command != "BATCH" {
+		return []ircmsg.Message{message}, nil
+	}
+	var messages []ircmsg.Message
+	for _, item := range batch.Items {
+		itemMessages, err := historyMessages(item)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, itemMessages...)
+	}
+	return messages, nil
+}
@@ -0,0 +1,74 @@
+package irc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+)
+
+func Test_historyPoint(t *testing.T) {
+	after := time.Date(2021, 1, 2, 3, 4, 5, 6000000, time.FixedZone("", 3600))
+	if got, want := historyPoint("abc", after), "msgid=abc"; got != want {
+		t.Errorf("historyPoint() = %v, want %v", got, want)
+	}
+	if got, want := historyPoint("", after), "timestamp=2021-01-02T02:04:05.006Z"; got != want {
+		t.Errorf("historyPoint() = %v, want %v", got, want)
+	}
+}
+
+func Test_historyLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		limit int
+		want  int
+	}{
+		{name: "no server limit", value: "0", limit: 500, want: 500},
+		{name: "not advertised", value: "", limit: 50, want: 50},
+		{name: "capped", value: "100", limit: 500, want: 100},
+		{name: "default", value: "", limit: 0, want: defaultHistoryLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := historyLimit(tt.value, tt.limit); got != tt.want {
+				t.Errorf("historyLimit() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_historyMessages(t *testing.T) {
+	batch := &ircevent.Batch{
+		Message: parseLine(t, "@label=1 :server BATCH +1 chathistory #test"),
+		Items: []*ircevent.Batch{
+			{Message: parseLine(t, "@batch=1;msgid=a :nick!user@host PRIVMSG #test :first")},
+			{
+				Message: parseLine(t, "@batch=1;msgid=b :nick!user@host BATCH +2 draft/multiline #test"),
+				Items: []*ircevent.Batch{
+					{Message: parseLine(t, "@batch=2 :nick!user@host PRIVMSG #test :second")},
+				},
+			},
+		},
+	}
+	messages, err := historyMessages(batch)
+	if err != nil {
+		t.Fatalf("historyMessages() error = %v", err)
+	}
+	var got []string
+	for _, message := range messages {
+		got = append(got, message.Params[1])
+	}
+	if want := []string{"first", "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("historyMessages() = %v, want %v", got, want)
+	}
+	failed := &ircevent.Batch{Message: parseLine(t, "@label=1 :server FAIL CHATHISTORY INVALID_TARGET #test :No such channel")}
+	if _, err := historyMessages(failed); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("historyMessages() error = %v, want ErrHistoryUnavailable", err)
+	}
+	if _, err := historyMessages(nil); !errors.Is(err, ErrHistoryUnavailable) {
+		t.Errorf("historyMessages() error = %v for no reply, want ErrHistoryUnavailable", err)
+	}
+}
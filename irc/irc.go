@@ -1,13 +1,14 @@
 package irc
 
 import (
+	"context"
 	"crypto/tls"
-	"errors"
 	"fmt"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ergochat/irc-go/ircevent"
@@ -32,14 +33,20 @@ type Logger interface {
 
 type Connection struct {
 	connection   *ircevent.Connection
-	FloodProfile string
+	FloodProfile FloodProfile
 	logger       Logger
 	connected    bool
-	limiter      *RateLimiter
+	queue        *sendQueue
+	batches      atomic.Int64
+	echoes       echoTracker
+	userhost     string
+	userhostLock sync.Mutex
+	useTLS       bool
+	reconnect    *reconnector
 }
 
 func NewIRC(server, password, nickname, realname string, useTLS, useSasl bool, saslUser, saslPass string,
-	logger Logger, floodProfile string) *Connection {
+	logger Logger, floodProfile FloodProfile) *Connection {
 	connection := &Connection{
 		connection: &ircevent.Connection{
 			Server:       server,
@@ -49,28 +56,68 @@ func NewIRC(server, password, nickname, realname string, useTLS, useSasl bool, s
 			Password:     password,
 			SASLLogin:    saslUser,
 			SASLPassword: saslPass,
-			SASLMech:     "PLAIN",
+			SASLMech:     SASLPlain,
 			Timeout:      1 * time.Minute,
 			KeepAlive:    4 * time.Minute,
-			UseTLS:       useTLS,
 			UseSASL:      useSasl,
 			EnableCTCP:   true,
 			Debug:        true,
-			TLSConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-			QuitMessage: " ",
-			Log:         log.Default(),
+			TLSConfig:    &tls.Config{},
+			QuitMessage:  " ",
+			Log:          log.Default(),
 		},
 		FloodProfile: floodProfile,
 		logger:       logger,
+		useTLS:       useTLS,
+		reconnect:    newReconnector(server),
 	}
-	connection.connection.RequestCaps = append(connection.connection.RequestCaps, "draft/relaymsg")
-	connection.limiter = connection.NewRateLimiter(floodProfile)
+	// The connection dials and waits between attempts itself, so ircevent reconnects straight away
+	connection.connection.DialContext = connection.dial
+	connection.connection.ReconnectFreq = time.Nanosecond
+	connection.connection.RequestCaps = append(connection.connection.RequestCaps, "draft/relaymsg", "account-tag",
+		"account-notify", "extended-join", "multi-prefix", "userhost-in-names", "batch", "draft/multiline", "echo-message", "labeled-response",
+		"server-time", "message-tags", "draft/chathistory")
+	connection.queue = newSendQueue(floodProfile, connection.connection.SendRaw)
+	connection.connection.AddConnectCallback(func(ircmsg.Message) {
+		connection.queue.connected.Store(true)
+	})
+	connection.connection.AddDisconnectCallback(func(ircmsg.Message) {
+		connection.queue.disconnected()
+		connection.setUserhost("")
+		connection.echoes.clear()
+		connection.reconnect.disconnected("connection lost")
+	})
+	for command := range echoCommands {
+		connection.connection.AddCallback(command, func(message ircmsg.Message) {
+			if connection.isEcho(message) {
+				connection.echoes.handleEcho(message)
+			}
+		})
+	}
+	for numeric := range echoErrors {
+		connection.connection.AddCallback(numeric, connection.echoes.handleError)
+	}
+	connection.connection.AddCallback(ircevent.RPL_WELCOME, connection.handleWelcome)
+	connection.connection.AddCallback(ircevent.RPL_WELCOME, func(ircmsg.Message) {
+		connection.reconnect.registered()
+	})
+	connection.connection.AddCallback("ERROR", connection.handleError)
+	connection.connection.AddCallback("JOIN", connection.handleSelfJoin)
+	connection.connection.AddCallback("CHGHOST", connection.handleChangeHost)
+	connection.connection.AddCallback("396", connection.handleVisibleHost)
 	logger.Infof("Creating new IRC")
 	return connection
 }
 
+// SetQuitMessage sets the message sent when quitting, it must be called before connecting
+func (irc *Connection) SetQuitMessage(message string) {
+	if len(message) == 0 {
+		// An empty message would make ircevent send its version instead
+		message = " "
+	}
+	irc.connection.QuitMessage = message
+}
+
 func (irc *Connection) Quit() {
 	irc.connection.Quit()
 }
@@ -79,7 +126,16 @@ func (irc *Connection) AddConnectCallback(handler func(ircmsg.Message)) ircevent
 	return irc.connection.AddConnectCallback(handler)
 }
 
+// AddCallback adds a handler for a command.  Messages the bot sent that are echoed back by the server are not passed
+// to handlers, so they only see messages from other users.
 func (irc *Connection) AddCallback(command string, handler func(ircmsg.Message)) ircevent.CallbackID {
+	if echoCommands[command] {
+		return irc.connection.AddCallback(command, func(message ircmsg.Message) {
+			if !irc.isEcho(message) {
+				handler(message)
+			}
+		})
+	}
 	return irc.connection.AddCallback(command, handler)
 }
 
@@ -88,11 +144,21 @@ func (irc *Connection) RemoveCallback(id ircevent.CallbackID) {
 }
 
 func (irc *Connection) Join(channel string) error {
-	return irc.connection.Join(channel)
+	return irc.JoinContext(context.Background(), channel)
+}
+
+// JoinContext joins the channel, failing like SendRawContext if the line can't be queued before the context is done
+func (irc *Connection) JoinContext(ctx context.Context, channel string) error {
+	return irc.SendRawfContext(ctx, "JOIN %s", channel)
 }
 
 func (irc *Connection) Part(channel string) error {
-	return irc.connection.Part(channel)
+	return irc.PartContext(context.Background(), channel)
+}
+
+// PartContext leaves the channel, failing like SendRawContext if the line can't be queued before the context is done
+func (irc *Connection) PartContext(ctx context.Context, channel string) error {
+	return irc.SendRawfContext(ctx, "PART %s", channel)
 }
 
 func (irc *Connection) CurrentNick() string {
@@ -112,32 +178,264 @@ func (irc *Connection) SetMode(mode string) error {
 }
 
 func (irc *Connection) SendRaw(line string) error {
-	err := irc.limiter.Wait()
-	if err != nil {
-		return err
-	}
-	return irc.connection.SendRaw(line)
+	return irc.SendRawContext(context.Background(), line)
 }
 
 func (irc *Connection) SendRawf(formatLine string, args ...interface{}) error {
 	return irc.SendRaw(fmt.Sprintf(formatLine, args...))
 }
 
+// SendRawContext queues a line to be sent, waiting until it is sent or the context is done.  Lines sent with a
+// context from WithQueueOwner fail with ErrQueueFull if the owner already has too many lines queued, and lines
+// containing CR, LF or NUL fail with ErrInvalidText.
+func (irc *Connection) SendRawContext(ctx context.Context, line string) error {
+	return irc.queue.enqueue(ctx, line)
+}
+
+func (irc *Connection) SendRawfContext(ctx context.Context, formatLine string, args ...interface{}) error {
+	return irc.SendRawContext(ctx, fmt.Sprintf(formatLine, args...))
+}
+
+func (irc *Connection) SendMessage(command string, target string, message string) error {
+	return irc.SendMessageContext(context.Background(), command, target, message)
+}
+
+// SendMessageContext sends a PRIVMSG or NOTICE, splitting it into as many lines as are needed to fit in the
+// server's line length.  Messages that need more than one line are sent as a draft/multiline batch if the server
+// supports it.  It fails with ErrInvalidTarget if the target isn't a valid channel or nickname.
+func (irc *Connection) SendMessageContext(ctx context.Context, command string, target string, message string) error {
+	return irc.sendMessage(ctx, command, target, message, nil)
+}
+
+// SendMessageConfirmed sends a message like SendMessageContext, then waits for the server to confirm it was
+// delivered.  It fails with a RejectedError if the server refuses to deliver it.
+func (irc *Connection) SendMessageConfirmed(ctx context.Context, command string, target string, message string) (Delivery, error) {
+	delivery := Delivery{}
+	err := irc.sendMessage(ctx, command, target, message, &delivery)
+	return delivery, err
+}
+
+func (irc *Connection) sendMessage(ctx context.Context, command string, target string, message string,
+	delivery *Delivery) error {
+	if err := irc.ValidateTarget(target); err != nil {
+		return err
+	}
+	if strings.ContainsRune(message, 0) {
+		return fmt.Errorf("%w: may not contain NUL", ErrInvalidText)
+	}
+	limit := maxLineLength - irc.prefixLength(irc.CurrentNick()) - len(command+" "+target+" :\r\n")
+	return irc.sendLines(ctx, command, target, "", splitMessage(message, limit), delivery)
+}
+
 func (irc *Connection) SendRelayMessage(channel string, nickname string, message string) error {
+	return irc.SendRelayMessageContext(context.Background(), channel, nickname, message)
+}
+
+// SendRelayMessageContext sends a message on behalf of another user, with RELAYMSG if the server supports it or
+// prefixed with their nickname otherwise.  Long messages are split the same way as SendMessageContext.
+func (irc *Connection) SendRelayMessageContext(ctx context.Context, channel string, nickname string, message string) error {
+	return irc.sendRelayMessage(ctx, channel, nickname, message, nil)
+}
+
+// SendRelayMessageConfirmed relays a message like SendRelayMessageContext, then waits for the server to confirm it
+// was delivered
+func (irc *Connection) SendRelayMessageConfirmed(ctx context.Context, channel string, nickname string, message string) (Delivery, error) {
+	delivery := Delivery{}
+	err := irc.sendRelayMessage(ctx, channel, nickname, message, &delivery)
+	return delivery, err
+}
+
+func (irc *Connection) sendRelayMessage(ctx context.Context, channel string, nickname string, message string,
+	delivery *Delivery) error {
+	if err := irc.ValidateChannel(channel); err != nil {
+		return err
+	}
+	if strings.ContainsRune(message, 0) {
+		return fmt.Errorf("%w: may not contain NUL", ErrInvalidText)
+	}
+	// Relayed nicknames aren't real users, so only need to fit in a single parameter rather than within NICKLEN
+	if err := validateNick(nickname, chanTypes(irc.ISupport()), 0); err != nil {
+		return err
+	}
 	if irc.AcknowledgedCaps()["draft/relaymsg"] == "" {
-		return irc.SendRawf("PRIVMSG %s :<%s> %s", channel, nickname, message)
-	} else {
-		return irc.SendRawf("RELAYMSG %s %s :%s", channel, nickname, message)
+		prefix := "<" + nickname + "> "
+		limit := maxLineLength - irc.prefixLength(irc.CurrentNick()) - len("PRIVMSG "+channel+" :\r\n"+prefix)
+		return irc.sendLines(ctx, "PRIVMSG", channel, prefix, splitMessage(message, limit), delivery)
 	}
+	// The server relays the message from the nickname with its separator appended, so allow for one extra character
+	limit := maxLineLength - irc.prefixLength(nickname+"/") - len("PRIVMSG "+channel+" :\r\n")
+	lines := splitMessage(message, limit)
+	for _, line := range lines {
+		for _, part := range line {
+			if err := irc.sendLine(ctx, fmt.Sprintf("RELAYMSG %s %s :%s", channel, nickname, part), delivery); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-func (irc *Connection) Connect() error {
-	irc.logger.Infof("Connecting to IRC: %s", irc.connection.Server)
-	err := irc.connection.Connect()
+// sendLines sends the split lines of a message, as a multiline batch if the server supports it or as separate lines
+// otherwise.  The prefix is added to the start of every line.
+func (irc *Connection) sendLines(ctx context.Context, command string, target string, prefix string,
+	lines [][]string, delivery *Delivery) error {
+	multiline, supported := irc.AcknowledgedCaps()["draft/multiline"]
+	if supported && (len(lines) > 1 || (len(lines) == 1 && len(lines[0]) > 1)) {
+		maxBytes, maxLines := multilineLimits(multiline)
+		for _, batch := range batchLines(lines, maxBytes, maxLines) {
+			if err := irc.sendBatch(ctx, command, target, prefix, batch, delivery); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, line := range lines {
+		for _, part := range line {
+			part = strings.TrimRight(part, " ")
+			if len(part) == 0 {
+				continue
+			}
+			if err := irc.sendLine(ctx, fmt.Sprintf("%s %s :%s%s", command, target, prefix, part), delivery); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sendLine sends a single line, waiting for the server to confirm it if delivery is being tracked
+func (irc *Connection) sendLine(ctx context.Context, line string, delivery *Delivery) error {
+	if delivery == nil {
+		return irc.SendRawContext(ctx, line)
+	}
+	wait, err := irc.sendTracked(ctx, line)
 	if err != nil {
 		return err
 	}
-	return nil
+	result := wait(ctx)
+	delivery.add(result)
+	return result.err
+}
+
+// sendBatch sends the parts as a single draft/multiline batch, lines sent to the same target are sent in order
+// so the batch arrives intact even if other lines are sent in between
+func (irc *Connection) sendBatch(ctx context.Context, command string, target string, prefix string,
+	batch []multilinePart, delivery *Delivery) error {
+	if len(batch) == 1 {
+		return irc.sendLine(ctx, fmt.Sprintf("%s %s :%s%s", command, target, prefix, strings.TrimRight(batch[0].text, " ")),
+			delivery)
+	}
+	reference := "ml" + strconv.FormatInt(irc.batches.Add(1), 10)
+	start := fmt.Sprintf("BATCH +%s draft/multiline %s", reference, target)
+	wait := func(context.Context) deliveryResult { return deliveryResult{} }
+	var err error
+	if delivery == nil {
+		err = irc.SendRawContext(ctx, start)
+	} else {
+		wait, err = irc.sendTracked(ctx, start)
+	}
+	if err != nil {
+		return err
+	}
+	for _, part := range batch {
+		if part.concat {
+			err = irc.SendRawfContext(ctx, "@batch=%s;draft/multiline-concat %s %s :%s", reference, command, target,
+				part.text)
+		} else {
+			err = irc.SendRawfContext(ctx, "@batch=%s %s %s :%s%s", reference, command, target, prefix, part.text)
+		}
+		if err != nil {
+			break
+		}
+	}
+	// Always close the batch, even if the plugin has given up waiting, so the server doesn't hold it open
+	if closeErr := irc.SendRawf("BATCH -%s", reference); err == nil {
+		err = closeErr
+	}
+	if err != nil || delivery == nil {
+		return err
+	}
+	result := wait(ctx)
+	delivery.add(result)
+	return result.err
+}
+
+// prefixLength returns the length of the source the server adds when relaying a line from the nickname, assuming
+// the longest username and hostname the server allows until the bot has seen its own hostmask
+func (irc *Connection) prefixLength(nickname string) int {
+	irc.userhostLock.Lock()
+	userhost := irc.userhost
+	irc.userhostLock.Unlock()
+	if len(userhost) > 0 {
+		return len(":" + nickname + "!" + userhost + " ")
+	}
+	userLength := defaultUserLength
+	if length, err := strconv.Atoi(irc.ISupport()["USERLEN"]); err == nil && length > 0 {
+		userLength = length + 1
+	}
+	return len(":"+nickname+"!"+"@"+" ") + userLength + maxHostLength
+}
+
+func (irc *Connection) setUserhost(userhost string) {
+	irc.userhostLock.Lock()
+	defer irc.userhostLock.Unlock()
+	irc.userhost = userhost
+}
+
+// handleWelcome takes the bot's hostmask from the end of the welcome message, if the server includes it
+func (irc *Connection) handleWelcome(message ircmsg.Message) {
+	if len(message.Params) == 0 {
+		return
+	}
+	words := strings.Fields(message.Params[len(message.Params)-1])
+	if len(words) == 0 {
+		return
+	}
+	if _, userhost, found := strings.Cut(words[len(words)-1], "!"); found && strings.Contains(userhost, "@") {
+		irc.setUserhost(userhost)
+	}
+}
+
+func (irc *Connection) handleSelfJoin(message ircmsg.Message) {
+	if !strings.EqualFold(message.Nick(), irc.CurrentNick()) {
+		return
+	}
+	if _, userhost, found := strings.Cut(message.Source, "!"); found {
+		irc.setUserhost(userhost)
+	}
+}
+
+func (irc *Connection) handleChangeHost(message ircmsg.Message) {
+	if len(message.Params) < 2 || !strings.EqualFold(message.Nick(), irc.CurrentNick()) {
+		return
+	}
+	irc.setUserhost(message.Params[0] + "@" + message.Params[1])
+}
+
+// handleVisibleHost updates the bot's hostname when the server cloaks it, the username is unchanged
+func (irc *Connection) handleVisibleHost(message ircmsg.Message) {
+	if len(message.Params) < 2 {
+		return
+	}
+	irc.userhostLock.Lock()
+	defer irc.userhostLock.Unlock()
+	if user, _, found := strings.Cut(irc.userhost, "@"); found {
+		irc.userhost = user + "@" + message.Params[1]
+	}
+}
+
+// SetQueueLimit sets how many lines each plugin may have waiting to be sent, zero is unlimited
+func (irc *Connection) SetQueueLimit(limit int) {
+	irc.queue.setLimit(limit)
+}
+
+// QueueDepth returns the number of lines the owner has waiting to be sent, and the number waiting in total
+func (irc *Connection) QueueDepth(owner string) (owned int, total int) {
+	return irc.queue.depths(owner)
+}
+
+func (irc *Connection) Connect() error {
+	return irc.connection.Connect()
 }
 
 func (irc *Connection) Wait() {
@@ -154,36 +452,3 @@ func (irc *Connection) ConnectAndWait() error {
 	irc.Wait()
 	return nil
 }
-
-func (irc *Connection) ConnectAndWaitWithRetry(maxRetries int) error {
-	sigWait := make(chan os.Signal, 1)
-	signal.Notify(sigWait, os.Interrupt)
-	signal.Notify(sigWait, syscall.SIGTERM)
-	retryDelay := 0
-	retryCount := -1
-	for {
-		retryCount++
-		err := irc.ConnectAndWait()
-		if retryCount > maxRetries {
-			return errors.New("maximum retries reached")
-		}
-		retryDelay = retryCount*5 + retryDelay
-		if retryDelay > 300 {
-			retryDelay = 300
-		}
-		irc.connection.ReconnectFreq = time.Duration(retryDelay) * time.Second
-		if err != nil {
-			irc.logger.Errorf("Error connecting: %s", err.Error())
-			irc.logger.Infof("Retrying connect in %d", retryDelay)
-		} else {
-			return nil
-		}
-		sleep := time.NewTimer(time.Duration(retryDelay) * time.Second)
-		select {
-		case <-sleep.C:
-		//NOOP
-		case <-sigWait:
-			return fmt.Errorf("terminate Signal received")
-		}
-	}
-}
@@ -1,42 +1,43 @@
 package irc
 
 import (
-	"context"
 	"math"
 	"time"
 
-	"github.com/ergochat/irc-go/ircmsg"
 	"golang.org/x/time/rate"
 )
 
-func (irc *Connection) NewRateLimiter(floodProfile string) *RateLimiter {
-	rl := RateLimiter{}
-	rl.Init(floodProfile)
-	irc.connection.AddConnectCallback(func(ircmsg.Message) {
-		rl.received001 = true
-	})
-	return &rl
+// FloodProfile describes how quickly lines may be sent once connected.  The server allows a burst of Burst tokens,
+// refilled at one token every Interval, a zero Interval disables limiting.  Each line costs one token, plus one for
+// every BytesPerToken bytes if that is set.
+type FloodProfile struct {
+	Burst         int
+	Interval      time.Duration
+	BytesPerToken int
 }
 
-type RateLimiter struct {
-	limiter     *rate.Limiter
-	received001 bool
+// FloodProfiles lists the built-in flood profiles
+var FloodProfiles = map[string]FloodProfile{
+	"unlimited":   {},
+	"restrictive": {Burst: 3, Interval: 2500 * time.Millisecond},
 }
 
-func (r *RateLimiter) Init(profile string) {
-	switch profile {
-	case "unlimited":
-		r.limiter = rate.NewLimiter(rate.Inf, math.MaxInt)
-	case "restrictive":
-		r.limiter = rate.NewLimiter(rate.Every(2500*time.Millisecond), 3)
+// newLimiter returns a token bucket for the profile
+func (p FloodProfile) newLimiter() *rate.Limiter {
+	if p.Interval <= 0 {
+		return rate.NewLimiter(rate.Inf, math.MaxInt)
 	}
+	return rate.NewLimiter(rate.Every(p.Interval), p.Burst)
 }
 
-func (r *RateLimiter) Wait() error {
-	if r.received001 {
-		if err := r.limiter.WaitN(context.Background(), 1); err != nil {
-			return err
-		}
+// cost returns the number of tokens needed to send a line, never more than the burst so it can always be sent
+func (p FloodProfile) cost(line string) int {
+	cost := 1
+	if p.BytesPerToken > 0 {
+		cost += len(line) / p.BytesPerToken
 	}
-	return nil
+	if p.Interval > 0 && cost > p.Burst {
+		return p.Burst
+	}
+	return cost
 }
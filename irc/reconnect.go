@@ -0,0 +1,311 @@
+package irc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+// ErrGaveUp is returned when the connection gives up after its maximum number of attempts
+var ErrGaveUp = errors.New("maximum connection attempts reached")
+
+// Backoff describes how long to wait between connection attempts.  The delay starts at Delay and is multiplied by
+// Multiplier after every failed attempt up to MaxDelay, then reduced by a random amount up to Jitter (a fraction of
+// the delay) so bots don't all reconnect at once.  MaxAttempts of zero retries forever.
+type Backoff struct {
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxAttempts int
+}
+
+// DefaultBackoff is used if no backoff is set
+var DefaultBackoff = Backoff{
+	Delay:      5 * time.Second,
+	MaxDelay:   5 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// delay returns how long to wait before the given retry, counting from zero, random returns a number between 0 and 1
+func (b Backoff) delay(retry int, random func() float64) time.Duration {
+	delay := float64(b.Delay) * math.Pow(math.Max(b.Multiplier, 1), float64(retry))
+	if b.MaxDelay > 0 && delay > float64(b.MaxDelay) {
+		delay = float64(b.MaxDelay)
+	}
+	delay -= delay * math.Min(math.Max(b.Jitter, 0), 1) * random()
+	return time.Duration(delay)
+}
+
+// ConnectionState is the state of the connection to the server
+type ConnectionState string
+
+const (
+	// StateConnecting means the bot is connecting to a server, or waiting to
+	StateConnecting ConnectionState = "connecting"
+	// StateRegistered means the bot is connected and registered with the server
+	StateRegistered ConnectionState = "registered"
+	// StateDisconnected means the bot lost or failed to make its connection
+	StateDisconnected ConnectionState = "disconnected"
+)
+
+// StateChange describes the connection entering a new state.  Attempt counts the attempts since the bot was last
+// registered, and Reason says why the bot disconnected.
+type StateChange struct {
+	State   ConnectionState
+	Server  string
+	Reason  string
+	Attempt int
+	Time    time.Time
+}
+
+// reconnector picks the server for each connection attempt and waits between them, and tracks the connection's state
+type reconnector struct {
+	mutex     sync.Mutex
+	servers   []string
+	server    int
+	backoff   Backoff
+	failures  int
+	dialled   bool
+	gaveUp    bool
+	ctx       context.Context
+	state     StateChange
+	reason    string
+	callbacks map[int]func(StateChange)
+	nextID    int
+	random    func() float64
+	sleep     func(ctx context.Context, delay time.Duration) error
+}
+
+func newReconnector(server string) *reconnector {
+	return &reconnector{
+		servers:   []string{server},
+		backoff:   DefaultBackoff,
+		ctx:       context.Background(),
+		state:     StateChange{State: StateDisconnected, Server: server, Time: time.Now()},
+		callbacks: map[int]func(StateChange){},
+		random:    rand.Float64,
+		sleep:     sleepContext,
+	}
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// next waits before the next connection attempt if there has been one before, and returns the server to try.  Each
+// failed attempt moves on to the next server, after a disconnection the same server is tried first.
+func (r *reconnector) next() (string, error) {
+	// An attempt that failed after dialling, while registering, hasn't been reported yet
+	r.failed("connection failed")
+	r.mutex.Lock()
+	failures := r.failures
+	if r.backoff.MaxAttempts > 0 && failures >= r.backoff.MaxAttempts {
+		r.gaveUp = true
+		r.mutex.Unlock()
+		return "", ErrGaveUp
+	}
+	if failures > 0 {
+		r.server = (r.server + 1) % len(r.servers)
+	}
+	server := r.servers[r.server]
+	wait := r.dialled
+	r.dialled = true
+	r.failures++
+	ctx := r.ctx
+	backoff := r.backoff
+	r.mutex.Unlock()
+	r.setState(StateChange{State: StateConnecting, Server: server, Attempt: failures + 1})
+	if wait {
+		// After a disconnection there were no failures, but the first retry still waits
+		if err := r.sleep(ctx, backoff.delay(max(failures-1, 0), r.random)); err != nil {
+			return "", err
+		}
+	}
+	return server, nil
+}
+
+// registered resets the backoff once a connection has registered
+func (r *reconnector) registered() {
+	r.mutex.Lock()
+	r.failures = 0
+	r.reason = ""
+	server := r.servers[r.server]
+	r.mutex.Unlock()
+	r.setState(StateChange{State: StateRegistered, Server: server})
+}
+
+// disconnected records that the connection finished, with the last reason the server or dialler gave
+func (r *reconnector) disconnected(reason string) {
+	r.mutex.Lock()
+	if len(r.reason) > 0 {
+		reason = r.reason
+	}
+	r.reason = ""
+	server := r.servers[r.server]
+	attempt := r.failures
+	r.mutex.Unlock()
+	r.setState(StateChange{State: StateDisconnected, Server: server, Reason: reason, Attempt: attempt})
+}
+
+// failed reports a connection attempt failing, unless it has already been reported
+func (r *reconnector) failed(reason string) {
+	r.mutex.Lock()
+	connecting := r.state.State == StateConnecting
+	r.mutex.Unlock()
+	if connecting {
+		r.disconnected(reason)
+	}
+}
+
+func (r *reconnector) setReason(reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reason = reason
+}
+
+func (r *reconnector) setState(change StateChange) {
+	r.mutex.Lock()
+	change.Time = time.Now()
+	r.state = change
+	callbacks := make([]func(StateChange), 0, len(r.callbacks))
+	for _, callback := range r.callbacks {
+		callbacks = append(callbacks, callback)
+	}
+	r.mutex.Unlock()
+	for _, callback := range callbacks {
+		callback(change)
+	}
+}
+
+// SetServers sets the servers to connect to, rotating between them when connecting fails
+func (irc *Connection) SetServers(servers []string) {
+	if len(servers) == 0 {
+		return
+	}
+	irc.reconnect.mutex.Lock()
+	defer irc.reconnect.mutex.Unlock()
+	irc.reconnect.servers = append([]string(nil), servers...)
+	irc.reconnect.server = 0
+}
+
+// SetBackoff sets how long to wait between connection attempts and how many to make
+func (irc *Connection) SetBackoff(backoff Backoff) {
+	irc.reconnect.mutex.Lock()
+	defer irc.reconnect.mutex.Unlock()
+	irc.reconnect.backoff = backoff
+}
+
+// State returns the current state of the connection
+func (irc *Connection) State() StateChange {
+	irc.reconnect.mutex.Lock()
+	defer irc.reconnect.mutex.Unlock()
+	return irc.reconnect.state
+}
+
+// AddStateCallback calls the handler whenever the connection changes state, the returned function removes it
+func (irc *Connection) AddStateCallback(handler func(StateChange)) func() {
+	irc.reconnect.mutex.Lock()
+	defer irc.reconnect.mutex.Unlock()
+	irc.reconnect.nextID++
+	id := irc.reconnect.nextID
+	irc.reconnect.callbacks[id] = handler
+	return func() {
+		irc.reconnect.mutex.Lock()
+		defer irc.reconnect.mutex.Unlock()
+		delete(irc.reconnect.callbacks, id)
+	}
+}
+
+// Run connects to the server and keeps reconnecting, backing off between attempts, until Quit is called or the
+// context is done while waiting to reconnect.  It returns ErrGaveUp if the backoff's maximum attempts are used up
+// without registering.
+func (irc *Connection) Run(ctx context.Context) error {
+	irc.reconnect.mutex.Lock()
+	irc.reconnect.ctx = ctx
+	irc.reconnect.mutex.Unlock()
+	for {
+		err := irc.Connect()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || errors.Is(err, ircevent.ClientHasQuit) {
+			return ctx.Err()
+		}
+		if errors.Is(err, ErrGaveUp) {
+			return err
+		}
+		irc.logger.Errorf("Error connecting: %s", err.Error())
+		irc.reconnect.failed(err.Error())
+	}
+	irc.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	irc.reconnect.mutex.Lock()
+	defer irc.reconnect.mutex.Unlock()
+	if irc.reconnect.gaveUp {
+		return ErrGaveUp
+	}
+	return nil
+}
+
+// dial is used by ircevent to open each connection, it waits out the backoff and rotates between the servers.  TLS
+// is done here rather than by ircevent so the certificate is checked against the server actually dialled.
+func (irc *Connection) dial(_ context.Context, network string, _ string) (net.Conn, error) {
+	server, err := irc.reconnect.next()
+	if err != nil {
+		irc.reconnect.failed(err.Error())
+		// Without quitting ircevent would keep trying to reconnect, once connected quitting is left to Quit
+		irc.connection.Quit()
+		return nil, err
+	}
+	irc.reconnect.mutex.Lock()
+	ctx := irc.reconnect.ctx
+	irc.reconnect.mutex.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, irc.connection.Timeout)
+	defer cancel()
+	irc.logger.Infof("Connecting to IRC: %s", server)
+	socket, err := (&net.Dialer{}).DialContext(ctx, network, server)
+	if err != nil {
+		irc.reconnect.failed(err.Error())
+		return nil, err
+	}
+	if !irc.useTLS {
+		return socket, nil
+	}
+	config := irc.connection.TLSConfig.Clone()
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		config.ServerName = host
+	}
+	tlsSocket := tls.Client(socket, config)
+	if err := tlsSocket.HandshakeContext(ctx); err != nil {
+		_ = socket.Close()
+		irc.reconnect.failed(err.Error())
+		return nil, err
+	}
+	return tlsSocket, nil
+}
+
+// handleError records the reason the server gave for closing the connection
+func (irc *Connection) handleError(message ircmsg.Message) {
+	if len(message.Params) > 0 {
+		irc.reconnect.setReason(message.Params[len(message.Params)-1])
+	}
+}
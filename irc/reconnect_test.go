@@ -0,0 +1,174 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBackoff_delay(t *testing.T) {
+	backoff := Backoff{Delay: 5 * time.Second, MaxDelay: 5 * time.Minute, Multiplier: 2}
+	tests := []struct {
+		name    string
+		backoff Backoff
+		retry   int
+		random  float64
+		want    time.Duration
+	}{
+		{name: "first retry", backoff: backoff, retry: 0, want: 5 * time.Second},
+		{name: "doubles", backoff: backoff, retry: 3, want: 40 * time.Second},
+		{name: "capped", backoff: backoff, retry: 10, want: 5 * time.Minute},
+		{name: "no jitter", backoff: backoff, retry: 0, random: 1, want: 5 * time.Second},
+		{
+			name:    "jitter",
+			backoff: Backoff{Delay: 5 * time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.2},
+			retry:   0,
+			random:  1,
+			want:    4 * time.Second,
+		},
+		{
+			name:    "jitter applied after cap",
+			backoff: Backoff{Delay: 5 * time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.5},
+			retry:   10,
+			random:  0.5,
+			want:    45 * time.Second,
+		},
+		{name: "multiplier below one", backoff: Backoff{Delay: time.Second, Multiplier: 0.5}, retry: 3, want: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.backoff.delay(tt.retry, func() float64 { return tt.random }); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_reconnector_next(t *testing.T) {
+	r := newReconnector("a:6697")
+	r.servers = []string{"a:6697", "b:6697"}
+	r.backoff = Backoff{Delay: time.Second, MaxDelay: time.Minute, Multiplier: 2, MaxAttempts: 3}
+	var slept []time.Duration
+	r.sleep = func(_ context.Context, delay time.Duration) error {
+		slept = append(slept, delay)
+		return nil
+	}
+	var servers []string
+	next := func() {
+		server, err := r.next()
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		servers = append(servers, server)
+	}
+	next()
+	next()
+	r.registered()
+	r.disconnected("connection lost")
+	next()
+	next()
+	next()
+	if _, err := r.next(); !errors.Is(err, ErrGaveUp) {
+		t.Errorf("next() error = %v, want %v", err, ErrGaveUp)
+	}
+	wantServers := []string{"a:6697", "b:6697", "b:6697", "a:6697", "b:6697"}
+	if !reflect.DeepEqual(servers, wantServers) {
+		t.Errorf("next() servers = %v, want %v", servers, wantServers)
+	}
+	wantSlept := []time.Duration{time.Second, time.Second, time.Second, 2 * time.Second}
+	if !reflect.DeepEqual(slept, wantSlept) {
+		t.Errorf("next() slept = %v, want %v", slept, wantSlept)
+	}
+}
+
+func TestConnection_Run_GivesUp(t *testing.T) {
+	var servers []string
+	for range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		servers = append(servers, listener.Addr().String())
+		_ = listener.Close()
+	}
+	connection := NewIRC(servers[0], "", "bot", "bot", false, false, "", "", zap.NewNop().Sugar(), FloodProfile{})
+	connection.SetServers(servers)
+	connection.SetBackoff(Backoff{Delay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 2, MaxAttempts: 3})
+	var mutex sync.Mutex
+	var changes []StateChange
+	connection.AddStateCallback(func(change StateChange) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, change)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := connection.Run(ctx); !errors.Is(err, ErrGaveUp) {
+		t.Fatalf("Run() error = %v, want %v", err, ErrGaveUp)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	want := []struct {
+		state   ConnectionState
+		server  string
+		attempt int
+	}{
+		{StateConnecting, servers[0], 1},
+		{StateDisconnected, servers[0], 1},
+		{StateConnecting, servers[1], 2},
+		{StateDisconnected, servers[1], 2},
+		{StateConnecting, servers[0], 3},
+		{StateDisconnected, servers[0], 3},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Run() state changes = %#+v, want %d changes", changes, len(want))
+	}
+	for index, change := range changes {
+		if change.State != want[index].state || change.Server != want[index].server || change.Attempt != want[index].attempt {
+			t.Errorf("Run() state change %d = %#+v, want %#+v", index, change, want[index])
+		}
+		if change.State == StateDisconnected && len(change.Reason) == 0 {
+			t.Errorf("Run() state change %d has no reason", index)
+		}
+	}
+}
+
+func TestConnection_Run_Cancelled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := listener.Addr().String()
+	_ = listener.Close()
+	connection := NewIRC(server, "", "bot", "bot", false, false, "", "", zap.NewNop().Sugar(), FloodProfile{})
+	connection.SetBackoff(Backoff{Delay: time.Hour, MaxDelay: time.Hour, Multiplier: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	connection.AddStateCallback(func(change StateChange) {
+		// Cancel while the second attempt is waiting out the backoff
+		if change.State == StateConnecting && change.Attempt == 2 {
+			cancel()
+		}
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- connection.Run(ctx)
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Run() didn't return when the context was cancelled")
+	}
+	if got := connection.State(); got.State != StateDisconnected {
+		t.Errorf("State() = %#+v, want disconnected", got)
+	}
+}
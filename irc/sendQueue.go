@@ -0,0 +1,317 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"golang.org/x/time/rate"
+)
+
+var (
+	// ErrQueueFull is returned when the owner of a line already has as many lines queued as they are allowed
+	ErrQueueFull = errors.New("send queue full")
+	// ErrDisconnected is returned for lines sent while there is no connection to the server, or still queued when
+	// the connection was lost
+	ErrDisconnected = errors.New("disconnected from server")
+)
+
+// urgentCommands skip the queue so keeping the connection alive isn't held up by bulk messages, they still use up
+// tokens so the bulk messages slow down to compensate
+var urgentCommands = map[string]bool{
+	"PING": true, "PONG": true, "QUIT": true, "CAP": true, "AUTHENTICATE": true,
+}
+
+// priorityCommands are queued ahead of every target so joining channels isn't held up by bulk messages, but still
+// wait for the flood profile
+var priorityCommands = map[string]bool{
+	"JOIN": true, "PART": true, "NICK": true,
+}
+
+// targetedCommands are queued per target, so that a busy channel can't starve the others
+var targetedCommands = map[string]bool{
+	"PRIVMSG": true, "NOTICE": true, "TAGMSG": true, "RELAYMSG": true,
+}
+
+type queueOwnerKey struct{}
+
+// WithQueueOwner returns a context that attributes the lines sent with it to the owner, so they count towards the
+// owner's queue limit
+func WithQueueOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, queueOwnerKey{}, owner)
+}
+
+func queueOwner(ctx context.Context) string {
+	owner, _ := ctx.Value(queueOwnerKey{}).(string)
+	return owner
+}
+
+type queuedLine struct {
+	ctx      context.Context
+	owner    string
+	target   string
+	priority bool
+	line     string
+	cost     int
+	send     func(string) error
+	result   chan error
+}
+
+// sendQueue rate limits lines sent to the server according to a flood profile, taking priority lines first and then
+// lines from each target in turn.  Nothing is limited until the server has accepted the connection.
+type sendQueue struct {
+	mutex     sync.Mutex
+	profile   FloodProfile
+	limiter   *rate.Limiter
+	priority  []*queuedLine
+	targets   map[string][]*queuedLine
+	order     []string
+	owners    map[string]int
+	depth     int
+	limit     int
+	dropped   chan struct{}
+	wake      chan struct{}
+	send      func(string) error
+	connected atomic.Bool
+}
+
+func newSendQueue(profile FloodProfile, send func(string) error) *sendQueue {
+	queue := &sendQueue{
+		profile: profile,
+		limiter: profile.newLimiter(),
+		targets: make(map[string][]*queuedLine),
+		owners:  make(map[string]int),
+		dropped: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		send:    send,
+	}
+	go queue.run()
+	return queue
+}
+
+// setLimit sets how many lines each owner may have queued at once, zero is unlimited.  Lines without an owner are
+// never limited.
+func (q *sendQueue) setLimit(limit int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.limit = limit
+}
+
+// enqueue sends the line once the flood profile allows, returning the result of sending it.  If the context is done
+// before the line is sent it is removed from the queue.
+func (q *sendQueue) enqueue(ctx context.Context, line string) error {
+	return q.enqueueWith(ctx, line, q.send)
+}
+
+// enqueueWith is enqueue, but sends the line with the given function rather than the queue's
+func (q *sendQueue) enqueueWith(ctx context.Context, line string, send func(string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validateText(line); err != nil {
+		return err
+	}
+	if !q.connected.Load() {
+		err := send(line)
+		if errors.Is(err, ircevent.ClientDisconnected) {
+			return ErrDisconnected
+		}
+		return err
+	}
+	command, target := lineTarget(line)
+	cost := q.profile.cost(line)
+	if urgentCommands[command] {
+		q.limiter.ReserveN(time.Now(), cost)
+		return send(line)
+	}
+	queued := &queuedLine{
+		ctx:      ctx,
+		owner:    queueOwner(ctx),
+		target:   target,
+		priority: priorityCommands[command],
+		line:     line,
+		cost:     cost,
+		send:     send,
+		result:   make(chan error, 1),
+	}
+	if err := q.push(queued); err != nil {
+		return err
+	}
+	select {
+	case err := <-queued.result:
+		return err
+	case <-ctx.Done():
+		if q.remove(queued) {
+			return ctx.Err()
+		}
+		return <-queued.result
+	}
+}
+
+// push adds a line to the end of the priority queue or the queue for its target, and wakes the sender
+func (q *sendQueue) push(queued *queuedLine) error {
+	q.mutex.Lock()
+	if len(queued.owner) > 0 && q.limit > 0 && q.owners[queued.owner] >= q.limit {
+		q.mutex.Unlock()
+		return ErrQueueFull
+	}
+	if queued.priority {
+		q.priority = append(q.priority, queued)
+	} else {
+		if len(q.targets[queued.target]) == 0 {
+			q.order = append(q.order, queued.target)
+		}
+		q.targets[queued.target] = append(q.targets[queued.target], queued)
+	}
+	q.owners[queued.owner]++
+	q.depth++
+	q.mutex.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the first priority line, or the first line queued for the next target in turn, or nil if nothing is
+// queued, along with a channel that is closed if the queue is dropped before the line is sent
+func (q *sendQueue) next() (*queuedLine, chan struct{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.priority) > 0 {
+		queued := q.priority[0]
+		q.priority = q.priority[1:]
+		q.dequeued(queued)
+		return queued, q.dropped
+	}
+	if len(q.order) == 0 {
+		return nil, nil
+	}
+	target := q.order[0]
+	q.order = q.order[1:]
+	queued := q.targets[target][0]
+	q.targets[target] = q.targets[target][1:]
+	if len(q.targets[target]) > 0 {
+		q.order = append(q.order, target)
+	} else {
+		delete(q.targets, target)
+	}
+	q.dequeued(queued)
+	return queued, q.dropped
+}
+
+// remove takes a line out of the queue, returning false if it has already been taken by the sender
+func (q *sendQueue) remove(queued *queuedLine) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if queued.priority {
+		for index := range q.priority {
+			if q.priority[index] == queued {
+				q.priority = append(q.priority[:index], q.priority[index+1:]...)
+				q.dequeued(queued)
+				return true
+			}
+		}
+		return false
+	}
+	lines := q.targets[queued.target]
+	for index := range lines {
+		if lines[index] != queued {
+			continue
+		}
+		q.targets[queued.target] = append(lines[:index], lines[index+1:]...)
+		if len(q.targets[queued.target]) == 0 {
+			delete(q.targets, queued.target)
+			for orderIndex := range q.order {
+				if q.order[orderIndex] == queued.target {
+					q.order = append(q.order[:orderIndex], q.order[orderIndex+1:]...)
+					break
+				}
+			}
+		}
+		q.dequeued(queued)
+		return true
+	}
+	return false
+}
+
+// dequeued updates the queue depths once a line has left the queue.  The mutex must be held.
+func (q *sendQueue) dequeued(queued *queuedLine) {
+	q.depth--
+	q.owners[queued.owner]--
+	if q.owners[queued.owner] == 0 {
+		delete(q.owners, queued.owner)
+	}
+}
+
+// disconnected stops limiting until the server accepts a new connection, failing every queued line as there is no
+// connection to send them on
+func (q *sendQueue) disconnected() {
+	q.connected.Store(false)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	close(q.dropped)
+	q.dropped = make(chan struct{})
+	for _, queued := range q.priority {
+		queued.result <- ErrDisconnected
+	}
+	q.priority = nil
+	for _, lines := range q.targets {
+		for _, queued := range lines {
+			queued.result <- ErrDisconnected
+		}
+	}
+	q.targets = make(map[string][]*queuedLine)
+	q.order = nil
+	q.owners = make(map[string]int)
+	q.depth = 0
+}
+
+// depths returns the number of lines queued by the owner, and the number queued in total
+func (q *sendQueue) depths(owner string) (owned int, total int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.owners[owner], q.depth
+}
+
+func (q *sendQueue) run() {
+	for {
+		queued, dropped := q.next()
+		if queued == nil {
+			<-q.wake
+			continue
+		}
+		reservation := q.limiter.ReserveN(time.Now(), queued.cost)
+		timer := time.NewTimer(reservation.Delay())
+		select {
+		case <-timer.C:
+			queued.result <- queued.send(queued.line)
+		case <-queued.ctx.Done():
+			timer.Stop()
+			reservation.Cancel()
+			queued.result <- queued.ctx.Err()
+		case <-dropped:
+			timer.Stop()
+			reservation.Cancel()
+			queued.result <- ErrDisconnected
+		}
+	}
+}
+
+// lineTarget returns the command of a line, and the lower cased target if it is sent to a specific channel or user
+func lineTarget(line string) (command string, target string) {
+	message, err := ircmsg.ParseLine(line)
+	if err != nil {
+		return "", ""
+	}
+	command = strings.ToUpper(message.Command)
+	if targetedCommands[command] && len(message.Params) > 0 {
+		target = strings.ToLower(message.Params[0])
+	}
+	return
+}
@@ -0,0 +1,179 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFloodProfile_cost(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile FloodProfile
+		line    string
+		want    int
+	}{
+		{name: "per line", profile: FloodProfile{Burst: 5, Interval: time.Second}, line: "PRIVMSG #test :hello", want: 1},
+		{name: "per byte", profile: FloodProfile{Burst: 5, Interval: time.Second, BytesPerToken: 10}, line: "PRIVMSG #test :hello", want: 3},
+		{name: "capped at burst", profile: FloodProfile{Burst: 2, Interval: time.Second, BytesPerToken: 1}, line: "PRIVMSG #test :hello", want: 2},
+		{name: "unlimited", profile: FloodProfile{BytesPerToken: 1}, line: "PRIVMSG #test :hello", want: 21},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.cost(tt.line); got != tt.want {
+				t.Errorf("cost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_lineTarget(t *testing.T) {
+	tests := []struct {
+		line        string
+		wantCommand string
+		wantTarget  string
+	}{
+		{line: "PRIVMSG #Test :hello", wantCommand: "PRIVMSG", wantTarget: "#test"},
+		{line: "@label=1 notice Nick :hello", wantCommand: "NOTICE", wantTarget: "nick"},
+		{line: "JOIN #test", wantCommand: "JOIN", wantTarget: ""},
+		{line: "", wantCommand: "", wantTarget: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			command, target := lineTarget(tt.line)
+			if command != tt.wantCommand || target != tt.wantTarget {
+				t.Errorf("lineTarget() = %v, %v, want %v, %v", command, target, tt.wantCommand, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestSendQueue_next(t *testing.T) {
+	queue := &sendQueue{targets: make(map[string][]*queuedLine), owners: make(map[string]int), wake: make(chan struct{}, 1)}
+	for _, line := range []string{"#first 1", "#first 2", "#first 3", "#second 1", " 1", "#first 4", " 2"} {
+		if err := queue.push(&queuedLine{target: strings.SplitN(line, " ", 2)[0], line: line}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := queue.push(&queuedLine{priority: true, line: "JOIN"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for queued, _ := queue.next(); queued != nil; queued, _ = queue.next() {
+		got = append(got, queued.line)
+	}
+	want := []string{"JOIN", "#first 1", "#second 1", " 1", "#first 2", " 2", "#first 3", "#first 4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("next() order = %v, want %v", got, want)
+	}
+}
+
+func TestSendQueue_enqueue(t *testing.T) {
+	sent := make(chan string, 10)
+	queue := newSendQueue(FloodProfile{Burst: 1, Interval: 50 * time.Millisecond}, func(line string) error {
+		sent <- line
+		return nil
+	})
+	queue.connected.Store(true)
+	if err := queue.enqueue(context.Background(), "PRIVMSG #test :first"); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	ctx := WithQueueOwner(context.Background(), "plugin")
+	go func() {
+		_ = queue.enqueue(ctx, "PRIVMSG #test :limited")
+	}()
+	waitForTokens(t, queue)
+	go func() {
+		_ = queue.enqueue(ctx, "PRIVMSG #other :queued")
+	}()
+	waitForDepth(t, queue, 1)
+	go func() {
+		_ = queue.enqueue(context.Background(), "JOIN #test")
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, total := queue.depths(""); total == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("JOIN was never queued")
+		}
+	}
+	if err := queue.enqueue(context.Background(), "PING :keepalive"); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	want := []string{"PRIVMSG #test :first", "PING :keepalive", "PRIVMSG #test :limited", "JOIN #test",
+		"PRIVMSG #other :queued"}
+	for _, line := range want {
+		select {
+		case got := <-sent:
+			if got != line {
+				t.Errorf("sent %s, want %s", got, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was never sent", line)
+		}
+	}
+}
+
+func TestSendQueue_limits(t *testing.T) {
+	queue := newSendQueue(FloodProfile{Burst: 1, Interval: time.Hour}, func(line string) error {
+		return nil
+	})
+	queue.connected.Store(true)
+	queue.setLimit(1)
+	if err := queue.enqueue(context.Background(), "PRIVMSG #test :uses the only token"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithQueueOwner(context.Background(), "plugin")
+	waiting := make(chan error, 1)
+	go func() {
+		waiting <- queue.enqueue(ctx, "PRIVMSG #test :waiting for a token")
+	}()
+	waitForTokens(t, queue)
+	cancelled, cancel := context.WithCancel(ctx)
+	queued := make(chan error, 1)
+	go func() {
+		queued <- queue.enqueue(cancelled, "PRIVMSG #test :queued")
+	}()
+	waitForDepth(t, queue, 1)
+	if err := queue.enqueue(ctx, "PRIVMSG #other :too many"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("enqueue() error = %v, want ErrQueueFull", err)
+	}
+	if err := queue.enqueue(ctx, "PING :keepalive"); err != nil {
+		t.Errorf("enqueue() error = %v, want urgent lines to ignore the limit", err)
+	}
+	cancel()
+	if err := <-queued; !errors.Is(err, context.Canceled) {
+		t.Errorf("enqueue() error = %v, want context.Canceled", err)
+	}
+	if owned, total := queue.depths("plugin"); owned != 0 || total != 0 {
+		t.Errorf("depths() = %d, %d, want the cancelled line removed", owned, total)
+	}
+	queue.disconnected()
+	if err := <-waiting; !errors.Is(err, ErrDisconnected) {
+		t.Errorf("enqueue() error = %v, want ErrDisconnected", err)
+	}
+}
+
+// waitForDepth waits for the plugin to have the given number of lines queued
+func waitForDepth(t *testing.T, queue *sendQueue, depth int) {
+	deadline := time.Now().Add(time.Second)
+	for owned, _ := queue.depths("plugin"); owned != depth; owned, _ = queue.depths("plugin") {
+		if time.Now().After(deadline) {
+			t.Fatalf("depths() = %d, want %d", owned, depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitForTokens waits for the sender to take a line from the queue and reserve more tokens than are available
+func waitForTokens(t *testing.T, queue *sendQueue) {
+	for deadline := time.Now().Add(time.Second); queue.limiter.Tokens() >= 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("line was never taken from the queue")
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package irc
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+const (
+	// maxLineLength is the longest line a server will relay, including the source it adds and the trailing CRLF.
+	// Tags have a separate limit of their own so they don't count towards it.
+	maxLineLength = 512
+	// defaultUserLength is the longest username assumed when the server doesn't advertise USERLEN, allowing for
+	// the ~ added to usernames without ident
+	defaultUserLength = 11
+	// maxHostLength is the longest hostname assumed until the bot has seen its own hostmask
+	maxHostLength = 63
+)
+
+// splitMessage splits a message into lines at newlines, then splits each line into parts that fit in limit bytes.
+// Lines are split after the last space that fits, or at a UTF-8 boundary if there isn't one, a part split at a
+// space keeps the space so joining the parts gives back the original line.  Blank lines are dropped as they can't
+// be sent.
+func splitMessage(message string, limit int) [][]string {
+	var lines [][]string
+	for _, line := range strings.FieldsFunc(message, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		lines = append(lines, splitLine(line, limit))
+	}
+	return lines
+}
+
+func splitLine(line string, limit int) (parts []string) {
+	if limit < 1 {
+		limit = 1
+	}
+	for len(line) > limit {
+		cut := strings.LastIndexByte(line[:limit], ' ') + 1
+		if cut <= 1 {
+			cut = len(ircmsg.TruncateUTF8Safe(line, limit))
+		}
+		if cut == 0 {
+			cut = limit
+		}
+		parts = append(parts, line[:cut])
+		line = line[cut:]
+	}
+	return append(parts, line)
+}
+
+// multilineLimits parses the draft/multiline capability value, a zero limit means the server didn't give one
+func multilineLimits(value string) (maxBytes int, maxLines int) {
+	for _, limit := range strings.Split(value, ",") {
+		key, value, _ := strings.Cut(limit, "=")
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			continue
+		}
+		switch key {
+		case "max-bytes":
+			maxBytes = number
+		case "max-lines":
+			maxLines = number
+		}
+	}
+	return
+}
+
+// multilinePart is one line of a multiline batch, concat parts continue the previous line without a line break
+type multilinePart struct {
+	text   string
+	concat bool
+}
+
+// batchLines groups the parts of a message into batches within the server's limits, a batch never starts with a
+// concat part as servers reject them
+func batchLines(lines [][]string, maxBytes int, maxLines int) [][]multilinePart {
+	var batches [][]multilinePart
+	var batch []multilinePart
+	size := 0
+	for _, line := range lines {
+		for index, part := range line {
+			length := len(part)
+			if index == 0 && len(batch) > 0 {
+				length++
+			}
+			if len(batch) > 0 && ((maxBytes > 0 && size+length > maxBytes) || (maxLines > 0 && len(batch) >= maxLines)) {
+				batches = append(batches, batch)
+				batch = nil
+				size = 0
+				length = len(part)
+			}
+			batch = append(batch, multilinePart{text: part, concat: index > 0 && len(batch) > 0})
+			size += length
+		}
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
@@ -0,0 +1,134 @@
+package irc
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+func Test_splitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		limit   int
+		want    [][]string
+	}{
+		{name: "fits", message: "hello world", limit: 20, want: [][]string{{"hello world"}}},
+		{name: "newlines", message: "first\nsecond\r\nthird", limit: 20, want: [][]string{{"first"}, {"second"}, {"third"}}},
+		{name: "blank lines dropped", message: "first\n\n\nsecond\n", limit: 20, want: [][]string{{"first"}, {"second"}}},
+		{name: "split at space", message: "hello there world", limit: 12, want: [][]string{{"hello there ", "world"}}},
+		{name: "split long word", message: "abcdefghij", limit: 4, want: [][]string{{"abcd", "efgh", "ij"}}},
+		{name: "split on rune boundary", message: "ab€cd", limit: 4, want: [][]string{{"ab", "€c", "d"}}},
+		{name: "leading space", message: " abcdef", limit: 4, want: [][]string{{" abc", "def"}}},
+		{name: "empty", message: "", limit: 4, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitMessage(tt.message, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMessage() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_multilineLimits(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantBytes int
+		wantLines int
+	}{
+		{value: "max-bytes=4096,max-lines=24", wantBytes: 4096, wantLines: 24},
+		{value: "max-bytes=4096", wantBytes: 4096},
+		{value: "max-lines=abc", wantLines: 0},
+		{value: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			maxBytes, maxLines := multilineLimits(tt.value)
+			if maxBytes != tt.wantBytes || maxLines != tt.wantLines {
+				t.Errorf("multilineLimits() = %v, %v, want %v, %v", maxBytes, maxLines, tt.wantBytes, tt.wantLines)
+			}
+		})
+	}
+}
+
+func Test_batchLines(t *testing.T) {
+	lines := [][]string{{"one ", "two"}, {"three"}}
+	tests := []struct {
+		name     string
+		maxBytes int
+		maxLines int
+		want     [][]multilinePart
+	}{
+		{
+			name: "unlimited",
+			want: [][]multilinePart{{{text: "one "}, {text: "two", concat: true}, {text: "three"}}},
+		},
+		{
+			name:     "max lines",
+			maxLines: 2,
+			want:     [][]multilinePart{{{text: "one "}, {text: "two", concat: true}}, {{text: "three"}}},
+		},
+		{
+			name:     "max bytes never starts with concat",
+			maxBytes: 5,
+			want:     [][]multilinePart{{{text: "one "}}, {{text: "two"}}, {{text: "three"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := batchLines(lines, tt.maxBytes, tt.maxLines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batchLines() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnection_SendMessageContext(t *testing.T) {
+	var sent []string
+	connection := &Connection{
+		connection: &ircevent.Connection{Nick: "bot"},
+		queue: newSendQueue(FloodProfile{}, func(line string) error {
+			sent = append(sent, line)
+			return nil
+		}),
+	}
+	connection.handleWelcome(ircmsg.MakeMessage(nil, "server", ircevent.RPL_WELCOME, "bot",
+		"Welcome to the network bot!bot@example.com"))
+	message := strings.Repeat("word ", 100) + "\nsecond line"
+	if err := connection.SendMessageContext(context.Background(), "PRIVMSG", "#test", message); err != nil {
+		t.Fatalf("SendMessageContext() error = %v", err)
+	}
+	var rebuilt []string
+	for _, line := range sent {
+		if length := len(":bot!bot@example.com " + line + "\r\n"); length > maxLineLength {
+			t.Errorf("line is %d bytes once relayed: %s", length, line)
+		}
+		rebuilt = append(rebuilt, strings.TrimPrefix(line, "PRIVMSG #test :"))
+	}
+	if got, want := strings.Join(rebuilt, " "), strings.TrimSpace(strings.Repeat("word ", 100))+" second line"; got != want {
+		t.Errorf("SendMessageContext() sent %q, want %q", got, want)
+	}
+	if len(sent) != 3 {
+		t.Errorf("SendMessageContext() sent %d lines, want 3", len(sent))
+	}
+}
+
+func TestConnection_prefixLength(t *testing.T) {
+	connection := &Connection{connection: &ircevent.Connection{}}
+	if got, want := connection.prefixLength("bot"), len(":bot!@ ")+defaultUserLength+maxHostLength; got != want {
+		t.Errorf("prefixLength() = %v before the hostmask is known, want %v", got, want)
+	}
+	connection.setUserhost("~bot@example.com")
+	if got, want := connection.prefixLength("bot"), len(":bot!~bot@example.com "); got != want {
+		t.Errorf("prefixLength() = %v, want %v", got, want)
+	}
+	connection.handleVisibleHost(ircmsg.MakeMessage(nil, "server", "396", "bot", "cloaked.host", "is now your hidden host"))
+	if got, want := connection.prefixLength("bot"), len(":bot!~bot@cloaked.host "); got != want {
+		t.Errorf("prefixLength() = %v after cloaking, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package irc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// SASLPlain authenticates with a username and password
+	SASLPlain = "PLAIN"
+	// SASLExternal authenticates with the client certificate
+	SASLExternal = "EXTERNAL"
+)
+
+// TLSOptions describes how the server's certificate is verified and which certificate the bot presents to it.  The
+// server's certificate is verified against the system roots unless a CA bundle is given, or is only checked against
+// the fingerprint if one is pinned.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the certificate authorities trusted to sign the server's certificate
+	CAFile string
+	// Fingerprint is the SHA-256 fingerprint of the server's certificate in hex, colons are optional
+	Fingerprint string
+	// Insecure skips verifying the server's certificate entirely
+	Insecure bool
+	// CertFile and KeyFile are the PEM client certificate and key used for SASL EXTERNAL or CertFP
+	CertFile string
+	KeyFile  string
+}
+
+// SetTLS sets how the connection verifies the server and authenticates itself, it must be called before connecting
+func (irc *Connection) SetTLS(options TLSOptions) error {
+	config, err := tlsConfig(irc.connection.Server, options)
+	if err != nil {
+		return err
+	}
+	irc.connection.TLSConfig = config
+	for _, certificate := range config.Certificates {
+		irc.logger.Infof("Client certificate fingerprint: %s", CertificateFingerprint(certificate.Certificate[0]))
+	}
+	return nil
+}
+
+// SetSASLMechanism sets the SASL mechanism used to authenticate, either PLAIN or EXTERNAL
+func (irc *Connection) SetSASLMechanism(mechanism string) error {
+	mechanism = strings.ToUpper(mechanism)
+	switch mechanism {
+	case "":
+		mechanism = SASLPlain
+	case SASLPlain, SASLExternal:
+	default:
+		return fmt.Errorf("unsupported SASL mechanism: %s", mechanism)
+	}
+	irc.connection.SASLMech = mechanism
+	return nil
+}
+
+// tlsConfig builds the TLS config used to connect to the server
+func tlsConfig(server string, options TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: options.Insecure}
+	if host, _, err := net.SplitHostPort(server); err == nil {
+		config.ServerName = host
+	}
+	if len(options.CAFile) > 0 {
+		data, err := os.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", options.CAFile)
+		}
+	}
+	if len(options.Fingerprint) > 0 {
+		fingerprint, err := ParseFingerprint(options.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// The pin replaces verifying the chain, which lets self-signed certificates be used
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = verifyFingerprint(fingerprint)
+	}
+	if len(options.CertFile) > 0 || len(options.KeyFile) > 0 {
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// ParseFingerprint parses a SHA-256 fingerprint written in hex, optionally separated with colons
+func ParseFingerprint(value string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(value, ":", ""))
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, errors.New("fingerprint must be a hex SHA-256 hash")
+	}
+	return fingerprint, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a DER encoded certificate in hex, as used by CertFP
+func CertificateFingerprint(certificate []byte) string {
+	sum := sha256.Sum256(certificate)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyFingerprint returns a check that the server's certificate has the pinned fingerprint
+func verifyFingerprint(fingerprint []byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if !bytes.Equal(sum[:], fingerprint) {
+			return fmt.Errorf("server certificate fingerprint %s doesn't match the pinned fingerprint",
+				hex.EncodeToString(sum[:]))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package irc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T) (certFile string, keyFile string, certificate *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "irc.example.tld"},
+		DNSNames:              []string{"irc.example.tld"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, certificate
+}
+
+func TestParseFingerprint(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "plain", value: hash},
+		{name: "colons", value: strings.TrimSuffix(strings.Repeat("AB:", 32), ":")},
+		{name: "too short", value: "abcdef", wantErr: true},
+		{name: "not hex", value: strings.Repeat("zz", 32), wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fingerprint, err := ParseFingerprint(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFingerprint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && len(fingerprint) != 32 {
+				t.Errorf("ParseFingerprint() length = %d, want 32", len(fingerprint))
+			}
+		})
+	}
+}
+
+func Test_tlsConfig(t *testing.T) {
+	certFile, keyFile, certificate := writeCertificate(t)
+	fingerprint := CertificateFingerprint(certificate.Raw)
+	tests := []struct {
+		name         string
+		options      TLSOptions
+		wantInsecure bool
+		wantRoots    bool
+		wantPin      bool
+		wantCerts    int
+		wantErr      bool
+	}{
+		{name: "verified by default"},
+		{name: "insecure", options: TLSOptions{Insecure: true}, wantInsecure: true},
+		{name: "ca bundle", options: TLSOptions{CAFile: certFile}, wantRoots: true},
+		{name: "missing ca bundle", options: TLSOptions{CAFile: certFile + ".missing"}, wantErr: true},
+		{name: "ca bundle without certificates", options: TLSOptions{CAFile: keyFile}, wantErr: true},
+		{name: "pinned", options: TLSOptions{Fingerprint: fingerprint}, wantInsecure: true, wantPin: true},
+		{name: "invalid pin", options: TLSOptions{Fingerprint: "abc"}, wantErr: true},
+		{name: "client certificate", options: TLSOptions{CertFile: certFile, KeyFile: keyFile}, wantCerts: 1},
+		{name: "certificate without key", options: TLSOptions{CertFile: certFile}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := tlsConfig("irc.example.tld:6697", tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("tlsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.ServerName != "irc.example.tld" {
+				t.Errorf("tlsConfig() ServerName = %s, want irc.example.tld", config.ServerName)
+			}
+			if config.InsecureSkipVerify != tt.wantInsecure {
+				t.Errorf("tlsConfig() InsecureSkipVerify = %t, want %t", config.InsecureSkipVerify, tt.wantInsecure)
+			}
+			if (config.RootCAs != nil) != tt.wantRoots {
+				t.Errorf("tlsConfig() RootCAs = %v, want %t", config.RootCAs, tt.wantRoots)
+			}
+			if (config.VerifyConnection != nil) != tt.wantPin {
+				t.Errorf("tlsConfig() VerifyConnection set = %t, want %t", config.VerifyConnection != nil, tt.wantPin)
+			}
+			if len(config.Certificates) != tt.wantCerts {
+				t.Errorf("tlsConfig() Certificates = %d, want %d", len(config.Certificates), tt.wantCerts)
+			}
+		})
+	}
+}
+
+func Test_verifyFingerprint(t *testing.T) {
+	_, _, certificate := writeCertificate(t)
+	_, _, other := writeCertificate(t)
+	fingerprint, err := ParseFingerprint(CertificateFingerprint(certificate.Raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		certificates []*x509.Certificate
+		wantErr      bool
+	}{
+		{name: "matching", certificates: []*x509.Certificate{certificate}},
+		{name: "different certificate", certificates: []*x509.Certificate{other}, wantErr: true},
+		{name: "pinned certificate not the leaf", certificates: []*x509.Certificate{other, certificate}, wantErr: true},
+		{name: "no certificate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyFingerprint(fingerprint)(tls.ConnectionState{PeerCertificates: tt.certificates})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyFingerprint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidTarget is returned when a channel or nickname isn't valid on the server
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrInvalidText is returned for text containing characters that would end the line or parameter it is sent in
+	ErrInvalidText = errors.New("invalid text")
+)
+
+const (
+	// defaultChanTypes are the channel prefixes assumed when the server doesn't advertise CHANTYPES
+	defaultChanTypes = "#&"
+	// lineBreakers are the characters that may not appear anywhere in a line
+	lineBreakers = "\r\n\x00"
+)
+
+// ValidateChannel checks the name is a valid channel on the server, using the channel types and maximum length it
+// advertises
+func (irc *Connection) ValidateChannel(name string) error {
+	isupport := irc.ISupport()
+	return validateChannel(name, chanTypes(isupport), isupportLength(isupport, "CHANNELLEN"))
+}
+
+// ValidateNick checks the name is a valid nickname on the server, using the channel types and maximum length it
+// advertises
+func (irc *Connection) ValidateNick(name string) error {
+	isupport := irc.ISupport()
+	return validateNick(name, chanTypes(isupport), isupportLength(isupport, "NICKLEN"))
+}
+
+// ValidateTarget checks the name is a valid channel, optionally prefixed with STATUSMSG modes, or nickname
+func (irc *Connection) ValidateTarget(name string) error {
+	channel := strings.TrimLeft(name, irc.ISupport()["STATUSMSG"])
+	if err := irc.ValidateChannel(channel); err == nil {
+		return nil
+	}
+	return irc.ValidateNick(name)
+}
+
+// ValidateKey checks a channel key can be sent in a JOIN
+func ValidateKey(key string) error {
+	if strings.ContainsAny(key, " ,"+lineBreakers) {
+		return fmt.Errorf("%w: key may not contain spaces, commas or line breaks", ErrInvalidText)
+	}
+	return nil
+}
+
+// validateText checks text doesn't contain any characters that would break the line it is sent in
+func validateText(text string) error {
+	if strings.ContainsAny(text, lineBreakers) {
+		return fmt.Errorf("%w: may not contain CR, LF or NUL", ErrInvalidText)
+	}
+	return nil
+}
+
+func validateChannel(name string, chanTypes string, maxLength int) error {
+	if len(name) < 2 || !strings.ContainsRune(chanTypes, rune(name[0])) {
+		return fmt.Errorf("%w: %q is not a channel", ErrInvalidTarget, name)
+	}
+	if strings.ContainsAny(name, " ,\a"+lineBreakers) {
+		return fmt.Errorf("%w: %q contains characters not allowed in channels", ErrInvalidTarget, name)
+	}
+	if maxLength > 0 && len(name) > maxLength {
+		return fmt.Errorf("%w: %q is longer than %d bytes", ErrInvalidTarget, name, maxLength)
+	}
+	return nil
+}
+
+func validateNick(name string, chanTypes string, maxLength int) error {
+	if len(name) == 0 || strings.ContainsRune(chanTypes+":$", rune(name[0])) {
+		return fmt.Errorf("%w: %q is not a nickname", ErrInvalidTarget, name)
+	}
+	if strings.ContainsAny(name, " ,*?!@"+lineBreakers) {
+		return fmt.Errorf("%w: %q contains characters not allowed in nicknames", ErrInvalidTarget, name)
+	}
+	if maxLength > 0 && len(name) > maxLength {
+		return fmt.Errorf("%w: %q is longer than %d bytes", ErrInvalidTarget, name, maxLength)
+	}
+	return nil
+}
+
+func chanTypes(isupport map[string]string) string {
+	if types, ok := isupport["CHANTYPES"]; ok {
+		return types
+	}
+	return defaultChanTypes
+}
+
+// isupportLength returns a length limit advertised by the server, or zero if it doesn't advertise one
+func isupportLength(isupport map[string]string, key string) int {
+	length, err := strconv.Atoi(isupport[key])
+	if err != nil || length < 0 {
+		return 0
+	}
+	return length
+}
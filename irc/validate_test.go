@@ -0,0 +1,109 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircevent"
+)
+
+func Test_validateChannel(t *testing.T) {
+	tests := []struct {
+		name      string
+		chanTypes string
+		maxLength int
+		wantErr   bool
+	}{
+		{name: "#test", chanTypes: "#&"},
+		{name: "&test", chanTypes: "#&"},
+		{name: "&test", chanTypes: "#", wantErr: true},
+		{name: "test", chanTypes: "#&", wantErr: true},
+		{name: "#", chanTypes: "#&", wantErr: true},
+		{name: "#test message", chanTypes: "#", wantErr: true},
+		{name: "#a,#b", chanTypes: "#", wantErr: true},
+		{name: "#test\r\nQUIT", chanTypes: "#", wantErr: true},
+		{name: "#test\x00", chanTypes: "#", wantErr: true},
+		{name: "#toolong", chanTypes: "#", maxLength: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateChannel(tt.name, tt.chanTypes, tt.maxLength)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateChannel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidTarget) {
+				t.Errorf("validateChannel() error = %v, want ErrInvalidTarget", err)
+			}
+		})
+	}
+}
+
+func Test_validateNick(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLength int
+		wantErr   bool
+	}{
+		{name: "greboid"},
+		{name: "[bot]|away"},
+		{name: "", wantErr: true},
+		{name: "#test", wantErr: true},
+		{name: ":greboid", wantErr: true},
+		{name: "$server", wantErr: true},
+		{name: "nick name", wantErr: true},
+		{name: "nick!user@host", wantErr: true},
+		{name: "nick\r\nQUIT", wantErr: true},
+		{name: "toolong", maxLength: 5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNick(tt.name, "#&", tt.maxLength)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateNick() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSendQueue_enqueueRejectsLineBreaks(t *testing.T) {
+	queue := newSendQueue(FloodProfile{}, func(line string) error {
+		t.Errorf("sent %q, want it rejected", line)
+		return nil
+	})
+	for _, line := range []string{"PRIVMSG #test :hi\r\nQUIT", "PRIVMSG #test :hi\nQUIT", "PRIVMSG #test :hi\x00"} {
+		if err := queue.enqueue(context.Background(), line); !errors.Is(err, ErrInvalidText) {
+			t.Errorf("enqueue(%q) error = %v, want ErrInvalidText", line, err)
+		}
+	}
+}
+
+func TestConnection_SendMessageContext_Invalid(t *testing.T) {
+	connection := &Connection{
+		connection: &ircevent.Connection{Nick: "bot"},
+		queue: newSendQueue(FloodProfile{}, func(line string) error {
+			t.Errorf("sent %q, want it rejected", line)
+			return nil
+		}),
+	}
+	tests := []struct {
+		name    string
+		target  string
+		message string
+		want    error
+	}{
+		{name: "injected target", target: "#test :hi\r\nQUIT #test", message: "hello", want: ErrInvalidTarget},
+		{name: "empty target", target: "", message: "hello", want: ErrInvalidTarget},
+		{name: "NUL in message", target: "#test", message: "hello\x00", want: ErrInvalidText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := connection.SendMessageContext(context.Background(), "PRIVMSG", tt.target, tt.message); !errors.Is(err, tt.want) {
+				t.Errorf("SendMessageContext() error = %v, want %v", err, tt.want)
+			}
+			if err := connection.SendRelayMessageContext(context.Background(), tt.target, "relayed", tt.message); !errors.Is(err, tt.want) {
+				t.Errorf("SendRelayMessageContext() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/greboid/irc-bot/v5/irc"
+)
+
+// DefaultTimeout is how long shutting down may take by default before the bot gives up waiting
+const DefaultTimeout = 10 * time.Second
+
+// Manager owns the root context of the bot, which is cancelled when the bot is told to stop, and shuts the bot's
+// components down in the order they were registered once it is
+type Manager struct {
+	ctx     context.Context
+	stop    context.CancelFunc
+	release func()
+	timeout time.Duration
+	logger  irc.Logger
+	mutex   sync.Mutex
+	hooks   []hook
+}
+
+type hook struct {
+	name     string
+	shutdown func(context.Context) error
+}
+
+// New returns a Manager whose context is cancelled by SIGINT or SIGTERM, shutting down may take up to timeout.  A
+// second signal while shutting down kills the bot.
+func New(logger irc.Logger, timeout time.Duration) *Manager {
+	ctx, release := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	manager := newManager(ctx, logger, timeout)
+	manager.release = release
+	return manager
+}
+
+func newManager(parent context.Context, logger irc.Logger, timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, stop := context.WithCancel(parent)
+	return &Manager{
+		ctx:     ctx,
+		stop:    stop,
+		release: func() {},
+		timeout: timeout,
+		logger:  logger,
+	}
+}
+
+// Context returns the root context, which is done once the bot starts shutting down
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Stop starts shutting the bot down
+func (m *Manager) Stop() {
+	m.stop()
+}
+
+// OnShutdown registers a component to shut down, components are shut down one at a time in the order they were
+// registered and share the deadline
+func (m *Manager) OnShutdown(name string, shutdown func(context.Context) error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.hooks = append(m.hooks, hook{name: name, shutdown: shutdown})
+}
+
+// Wait blocks until the root context is done, then shuts every component down.  It returns an error if any component
+// failed to shut down cleanly, including not finishing before the deadline.
+func (m *Manager) Wait() error {
+	<-m.ctx.Done()
+	m.release()
+	m.logger.Infof("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	m.mutex.Lock()
+	hooks := append([]hook(nil), m.hooks...)
+	m.mutex.Unlock()
+	var failed error
+	for _, hook := range hooks {
+		m.logger.Debugf("Shutting down %s", hook.name)
+		if err := hook.shutdown(ctx); err != nil {
+			m.logger.Errorf("Unable to shut down %s cleanly: %s", hook.name, err)
+			if failed == nil {
+				failed = fmt.Errorf("%s: %w", hook.name, err)
+			}
+		}
+	}
+	return failed
+}
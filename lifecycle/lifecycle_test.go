@@ -0,0 +1,86 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Debugf(template string, args ...interface{}) { l.t.Logf(template, args...) }
+func (l testLogger) Infof(template string, args ...interface{})  { l.t.Logf(template, args...) }
+func (l testLogger) Warnf(template string, args ...interface{})  { l.t.Logf(template, args...) }
+func (l testLogger) Errorf(template string, args ...interface{}) { l.t.Logf(template, args...) }
+func (l testLogger) Panicf(template string, args ...interface{}) { l.t.Logf(template, args...) }
+func (l testLogger) Fatalf(template string, args ...interface{}) { l.t.Fatalf(template, args...) }
+
+func TestManager_Wait(t *testing.T) {
+	tests := []struct {
+		name      string
+		hooks     map[string]func(context.Context) error
+		wantOrder []string
+		wantErr   error
+	}{
+		{
+			name: "in order",
+			hooks: map[string]func(context.Context) error{
+				"rpc": func(context.Context) error { return nil },
+				"irc": func(context.Context) error { return nil },
+			},
+			wantOrder: []string{"rpc", "irc"},
+		},
+		{
+			name: "failure doesn't stop later hooks",
+			hooks: map[string]func(context.Context) error{
+				"rpc": func(context.Context) error { return errors.New("failed") },
+				"irc": func(context.Context) error { return nil },
+			},
+			wantOrder: []string{"rpc", "irc"},
+			wantErr:   errors.New("rpc: failed"),
+		},
+		{
+			name: "deadline",
+			hooks: map[string]func(context.Context) error{
+				"rpc": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+				"irc": func(ctx context.Context) error { return ctx.Err() },
+			},
+			wantOrder: []string{"rpc", "irc"},
+			wantErr:   context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := newManager(context.Background(), testLogger{t: t}, 50*time.Millisecond)
+			var order []string
+			for _, name := range []string{"rpc", "irc"} {
+				name := name
+				manager.OnShutdown(name, func(ctx context.Context) error {
+					order = append(order, name)
+					return tt.hooks[name](ctx)
+				})
+			}
+			if manager.Context().Err() != nil {
+				t.Fatal("Context() done before Stop()")
+			}
+			manager.Stop()
+			err := manager.Wait()
+			if !reflect.DeepEqual(order, tt.wantOrder) {
+				t.Errorf("Wait() order = %v, want %v", order, tt.wantOrder)
+			}
+			if (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Wait() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error() {
+				t.Errorf("Wait() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,17 +3,23 @@ package plugins
 import (
 	"context"
 	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
+	"github.com/greboid/irc-bot/v5/irc"
 	"github.com/greboid/irc-bot/v5/rpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type PluginHelper struct {
 	RPCTarget     string
 	RPCToken      string
+	tlsConfig     *tls.Config
 	rpcConnection *grpc.ClientConn
 	httpClient    rpc.HTTPPluginClient
 	ircClient     rpc.IRCPluginClient
@@ -21,12 +27,14 @@ type PluginHelper struct {
 
 //NewHelper returns a PluginHelper that simplifies writing plugins by managing grpc connections and exposing a simple
 //interface.
+//Targets starting with unix:// connect to the bot's Unix socket, where the token may be empty if the bot
+//authenticates the plugin by its user.
 //It returns a PluginHelper or any errors encountered whilst creating
 func NewHelper(target string, rpctoken string) (*PluginHelper, error) {
 	if len(target) == 0 {
 		return nil, fmt.Errorf("gRPC target name needs to be set")
 	}
-	if len(rpctoken) == 0 {
+	if len(rpctoken) == 0 && !isSocket(target) {
 		return nil, fmt.Errorf("plugin RPC token must be set")
 	}
 	return &PluginHelper{
@@ -35,9 +43,70 @@ func NewHelper(target string, rpctoken string) (*PluginHelper, error) {
 	}, nil
 }
 
+// NewCertificateHelper returns a PluginHelper that authenticates to the bot with a client certificate rather than a
+// token, the bot maps the certificate's common name to the plugin
+func NewCertificateHelper(target string, certFile string, keyFile string) (*PluginHelper, error) {
+	if len(target) == 0 {
+		return nil, fmt.Errorf("gRPC target name needs to be set")
+	}
+	helper := &PluginHelper{RPCTarget: target}
+	if err := helper.UseCertificate(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return helper, nil
+}
+
+// PinCertificate only accepts the bot's certificate if it has the given SHA-256 fingerprint, which the bot logs on
+// startup.  Without a pin the bot's certificate isn't verified.  It must be called before any clients are created.
+func (h *PluginHelper) PinCertificate(fingerprint string) error {
+	pinned, err := irc.ParseFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	want := hex.EncodeToString(pinned)
+	h.clientTLS().VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("bot sent no certificate")
+		}
+		if got := irc.CertificateFingerprint(state.PeerCertificates[0].Raw); got != want {
+			return fmt.Errorf("bot certificate fingerprint %s doesn't match the pinned fingerprint", got)
+		}
+		return nil
+	}
+	return nil
+}
+
+// UseCertificate presents a client certificate to the bot for mutual TLS.  It must be called before any clients are
+// created.
+func (h *PluginHelper) UseCertificate(certFile string, keyFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	h.clientTLS().Certificates = []tls.Certificate{certificate}
+	return nil
+}
+
+// clientTLS returns the TLS config used to connect to the bot, the bot's certificate is checked against the pinned
+// fingerprint if there is one rather than a CA
+func (h *PluginHelper) clientTLS() *tls.Config {
+	if h.tlsConfig == nil {
+		h.tlsConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return h.tlsConfig
+}
+
+// isSocket returns true if the target is the bot's Unix socket, which doesn't use TLS
+func isSocket(target string) bool {
+	return strings.HasPrefix(target, "unix:")
+}
+
 func (h *PluginHelper) rpcClient(ctx context.Context) (*grpc.ClientConn, error) {
 	if h.rpcConnection == nil {
-		creds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
+		creds := credentials.NewTLS(h.clientTLS())
+		if isSocket(h.RPCTarget) {
+			creds = insecure.NewCredentials()
+		}
 		conn, err := grpc.DialContext(ctx, h.RPCTarget, grpc.WithTransportCredentials(creds))
 		if err != nil {
 			return nil, err
@@ -214,3 +283,210 @@ func (h *PluginHelper) RegisterChannelMessageHandlerWithContext(ctx context.Cont
 		handler(message)
 	}
 }
+
+func (h *PluginHelper) RegisterEventHandler(filter *rpc.EventFilter, handler func(event *rpc.Event)) error {
+	return h.RegisterEventHandlerWithContext(context.Background(), filter, handler)
+}
+
+func (h *PluginHelper) RegisterEventHandlerWithContext(ctx context.Context, filter *rpc.EventFilter, handler func(event *rpc.Event)) error {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := ircClient.GetEvents(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), filter)
+	if err != nil {
+		return err
+	}
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		handler(event)
+	}
+}
+
+// RegisterConnectionStateHandler calls the handler with the state of the bot's connection to IRC, then whenever it
+// changes
+func (h *PluginHelper) RegisterConnectionStateHandler(handler func(state *rpc.ConnectionState)) error {
+	return h.RegisterConnectionStateHandlerWithContext(context.Background(), handler)
+}
+
+func (h *PluginHelper) RegisterConnectionStateHandlerWithContext(ctx context.Context, handler func(state *rpc.ConnectionState)) error {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := ircClient.GetConnectionState(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.Empty{})
+	if err != nil {
+		return err
+	}
+	for {
+		state, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		handler(state)
+	}
+}
+
+func (h *PluginHelper) SendPrivateMessage(nick string, messages ...string) error {
+	return h.SendPrivateMessageWithContext(context.Background(), nick, messages...)
+}
+
+func (h *PluginHelper) SendPrivateMessageWithContext(ctx context.Context, nick string, messages ...string) error {
+	return h.sendPrivate(ctx, nick, false, messages...)
+}
+
+func (h *PluginHelper) SendPrivateNotice(nick string, messages ...string) error {
+	return h.SendPrivateNoticeWithContext(context.Background(), nick, messages...)
+}
+
+func (h *PluginHelper) SendPrivateNoticeWithContext(ctx context.Context, nick string, messages ...string) error {
+	return h.sendPrivate(ctx, nick, true, messages...)
+}
+
+func (h *PluginHelper) sendPrivate(ctx context.Context, nick string, notice bool, messages ...string) error {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	for index := range messages {
+		_, err := ircClient.SendPrivateMessage(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.PrivateMessage{
+			Nick:    nick,
+			Message: messages[index],
+			Notice:  notice,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *PluginHelper) RegisterPrivateMessageHandler(handler func(message *rpc.PrivateMessage)) error {
+	return h.RegisterPrivateMessageHandlerWithContext(context.Background(), handler)
+}
+
+func (h *PluginHelper) RegisterPrivateMessageHandlerWithContext(ctx context.Context, handler func(message *rpc.PrivateMessage)) error {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := ircClient.GetPrivateMessages(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.Empty{})
+	if err != nil {
+		return err
+	}
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		handler(message)
+	}
+}
+
+func (h *PluginHelper) RegisterCommands(commands []*rpc.Command, handler func(invocation *rpc.CommandInvocation)) error {
+	return h.RegisterCommandsWithContext(context.Background(), commands, handler)
+}
+
+func (h *PluginHelper) RegisterCommandsWithContext(ctx context.Context, commands []*rpc.Command, handler func(invocation *rpc.CommandInvocation)) error {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := ircClient.RegisterCommands(
+		rpc.CtxWithToken(ctx, "bearer", h.RPCToken),
+		&rpc.CommandRegistration{Commands: commands},
+	)
+	if err != nil {
+		return err
+	}
+	for {
+		invocation, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		handler(invocation)
+	}
+}
+
+func (h *PluginHelper) CheckPermission(source string, channel string, permission string) (bool, error) {
+	return h.CheckPermissionWithContext(context.Background(), source, channel, permission)
+}
+
+func (h *PluginHelper) CheckPermissionWithContext(ctx context.Context, source string, channel string, permission string) (bool, error) {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	result, err := ircClient.CheckPermission(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.PermissionCheck{
+		Source:     source,
+		Channel:    channel,
+		Permission: permission,
+	})
+	if err != nil {
+		return false, err
+	}
+	return result.Allowed, nil
+}
+
+func (h *PluginHelper) GetChannelUsers(channel string) ([]*rpc.ChannelUser, error) {
+	return h.GetChannelUsersWithContext(context.Background(), channel)
+}
+
+func (h *PluginHelper) GetChannelUsersWithContext(ctx context.Context, channel string) ([]*rpc.ChannelUser, error) {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := ircClient.GetChannelUsers(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.Channel{Name: channel})
+	if err != nil {
+		return nil, err
+	}
+	return list.Users, nil
+}
+
+func (h *PluginHelper) GetChannelTopic(channel string) (*rpc.ChannelTopic, error) {
+	return h.GetChannelTopicWithContext(context.Background(), channel)
+}
+
+func (h *PluginHelper) GetChannelTopicWithContext(ctx context.Context, channel string) (*rpc.ChannelTopic, error) {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ircClient.GetChannelTopic(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.Channel{Name: channel})
+}
+
+func (h *PluginHelper) GetChannelModes(channel string) (map[string]string, error) {
+	return h.GetChannelModesWithContext(context.Background(), channel)
+}
+
+func (h *PluginHelper) GetChannelModesWithContext(ctx context.Context, channel string) (map[string]string, error) {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	modes, err := ircClient.GetChannelModes(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.Channel{Name: channel})
+	if err != nil {
+		return nil, err
+	}
+	return modes.Modes, nil
+}
+
+func (h *PluginHelper) GetQueueDepth() (plugin int, total int, err error) {
+	return h.GetQueueDepthWithContext(context.Background())
+}
+
+func (h *PluginHelper) GetQueueDepthWithContext(ctx context.Context) (plugin int, total int, err error) {
+	ircClient, err := h.IRCClientWithContext(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	depth, err := ircClient.GetQueueDepth(rpc.CtxWithToken(ctx, "bearer", h.RPCToken), &rpc.Empty{})
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(depth.Plugin), int(depth.Total), nil
+}
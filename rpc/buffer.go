@@ -0,0 +1,639 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/irc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	subscriptionEvents   = "events"
+	subscriptionMessages = "messages"
+	subscriptionPrivate  = "private"
+	subscriptionWebhook  = "webhook"
+	// maxBufferSubscriptions is how many streams are remembered for each plugin, the oldest closed stream is
+	// forgotten when a plugin opens more
+	maxBufferSubscriptions = 20
+)
+
+// bufferSubscription is a stream a plugin has opened, whatever it would have received is buffered while it is closed
+type bufferSubscription struct {
+	Kind     string   `json:"kind"`
+	Types    []string `json:"types,omitempty"`
+	Channels []string `json:"channels,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+	Channel  string   `json:"channel,omitempty"`
+	Path     string   `json:"path,omitempty"`
+
+	active    int
+	callbacks []ircevent.CallbackID
+}
+
+func (s *bufferSubscription) same(other *bufferSubscription) bool {
+	return s.Kind == other.Kind && reflect.DeepEqual(s.Types, other.Types) &&
+		reflect.DeepEqual(s.Channels, other.Channels) && reflect.DeepEqual(s.Sources, other.Sources) &&
+		s.Channel == other.Channel && s.Path == other.Path
+}
+
+func (s *bufferSubscription) filter() *EventFilter {
+	return &EventFilter{Types: s.Types, Channels: s.Channels, Sources: s.Sources}
+}
+
+// allowed returns true if the plugin's permissions still allow it to open the stream
+func (s *bufferSubscription) allowed(plugin *Plugin) bool {
+	switch s.Kind {
+	case subscriptionEvents:
+		return authoriseChannels(plugin, ScopeRead, s.Channels) == nil
+	case subscriptionMessages:
+		return plugin.Allowed(ScopeRead, s.Channel)
+	case subscriptionPrivate:
+		return plugin.HasScope(ScopeQuery)
+	case subscriptionWebhook:
+		return plugin.Allowed(ScopeHTTP, s.Path)
+	}
+	return false
+}
+
+// bufferState is the contents of a buffer's file, items are stored as marshalled BufferedItems
+type bufferState struct {
+	NextID        uint64                `json:"next-id"`
+	Items         [][]byte              `json:"items"`
+	Subscriptions []*bufferSubscription `json:"subscriptions"`
+}
+
+// pluginBuffer is a bounded queue, kept on disk, of everything a plugin's closed streams would have received.  Changes
+// are written to disk in the background so buffering doesn't hold up the IRC callbacks.
+type pluginBuffer struct {
+	mutex         sync.Mutex
+	saving        sync.Mutex
+	path          string
+	limit         int
+	nextID        uint64
+	items         []*BufferedItem
+	subscriptions []*bufferSubscription
+	closed        bool
+	dirty         bool
+	changed       chan struct{}
+	plugin        *Plugin
+	logger        irc.Logger
+}
+
+// loadBuffer reads a buffer from its file, a missing file is an empty buffer
+func loadBuffer(path string, limit int, logger irc.Logger) (*pluginBuffer, error) {
+	buffer := &pluginBuffer{path: path, limit: limit, nextID: 1, changed: make(chan struct{}, 1), logger: logger}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		go buffer.run()
+		return buffer, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := bufferState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	for _, data := range state.Items {
+		item := &BufferedItem{}
+		if err := proto.Unmarshal(data, item); err != nil {
+			return nil, err
+		}
+		buffer.items = append(buffer.items, item)
+	}
+	if state.NextID > buffer.nextID {
+		buffer.nextID = state.NextID
+	}
+	buffer.subscriptions = state.Subscriptions
+	buffer.trim()
+	go buffer.run()
+	return buffer, nil
+}
+
+// run writes the buffer whenever it changes, until it is closed
+func (b *pluginBuffer) run() {
+	for range b.changed {
+		if err := b.flush(); err != nil {
+			b.logger.Errorf("Unable to save buffer %s: %s", b.path, err)
+		}
+	}
+}
+
+// changedLater marks the buffer as changed so it is written in the background.  The caller must hold the mutex.
+func (b *pluginBuffer) changedLater() {
+	b.dirty = true
+	if b.closed {
+		return
+	}
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// flush writes the buffer if it has changed since it was last written.  Only copying the buffer holds the mutex, so
+// items can still be added while it is written.
+func (b *pluginBuffer) flush() error {
+	b.saving.Lock()
+	defer b.saving.Unlock()
+	b.mutex.Lock()
+	if !b.dirty {
+		b.mutex.Unlock()
+		return nil
+	}
+	b.dirty = false
+	nextID := b.nextID
+	items := append([]*BufferedItem(nil), b.items...)
+	subscriptions := append([]*bufferSubscription(nil), b.subscriptions...)
+	b.mutex.Unlock()
+	if err := saveBuffer(b.path, nextID, items, subscriptions); err != nil {
+		b.mutex.Lock()
+		b.dirty = true
+		b.mutex.Unlock()
+		return err
+	}
+	return nil
+}
+
+// saveBuffer writes a buffer to a temporary file then replaces its file, so a crash can't leave it truncated
+func saveBuffer(path string, nextID uint64, items []*BufferedItem, subscriptions []*bufferSubscription) error {
+	state := bufferState{NextID: nextID, Items: make([][]byte, 0, len(items)), Subscriptions: subscriptions}
+	for _, item := range items {
+		data, err := proto.Marshal(item)
+		if err != nil {
+			return err
+		}
+		state.Items = append(state.Items, data)
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(temp.Name())
+	}()
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// trim drops the oldest items beyond the limit.  The caller must hold the mutex.
+func (b *pluginBuffer) trim() {
+	if dropped := len(b.items) - b.limit; dropped > 0 {
+		b.logger.Warnf("Buffer %s is full, dropped %d items", b.path, dropped)
+		b.items = append([]*BufferedItem(nil), b.items[dropped:]...)
+	}
+}
+
+// add gives the item the next id and buffers it, dropping the oldest item if the buffer is full
+func (b *pluginBuffer) add(item *BufferedItem) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	item.Id = b.nextID
+	b.nextID++
+	b.items = append(b.items, item)
+	b.trim()
+	b.changedLater()
+}
+
+// pending returns the buffered items, oldest first
+func (b *pluginBuffer) pending() []*BufferedItem {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]*BufferedItem(nil), b.items...)
+}
+
+// acknowledge removes the items with the given ids and writes the buffer, ids that aren't buffered are ignored
+func (b *pluginBuffer) acknowledge(ids []uint64) error {
+	b.mutex.Lock()
+	acknowledged := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		acknowledged[id] = true
+	}
+	items := b.items[:0]
+	for _, item := range b.items {
+		if !acknowledged[item.Id] {
+			items = append(items, item)
+		}
+	}
+	if len(items) < len(b.items) {
+		b.items = items
+		b.dirty = true
+	}
+	b.mutex.Unlock()
+	return b.flush()
+}
+
+func (b *pluginBuffer) setLimit(limit int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.limit = limit
+	b.trim()
+}
+
+// setPlugin updates the plugin the buffer belongs to and forgets the streams it is no longer allowed to open, returning
+// the callbacks that were buffering them so they can be removed
+func (b *pluginBuffer) setPlugin(plugin *Plugin) (callbacks []ircevent.CallbackID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.plugin = plugin
+	subscriptions := make([]*bufferSubscription, 0, len(b.subscriptions))
+	for _, subscription := range b.subscriptions {
+		if subscription.allowed(plugin) {
+			subscriptions = append(subscriptions, subscription)
+			continue
+		}
+		callbacks = append(callbacks, subscription.callbacks...)
+		subscription.callbacks = nil
+	}
+	if len(subscriptions) < len(b.subscriptions) {
+		b.subscriptions = subscriptions
+		b.changedLater()
+	}
+	return
+}
+
+// owner returns the plugin the buffer belongs to
+func (b *pluginBuffer) owner() *Plugin {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.plugin
+}
+
+// remembered returns true if the stream hasn't been forgotten.  The caller must hold the mutex.
+func (b *pluginBuffer) remembered(subscription *bufferSubscription) bool {
+	for _, existing := range b.subscriptions {
+		if existing == subscription {
+			return true
+		}
+	}
+	return false
+}
+
+// activate records a stream being opened, remembering it if it is new, and returns the callbacks that were
+// buffering what it receives so they can be removed
+func (b *pluginBuffer) activate(subscription *bufferSubscription) (*bufferSubscription, []ircevent.CallbackID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, existing := range b.subscriptions {
+		if existing.same(subscription) {
+			existing.active++
+			callbacks := existing.callbacks
+			existing.callbacks = nil
+			return existing, callbacks
+		}
+	}
+	subscription.active = 1
+	b.subscriptions = append(b.subscriptions, subscription)
+	var callbacks []ircevent.CallbackID
+	if len(b.subscriptions) > maxBufferSubscriptions {
+		for index, existing := range b.subscriptions {
+			if existing.active == 0 {
+				callbacks = existing.callbacks
+				b.subscriptions = append(b.subscriptions[:index], b.subscriptions[index+1:]...)
+				break
+			}
+		}
+	}
+	b.changedLater()
+	return subscription, callbacks
+}
+
+// deactivate records a stream being closed, returning true if no other stream is receiving the same things
+func (b *pluginBuffer) deactivate(subscription *bufferSubscription) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscription.active--
+	return subscription.active == 0 && !b.closed
+}
+
+// setCallbacks stores the callbacks buffering what a closed stream would receive.  If the stream has been reopened or
+// forgotten, or the buffer closed, in the meantime the callbacks are returned so they can be removed.
+func (b *pluginBuffer) setCallbacks(subscription *bufferSubscription, callbacks []ircevent.CallbackID) []ircevent.CallbackID {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if subscription.active > 0 || b.closed || !b.remembered(subscription) {
+		return callbacks
+	}
+	subscription.callbacks = append(subscription.callbacks, callbacks...)
+	return nil
+}
+
+// inactive returns the closed streams that aren't being buffered yet
+func (b *pluginBuffer) inactive() (subscriptions []*bufferSubscription) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, subscription := range b.subscriptions {
+		if subscription.active == 0 && len(subscription.callbacks) == 0 {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	return
+}
+
+// close stops the buffer once anything changed has been written, returning the callbacks that were filling it so they
+// can be removed
+func (b *pluginBuffer) close() (callbacks []ircevent.CallbackID) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.closed {
+		close(b.changed)
+	}
+	b.closed = true
+	for _, subscription := range b.subscriptions {
+		callbacks = append(callbacks, subscription.callbacks...)
+		subscription.callbacks = nil
+	}
+	return
+}
+
+// buffersWebhook returns true if the plugin registered a prefix matching the path but isn't currently listening
+func (b *pluginBuffer) buffersWebhook(path string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, subscription := range b.subscriptions {
+		if subscription.Kind == subscriptionWebhook && subscription.active == 0 &&
+			matchesPath(strings.TrimPrefix(subscription.Path, "/"), strings.TrimPrefix(path, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferRegistry holds the buffers of the plugins configured to have one, keyed on the plugin's name
+type bufferRegistry struct {
+	mutex   sync.Mutex
+	dir     string
+	buffers map[string]*pluginBuffer
+	server  *pluginServer
+	logger  irc.Logger
+}
+
+func newBufferRegistry(logger irc.Logger) *bufferRegistry {
+	return &bufferRegistry{buffers: map[string]*pluginBuffer{}, logger: logger}
+}
+
+// setDir sets the directory buffers are kept in, buffers are disabled until it is set
+func (r *bufferRegistry) setDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dir = dir
+	return nil
+}
+
+// set creates buffers for new plugins, loading anything buffered before a restart, updates the limits of existing
+// ones and closes those of plugins that no longer have one.  Streams the plugins are no longer allowed to open stop
+// being buffered.  Closed buffers are left on disk.
+func (r *bufferRegistry) set(plugins []Plugin) error {
+	r.mutex.Lock()
+	var errs []error
+	var started []*pluginBuffer
+	var removed []ircevent.CallbackID
+	wanted := map[string]bool{}
+	for _, plugin := range plugins {
+		if plugin.Buffer < 1 || len(r.dir) == 0 {
+			continue
+		}
+		wanted[plugin.Name] = true
+		if buffer, ok := r.buffers[plugin.Name]; ok {
+			buffer.setLimit(plugin.Buffer)
+			removed = append(removed, buffer.setPlugin(&plugin)...)
+			continue
+		}
+		buffer, err := loadBuffer(filepath.Join(r.dir, url.PathEscape(plugin.Name)+".json"), plugin.Buffer, r.logger)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		buffer.setPlugin(&plugin)
+		r.buffers[plugin.Name] = buffer
+		started = append(started, buffer)
+	}
+	for name, buffer := range r.buffers {
+		if !wanted[name] {
+			removed = append(removed, buffer.close()...)
+			delete(r.buffers, name)
+		}
+	}
+	server := r.server
+	r.mutex.Unlock()
+	if server != nil {
+		server.removeCallbacks(removed)
+		for _, buffer := range started {
+			server.captureInactive(buffer)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// start begins buffering what the plugins' closed streams would receive
+func (r *bufferRegistry) start(server *pluginServer) {
+	r.mutex.Lock()
+	r.server = server
+	buffers := make([]*pluginBuffer, 0, len(r.buffers))
+	for _, buffer := range r.buffers {
+		buffers = append(buffers, buffer)
+	}
+	r.mutex.Unlock()
+	for _, buffer := range buffers {
+		server.captureInactive(buffer)
+	}
+}
+
+// flush writes every buffer that has changed since it was last written
+func (r *bufferRegistry) flush() error {
+	r.mutex.Lock()
+	buffers := make([]*pluginBuffer, 0, len(r.buffers))
+	for _, buffer := range r.buffers {
+		buffers = append(buffers, buffer)
+	}
+	r.mutex.Unlock()
+	var errs []error
+	for _, buffer := range buffers {
+		errs = append(errs, buffer.flush())
+	}
+	return errors.Join(errs...)
+}
+
+// find returns the buffer of the plugin in the context, or nil if it doesn't have one
+func (r *bufferRegistry) find(ctx context.Context) *pluginBuffer {
+	if r == nil {
+		return nil
+	}
+	plugin, ok := PluginFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.buffers[plugin.Name]
+}
+
+// subscribe records the plugin in the context opening a stream, stopping what it receives being buffered until the
+// returned function is called when the stream closes
+func (r *bufferRegistry) subscribe(ctx context.Context, subscription *bufferSubscription) func() {
+	buffer := r.find(ctx)
+	if buffer == nil {
+		return func() {}
+	}
+	subscription, callbacks := buffer.activate(subscription)
+	r.mutex.Lock()
+	server := r.server
+	r.mutex.Unlock()
+	if server != nil {
+		server.removeCallbacks(callbacks)
+	}
+	return func() {
+		if buffer.deactivate(subscription) && server != nil {
+			server.removeCallbacks(buffer.setCallbacks(subscription, server.capture(buffer, subscription)))
+		}
+	}
+}
+
+// webhookBuffer returns the buffer of a plugin that registered a prefix matching the path but isn't listening, or
+// nil if there isn't one
+func (r *bufferRegistry) webhookBuffer(path string) *pluginBuffer {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, buffer := range r.buffers {
+		if buffer.buffersWebhook(path) {
+			return buffer
+		}
+	}
+	return nil
+}
+
+// captureInactive starts buffering what each of the buffer's closed streams would receive
+func (ps *pluginServer) captureInactive(buffer *pluginBuffer) {
+	for _, subscription := range buffer.inactive() {
+		ps.removeCallbacks(buffer.setCallbacks(subscription, ps.capture(buffer, subscription)))
+	}
+}
+
+// capture adds callbacks buffering what a closed stream would have received, webhooks are buffered by the HTTP
+// server instead
+func (ps *pluginServer) capture(buffer *pluginBuffer, subscription *bufferSubscription) (callbacks []ircevent.CallbackID) {
+	switch subscription.Kind {
+	case subscriptionEvents:
+		filter := subscription.filter()
+		for _, eventType := range eventTypes(filter) {
+			callbacks = append(callbacks, ps.functions.AddCallback(eventType, func(message ircmsg.Message) {
+				if event := ps.event(filter, message); event != nil {
+					buffer.add(&BufferedItem{Item: &BufferedItem_Event{Event: event}})
+				}
+			}))
+		}
+	case subscriptionMessages:
+		callbacks = append(callbacks, ps.functions.AddCallback("PRIVMSG", func(message ircmsg.Message) {
+			if ps.matchesChannel(buffer.owner(), subscription.Channel, message) {
+				buffer.add(&BufferedItem{Item: &BufferedItem_Message{Message: ps.channelMessage(&message)}})
+			}
+		}))
+	case subscriptionPrivate:
+		handler := func(message ircmsg.Message) {
+			if privateMessage := ps.privateMessage(message); privateMessage != nil {
+				buffer.add(&BufferedItem{Item: &BufferedItem_PrivateMessage{PrivateMessage: privateMessage}})
+			}
+		}
+		callbacks = append(callbacks, ps.functions.AddCallback("PRIVMSG", handler))
+		callbacks = append(callbacks, ps.functions.AddCallback("NOTICE", handler))
+	}
+	return
+}
+
+func (ps *pluginServer) removeCallbacks(callbacks []ircevent.CallbackID) {
+	for _, callback := range callbacks {
+		ps.functions.RemoveCallback(callback)
+	}
+}
+
+// bufferedAllowed returns true if the plugin may still see the item, its permissions may have changed since the item
+// was buffered
+func (ps *pluginServer) bufferedAllowed(plugin *Plugin, item *BufferedItem) bool {
+	if plugin == nil {
+		return true
+	}
+	switch buffered := item.Item.(type) {
+	case *BufferedItem_Event:
+		message := ircmsg.Message{Command: buffered.Event.Type, Params: buffered.Event.Params}
+		return ps.eventAllowed(plugin, message, buffered.Event.Channel)
+	case *BufferedItem_Message:
+		if ps.isMe(buffered.Message.Channel) {
+			return plugin.HasScope(ScopeQuery)
+		}
+		return plugin.Allowed(ScopeRead, buffered.Message.Channel)
+	case *BufferedItem_PrivateMessage:
+		return plugin.HasScope(ScopeQuery)
+	case *BufferedItem_Request:
+		return plugin.Allowed(ScopeHTTP, buffered.Request.Path)
+	}
+	return false
+}
+
+// GetBuffered sends the plugin its buffered items, items it is no longer allowed to see are dropped instead
+func (ps *pluginServer) GetBuffered(_ *Empty, stream IRCPlugin_GetBufferedServer) error {
+	buffer := ps.buffers.find(stream.Context())
+	if buffer == nil {
+		return nil
+	}
+	plugin, _ := PluginFromContext(stream.Context())
+	var forbidden []uint64
+	for _, item := range buffer.pending() {
+		select {
+		case <-ps.stopping:
+			return nil
+		default:
+		}
+		if !ps.bufferedAllowed(plugin, item) {
+			forbidden = append(forbidden, item.Id)
+			continue
+		}
+		if err := stream.Send(item); err != nil {
+			return err
+		}
+	}
+	if len(forbidden) > 0 {
+		if err := buffer.acknowledge(forbidden); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+func (ps *pluginServer) Acknowledge(ctx context.Context, acknowledgement *Acknowledgement) (*Empty, error) {
+	buffer := ps.buffers.find(ctx)
+	if buffer == nil {
+		return &Empty{}, nil
+	}
+	if err := buffer.acknowledge(acknowledgement.Ids); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &Empty{}, nil
+}
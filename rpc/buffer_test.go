@@ -0,0 +1,202 @@
+package rpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"go.uber.org/zap"
+)
+
+type fakeBufferedStream struct {
+	IRCPlugin_GetBufferedServer
+	ctx  context.Context
+	sent []*BufferedItem
+}
+
+func (s *fakeBufferedStream) Context() context.Context { return s.ctx }
+
+func (s *fakeBufferedStream) Send(item *BufferedItem) error {
+	s.sent = append(s.sent, item)
+	return nil
+}
+
+func Test_pluginBuffer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.json")
+	buffer, err := loadBuffer(path, 2, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("loadBuffer() error = %v", err)
+	}
+	for _, text := range []string{"one", "two", "three"} {
+		buffer.add(&BufferedItem{Item: &BufferedItem_PrivateMessage{PrivateMessage: &PrivateMessage{Message: text}}})
+	}
+	if err := buffer.acknowledge([]uint64{2, 42}); err != nil {
+		t.Fatalf("acknowledge() error = %v", err)
+	}
+	loaded, err := loadBuffer(path, 2, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("loadBuffer() error = %v", err)
+	}
+	pending := loaded.pending()
+	if len(pending) != 1 || pending[0].Id != 3 || pending[0].GetPrivateMessage().Message != "three" {
+		t.Errorf("pending() = %v, want only the newest unacknowledged item", pending)
+	}
+	loaded.add(&BufferedItem{})
+	if pending := loaded.pending(); pending[len(pending)-1].Id != 4 {
+		t.Errorf("add() id = %d after reloading, want 4", pending[len(pending)-1].Id)
+	}
+	if err := loaded.flush(); err != nil {
+		t.Fatalf("flush() error = %v", err)
+	}
+	reloaded, err := loadBuffer(path, 2, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("loadBuffer() error = %v", err)
+	}
+	if pending := reloaded.pending(); len(pending) != 2 || pending[1].Id != 4 {
+		t.Errorf("pending() = %v after flushing, want the added item saved", pending)
+	}
+}
+
+func Test_bufferRegistry(t *testing.T) {
+	dir := t.TempDir()
+	plugins := []Plugin{{Name: "plugin", Token: "abc", Buffer: 10}, {Name: "other", Token: "def"}}
+	registry := newBufferRegistry(zap.NewNop().Sugar())
+	if err := registry.setDir(dir); err != nil {
+		t.Fatalf("setDir() error = %v", err)
+	}
+	if err := registry.set(plugins); err != nil {
+		t.Fatalf("set() error = %v", err)
+	}
+	functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+	ps := &pluginServer{functions: functions, buffers: registry}
+	registry.start(ps)
+	ctx := contextWithPlugin(context.Background(), &Plugin{Name: "plugin"})
+
+	release := registry.subscribe(ctx, &bufferSubscription{Kind: subscriptionPrivate})
+	if len(functions.callbacks) != 0 {
+		t.Fatalf("subscribe() buffering %v while the stream is open", functions.callbacks)
+	}
+	release()
+	registry.subscribe(ctx, &bufferSubscription{Kind: subscriptionWebhook, Path: "hook"})()
+	for _, line := range []string{":nick!user@host PRIVMSG bot :hello", ":nick!user@host PRIVMSG #test :not for us"} {
+		message, _ := ircmsg.ParseLine(line)
+		functions.callbacks["PRIVMSG"](message)
+	}
+	if registry.webhookBuffer("/hook/push") == nil || registry.webhookBuffer("/hook") == nil ||
+		registry.webhookBuffer("/elsewhere") != nil || registry.webhookBuffer("/hooked") != nil {
+		t.Errorf("webhookBuffer() should only buffer the registered prefix")
+	}
+	registry.webhookBuffer("/hook/push").add(&BufferedItem{Item: &BufferedItem_Request{Request: &HttpRequest{Path: "/hook/push"}}})
+
+	stream := &fakeBufferedStream{ctx: ctx}
+	if err := ps.GetBuffered(&Empty{}, stream); err != nil {
+		t.Fatalf("GetBuffered() error = %v", err)
+	}
+	if len(stream.sent) != 2 || stream.sent[0].GetPrivateMessage().Message != "hello" || stream.sent[1].GetRequest().Path != "/hook/push" {
+		t.Fatalf("GetBuffered() sent %v, want the private message then the webhook", stream.sent)
+	}
+	if _, err := ps.Acknowledge(ctx, &Acknowledgement{Ids: []uint64{stream.sent[0].Id}}); err != nil {
+		t.Fatalf("Acknowledge() error = %v", err)
+	}
+	if _, err := ps.Acknowledge(contextWithPlugin(context.Background(), &Plugin{Name: "other"}), &Acknowledgement{Ids: []uint64{1}}); err != nil {
+		t.Errorf("Acknowledge() error = %v for a plugin without a buffer", err)
+	}
+
+	// A restarted bot buffers the plugin's streams straight away, and still has the unacknowledged items
+	restarted := newBufferRegistry(zap.NewNop().Sugar())
+	_ = restarted.setDir(dir)
+	_ = restarted.set(plugins)
+	restartedFunctions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+	restarted.start(&pluginServer{functions: restartedFunctions, buffers: restarted})
+	if restartedFunctions.callbacks["PRIVMSG"] == nil || restartedFunctions.callbacks["NOTICE"] == nil {
+		t.Errorf("start() callbacks = %v, want private messages buffered", restartedFunctions.callbacks)
+	}
+	if pending := restarted.find(ctx).pending(); len(pending) != 1 || pending[0].GetRequest() == nil {
+		t.Errorf("pending() = %v after restarting, want the unacknowledged webhook", pending)
+	}
+	_ = restarted.set(nil)
+	if restartedFunctions.removed != 2 {
+		t.Errorf("set() removed %d callbacks, want 2 when the plugin's buffer is removed", restartedFunctions.removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "plugin.json")); err != nil {
+		t.Errorf("buffer file should be kept when the plugin is removed: %v", err)
+	}
+}
+
+func Test_bufferRegistry_permissions(t *testing.T) {
+	plugin := Plugin{Name: "plugin", Token: "abc", Buffer: 10, Permissions: Permissions{
+		ScopeRead:  {"#ops"},
+		ScopeQuery: nil,
+		ScopeHTTP:  {"hook"},
+	}}
+	registry := newBufferRegistry(zap.NewNop().Sugar())
+	_ = registry.setDir(t.TempDir())
+	_ = registry.set([]Plugin{plugin})
+	functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+	ps := &pluginServer{functions: functions, buffers: registry}
+	registry.start(ps)
+	ctx := contextWithPlugin(context.Background(), &plugin)
+	registry.subscribe(ctx, &bufferSubscription{Kind: subscriptionMessages, Channel: "#ops"})()
+	registry.subscribe(ctx, &bufferSubscription{Kind: subscriptionPrivate})()
+	registry.subscribe(ctx, &bufferSubscription{Kind: subscriptionWebhook, Path: "hook"})()
+
+	buffer := registry.find(ctx)
+	for _, item := range []*BufferedItem{
+		{Item: &BufferedItem_Message{Message: &ChannelMessage{Channel: "#ops"}}},
+		{Item: &BufferedItem_Message{Message: &ChannelMessage{Channel: "#secret"}}},
+		{Item: &BufferedItem_Event{Event: &Event{Type: "QUIT", Params: []string{"bye"}}}},
+		{Item: &BufferedItem_PrivateMessage{PrivateMessage: &PrivateMessage{Message: "hello"}}},
+		{Item: &BufferedItem_Request{Request: &HttpRequest{Path: "/hook/push"}}},
+	} {
+		buffer.add(item)
+	}
+	stream := &fakeBufferedStream{ctx: ctx}
+	if err := ps.GetBuffered(&Empty{}, stream); err != nil {
+		t.Fatalf("GetBuffered() error = %v", err)
+	}
+	if len(stream.sent) != 3 || stream.sent[0].GetMessage().GetChannel() != "#ops" ||
+		stream.sent[1].GetPrivateMessage() == nil || stream.sent[2].GetRequest() == nil {
+		t.Errorf("GetBuffered() sent %v, want only the items the plugin may see", stream.sent)
+	}
+	if pending := buffer.pending(); len(pending) != 3 {
+		t.Errorf("pending() = %v, want the items the plugin may not see dropped", pending)
+	}
+
+	plugin.Permissions = Permissions{ScopeRead: {"#ops"}}
+	_ = registry.set([]Plugin{plugin})
+	if functions.removed != 2 {
+		t.Errorf("set() removed %d callbacks, want 2 when the plugin loses the query scope", functions.removed)
+	}
+	if registry.webhookBuffer("/hook/push") != nil {
+		t.Errorf("webhookBuffer() should not buffer a prefix the plugin may no longer register")
+	}
+	if subscriptions := buffer.inactive(); len(subscriptions) != 0 {
+		t.Errorf("inactive() = %v, want the allowed stream still buffered", subscriptions)
+	}
+}
+
+func Test_pluginServer_bufferedAllowed(t *testing.T) {
+	reader := &Plugin{Name: "reader", Permissions: Permissions{ScopeRead: nil}}
+	query := &Plugin{Name: "query", Permissions: Permissions{ScopeRead: nil, ScopeQuery: nil}}
+	tests := []struct {
+		name   string
+		plugin *Plugin
+		item   *BufferedItem
+		want   bool
+	}{
+		{name: "channel message", plugin: reader, item: &BufferedItem{Item: &BufferedItem_Message{Message: &ChannelMessage{Channel: "#test"}}}, want: true},
+		{name: "message to the bot without query", plugin: reader, item: &BufferedItem{Item: &BufferedItem_Message{Message: &ChannelMessage{Channel: "bot"}}}, want: false},
+		{name: "message to the bot with query", plugin: query, item: &BufferedItem{Item: &BufferedItem_Message{Message: &ChannelMessage{Channel: "bot"}}}, want: true},
+		{name: "private message without query", plugin: reader, item: &BufferedItem{Item: &BufferedItem_PrivateMessage{PrivateMessage: &PrivateMessage{}}}, want: false},
+	}
+	ps := &pluginServer{functions: &fakeIRCFunctions{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ps.bufferedAllowed(tt.plugin, tt.item); got != tt.want {
+				t.Errorf("bufferedAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"google.golang.org/grpc/codes"
+)
+
+// OverflowPolicy decides what happens when a plugin's stream falls so far behind that its buffer fills
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest drops the oldest buffered message to make room for the new one
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest drops the new message
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDisconnect closes the stream with RESOURCE_EXHAUSTED, the plugin can reconnect and resume
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// DefaultEventBuffer is how many messages each stream may have waiting by default
+const DefaultEventBuffer = 100
+
+// Valid returns true if the policy is a known policy
+func (p OverflowPolicy) Valid() bool {
+	switch p {
+	case OverflowDropOldest, OverflowDropNewest, OverflowDisconnect:
+		return true
+	}
+	return false
+}
+
+// eventBus fans messages from the server out to plugin streams.  Each stream has its own bounded buffer, so a plugin
+// that is slow to read can't hold up the IRC loop, and its overflow policy decides what happens when it fills.
+type eventBus struct {
+	mutex       sync.Mutex
+	functions   IRCFunctions
+	subscribers map[string]map[*busSubscriber[ircmsg.Message]]bool
+	callbacks   map[string]ircevent.CallbackID
+	size        int
+	policy      OverflowPolicy
+	dropped     map[string]uint64
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: map[string]map[*busSubscriber[ircmsg.Message]]bool{},
+		callbacks:   map[string]ircevent.CallbackID{},
+		size:        DefaultEventBuffer,
+		policy:      OverflowDropOldest,
+		dropped:     map[string]uint64{},
+	}
+}
+
+// busSubscriber buffers what is sent to a plugin's stream, for event streams the messages with the commands it
+// subscribed to that it accepts
+type busSubscriber[T any] struct {
+	mutex    sync.Mutex
+	owner    string
+	accept   func(T) bool
+	policy   OverflowPolicy
+	messages chan T
+	overflow chan struct{}
+	closed   bool
+}
+
+// newBusSubscriber returns a subscriber with the bus's buffer size and overflow policy, owned by the plugin in the
+// context, that isn't subscribed to any messages from the server
+func newBusSubscriber[T any](b *eventBus, ctx context.Context) *busSubscriber[T] {
+	owner := ""
+	if plugin, ok := PluginFromContext(ctx); ok {
+		owner = plugin.Name
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return &busSubscriber[T]{
+		owner:    owner,
+		policy:   b.policy,
+		messages: make(chan T, b.size),
+		overflow: make(chan struct{}),
+	}
+}
+
+// setLimits sets the size of the buffers and the overflow policy of streams opened from now on
+func (b *eventBus) setLimits(size int, policy OverflowPolicy) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if size < 1 {
+		size = DefaultEventBuffer
+	}
+	if !policy.Valid() {
+		policy = OverflowDropOldest
+	}
+	b.size = size
+	b.policy = policy
+}
+
+// start begins receiving messages from the server, streams can't subscribe until it has been called
+func (b *eventBus) start(functions IRCFunctions) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.functions = functions
+}
+
+// subscribe starts buffering the messages with the given commands that accept returns true for, a nil accept takes
+// every message.  The subscriber's owner is the plugin in the context.  The returned function must be called to
+// unsubscribe when the stream finishes.
+func (b *eventBus) subscribe(ctx context.Context, commands []string,
+	accept func(ircmsg.Message) bool) (*busSubscriber[ircmsg.Message], func()) {
+	subscriber := newBusSubscriber[ircmsg.Message](b, ctx)
+	subscriber.accept = accept
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var added []string
+	for _, command := range commands {
+		command = strings.ToUpper(command)
+		if b.subscribers[command] == nil {
+			b.subscribers[command] = map[*busSubscriber[ircmsg.Message]]bool{}
+			b.callbacks[command] = b.functions.AddCallback(command, func(message ircmsg.Message) {
+				b.publish(command, message)
+			})
+		}
+		if !b.subscribers[command][subscriber] {
+			b.subscribers[command][subscriber] = true
+			added = append(added, command)
+		}
+	}
+	return subscriber, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		for _, command := range added {
+			delete(b.subscribers[command], subscriber)
+			if len(b.subscribers[command]) == 0 {
+				b.functions.RemoveCallback(b.callbacks[command])
+				delete(b.subscribers, command)
+				delete(b.callbacks, command)
+			}
+		}
+	}
+}
+
+// publish passes a message to every subscriber to its command without waiting for them to read it
+func (b *eventBus) publish(command string, message ircmsg.Message) {
+	b.mutex.Lock()
+	subscribers := make([]*busSubscriber[ircmsg.Message], 0, len(b.subscribers[command]))
+	for subscriber := range b.subscribers[command] {
+		subscribers = append(subscribers, subscriber)
+	}
+	b.mutex.Unlock()
+	for _, subscriber := range subscribers {
+		if subscriber.accept != nil && !subscriber.accept(message) {
+			continue
+		}
+		deliver(b, subscriber, message)
+	}
+}
+
+// deliver buffers a message for the subscriber without waiting for it to be read, counting it against the
+// subscriber's owner if anything was dropped
+func deliver[T any](b *eventBus, subscriber *busSubscriber[T], message T) {
+	if subscriber.deliver(message) {
+		b.mutex.Lock()
+		b.dropped[subscriber.owner]++
+		b.mutex.Unlock()
+	}
+}
+
+// droppedEvents returns how many messages have been dropped because the plugin's streams fell behind
+func (b *eventBus) droppedEvents(owner string) uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.dropped[owner]
+}
+
+// deliver buffers a message, applying the overflow policy if the buffer is full.  It returns true if a message was
+// dropped.
+func (s *busSubscriber[T]) deliver(message T) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.messages <- message:
+		return false
+	default:
+	}
+	switch s.policy {
+	case OverflowDropNewest:
+	case OverflowDisconnect:
+		s.closed = true
+		close(s.overflow)
+	default:
+		// Only deliver sends to the channel, and it holds the mutex, so there is room once the oldest is removed
+		select {
+		case <-s.messages:
+		default:
+		}
+		s.messages <- message
+	}
+	return true
+}
+
+// overflowError is returned to plugins disconnected because their stream fell behind
+func overflowError() error {
+	return statusError(codes.ResourceExhausted, ReasonOverflow, "stream fell too far behind and was disconnected")
+}
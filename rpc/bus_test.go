@@ -0,0 +1,112 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/bot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_eventBus_overflow(t *testing.T) {
+	tests := []struct {
+		policy         OverflowPolicy
+		want           []string
+		wantDisconnect bool
+	}{
+		{policy: OverflowDropOldest, want: []string{"two", "three"}},
+		{policy: OverflowDropNewest, want: []string{"one", "two"}},
+		{policy: OverflowDisconnect, want: []string{"one", "two"}, wantDisconnect: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+			bus := newEventBus()
+			bus.setLimits(2, tt.policy)
+			bus.start(functions)
+			ctx := contextWithPlugin(context.Background(), &Plugin{Name: "plugin"})
+			subscriber, unsubscribe := bus.subscribe(ctx, []string{"privmsg"}, func(message ircmsg.Message) bool {
+				return message.Params[0] == "#test"
+			})
+			for _, text := range []string{"one", "two", "three"} {
+				functions.callbacks["PRIVMSG"](ircmsg.MakeMessage(nil, "nick!user@host", "PRIVMSG", "#test", text))
+				functions.callbacks["PRIVMSG"](ircmsg.MakeMessage(nil, "nick!user@host", "PRIVMSG", "#other", text))
+			}
+			var got []string
+			for len(subscriber.messages) > 0 {
+				got = append(got, (<-subscriber.messages).Params[1])
+			}
+			if len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] {
+				t.Errorf("subscriber received %v, want %v", got, tt.want)
+			}
+			select {
+			case <-subscriber.overflow:
+				if !tt.wantDisconnect {
+					t.Errorf("subscriber disconnected, want it to stay connected")
+				}
+			default:
+				if tt.wantDisconnect {
+					t.Errorf("subscriber still connected, want it disconnected")
+				}
+			}
+			if dropped := bus.droppedEvents("plugin"); dropped != 1 {
+				t.Errorf("droppedEvents() = %d, want 1", dropped)
+			}
+			unsubscribe()
+			if functions.removed != 1 {
+				t.Errorf("unsubscribe() removed %d callbacks, want 1", functions.removed)
+			}
+		})
+	}
+}
+
+type commandFunctions struct {
+	fakeIRCFunctions
+	handler chan func(bot.CommandInvocation)
+}
+
+func (f *commandFunctions) RegisterCommands(_ string, _ []bot.Command, handler func(bot.CommandInvocation)) (func(), error) {
+	f.handler <- handler
+	return func() {}, nil
+}
+
+type blockedCommandStream struct {
+	IRCPlugin_RegisterCommandsServer
+	ctx     context.Context
+	release chan struct{}
+}
+
+func (s *blockedCommandStream) Context() context.Context { return s.ctx }
+
+func (s *blockedCommandStream) Send(*CommandInvocation) error {
+	<-s.release
+	return nil
+}
+
+func Test_pluginServer_RegisterCommands_overflow(t *testing.T) {
+	functions := &commandFunctions{handler: make(chan func(bot.CommandInvocation), 1)}
+	bus := newEventBus()
+	bus.setLimits(1, OverflowDisconnect)
+	ps := &pluginServer{functions: functions, bus: bus}
+	stream := &blockedCommandStream{
+		ctx:     contextWithPlugin(context.Background(), &Plugin{Name: "plugin"}),
+		release: make(chan struct{}),
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- ps.RegisterCommands(&CommandRegistration{Commands: []*Command{{Name: "deploy"}}}, stream)
+	}()
+	handler := <-functions.handler
+	for range 3 {
+		handler(bot.CommandInvocation{Command: "deploy"})
+	}
+	close(stream.release)
+	if err := <-result; status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("RegisterCommands() error = %v, want ResourceExhausted", err)
+	}
+	if dropped := bus.droppedEvents("plugin"); dropped == 0 {
+		t.Errorf("droppedEvents() = %d, want the overflowing invocations counted", dropped)
+	}
+}
@@ -0,0 +1,40 @@
+package rpc
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_loadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "rpc.pem")
+	keyFile := filepath.Join(dir, "rpc.key")
+
+	generated, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("loadCertificate() error = %v", err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatalf("loadCertificate() did not save the key: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("loadCertificate() key mode = %v, want 0600", info.Mode().Perm())
+	}
+	loaded, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("loadCertificate() error = %v", err)
+	}
+	if !reflect.DeepEqual(generated.Certificate, loaded.Certificate) {
+		t.Errorf("loadCertificate() generated a new certificate rather than loading the saved one")
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCertificate(certFile, keyFile); err == nil {
+		t.Errorf("loadCertificate() replaced a certificate whose key is missing")
+	}
+}
@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (ps *pluginServer) GetChannelUsers(_ context.Context, channel *Channel) (*ChannelUserList, error) {
+	users, ok := ps.functions.ChannelUsers(channel.Name)
+	if !ok {
+		return nil, statusError(codes.NotFound, ReasonNotInChannel, "not in channel: "+channel.Name)
+	}
+	list := &ChannelUserList{
+		Channel: channel.Name,
+		Users:   make([]*ChannelUser, len(users)),
+	}
+	for index := range users {
+		list.Users[index] = &ChannelUser{
+			Nick:    users[index].Nick,
+			Source:  users[index].Source,
+			Account: users[index].Account,
+			Modes:   users[index].Modes,
+		}
+	}
+	return list, nil
+}
+
+func (ps *pluginServer) GetChannelTopic(_ context.Context, channel *Channel) (*ChannelTopic, error) {
+	topic, ok := ps.functions.ChannelTopic(channel.Name)
+	if !ok {
+		return nil, statusError(codes.NotFound, ReasonNotInChannel, "not in channel: "+channel.Name)
+	}
+	result := &ChannelTopic{
+		Channel: channel.Name,
+		Topic:   topic.Topic,
+		SetBy:   topic.SetBy,
+	}
+	if !topic.SetAt.IsZero() {
+		result.SetAt = timestamppb.New(topic.SetAt)
+	}
+	return result, nil
+}
+
+func (ps *pluginServer) GetChannelModes(_ context.Context, channel *Channel) (*ChannelModes, error) {
+	modes, ok := ps.functions.ChannelModes(channel.Name)
+	if !ok {
+		return nil, statusError(codes.NotFound, ReasonNotInChannel, "not in channel: "+channel.Name)
+	}
+	return &ChannelModes{
+		Channel: channel.Name,
+		Modes:   modes,
+	}, nil
+}
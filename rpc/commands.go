@@ -0,0 +1,56 @@
+package rpc
+
+import (
+	"github.com/greboid/irc-bot/v5/bot"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (ps *pluginServer) RegisterCommands(registration *CommandRegistration, stream IRCPlugin_RegisterCommandsServer) error {
+	owner := ""
+	if plugin, ok := PluginFromContext(stream.Context()); ok {
+		owner = plugin.Name
+	}
+	commands := make([]bot.Command, 0, len(registration.Commands))
+	for _, command := range registration.Commands {
+		commands = append(commands, bot.Command{
+			Name:        command.Name,
+			Aliases:     command.Aliases,
+			Usage:       command.Usage,
+			Description: command.Description,
+			Permission:  command.Permission,
+		})
+	}
+	subscriber := newBusSubscriber[*CommandInvocation](ps.bus, stream.Context())
+	unregister, err := ps.functions.RegisterCommands(owner, commands, func(invocation bot.CommandInvocation) {
+		deliver(ps.bus, subscriber, &CommandInvocation{
+			Command:     invocation.Command,
+			Arguments:   invocation.Arguments,
+			Message:     invocation.Message,
+			Channel:     invocation.Channel,
+			Nick:        invocation.Nick,
+			Source:      invocation.Source,
+			Tags:        invocation.Tags,
+			Account:     invocation.Account,
+			Permissions: invocation.Permissions,
+		})
+	})
+	if err != nil {
+		return status.Errorf(codes.AlreadyExists, "unable to register commands: %s", err.Error())
+	}
+	defer unregister()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-subscriber.overflow:
+			return overflowError()
+		case <-ps.stopping:
+			return nil
+		case invocation := <-subscriber.messages:
+			if err := stream.Send(invocation); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"github.com/greboid/irc-bot/v5/irc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newConnectionState(change irc.StateChange) *ConnectionState {
+	return &ConnectionState{
+		State:   string(change.State),
+		Server:  change.Server,
+		Reason:  change.Reason,
+		Attempt: int32(change.Attempt),
+		Time:    timestamppb.New(change.Time),
+	}
+}
+
+// GetConnectionState sends the current state of the connection to the server, then every change to it
+func (ps *pluginServer) GetConnectionState(_ *Empty, stream IRCPlugin_GetConnectionStateServer) error {
+	subscriber := newBusSubscriber[irc.StateChange](ps.bus, stream.Context())
+	remove := ps.functions.AddStateCallback(func(change irc.StateChange) {
+		deliver(ps.bus, subscriber, change)
+	})
+	defer remove()
+	if err := stream.Send(newConnectionState(ps.functions.ConnectionState())); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-subscriber.overflow:
+			return overflowError()
+		case <-ps.stopping:
+			return nil
+		case change := <-subscriber.messages:
+			if err := stream.Send(newConnectionState(change)); err != nil {
+				return err
+			}
+		}
+	}
+}
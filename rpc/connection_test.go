@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/greboid/irc-bot/v5/irc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type fakeStateFunctions struct {
+	IRCFunctions
+	mutex    sync.Mutex
+	callback func(irc.StateChange)
+}
+
+func (f *fakeStateFunctions) ConnectionState() irc.StateChange {
+	return irc.StateChange{State: irc.StateRegistered, Server: "irc.example.tld:6697"}
+}
+
+func (f *fakeStateFunctions) AddStateCallback(callback func(irc.StateChange)) func() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.callback = callback
+	return func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.callback = nil
+	}
+}
+
+func (f *fakeStateFunctions) change(change irc.StateChange) {
+	f.mutex.Lock()
+	callback := f.callback
+	f.mutex.Unlock()
+	callback(change)
+}
+
+type fakeStateStream struct {
+	IRCPlugin_GetConnectionStateServer
+	ctx  context.Context
+	sent chan *ConnectionState
+}
+
+func (s *fakeStateStream) Context() context.Context { return s.ctx }
+
+func (s *fakeStateStream) Send(state *ConnectionState) error {
+	s.sent <- state
+	return nil
+}
+
+func Test_pluginServer_GetConnectionState(t *testing.T) {
+	functions := &fakeStateFunctions{}
+	stopping := make(chan struct{})
+	ps := &pluginServer{functions: functions, bus: newEventBus(), stopping: stopping}
+	stream := &fakeStateStream{ctx: context.Background(), sent: make(chan *ConnectionState, 10)}
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.GetConnectionState(&Empty{}, stream)
+	}()
+	if state := <-stream.sent; state.State != "registered" || state.Server != "irc.example.tld:6697" {
+		t.Errorf("GetConnectionState() sent %v, want the current state first", state)
+	}
+	functions.change(irc.StateChange{
+		State:   irc.StateDisconnected,
+		Server:  "irc.example.tld:6697",
+		Reason:  "Closing link",
+		Attempt: 1,
+		Time:    time.Now(),
+	})
+	state := <-stream.sent
+	if state.State != "disconnected" || state.Reason != "Closing link" || state.Attempt != 1 {
+		t.Errorf("GetConnectionState() sent %v, want the disconnection", state)
+	}
+	close(stopping)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("GetConnectionState() error = %v, want nil when shutting down", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetConnectionState() didn't return when shutting down")
+	}
+	functions.mutex.Lock()
+	defer functions.mutex.Unlock()
+	if functions.callback != nil {
+		t.Error("GetConnectionState() didn't remove its callback")
+	}
+}
+
+func Test_pluginServer_GetConnectionState_Overflow(t *testing.T) {
+	functions := &fakeStateFunctions{}
+	ps := &pluginServer{functions: functions, bus: newEventBus(), stopping: make(chan struct{})}
+	ps.bus.setLimits(2, OverflowDisconnect)
+	// The stream can't send anything after the current state, so changes pile up
+	stream := &fakeStateStream{ctx: context.Background(), sent: make(chan *ConnectionState, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.GetConnectionState(&Empty{}, stream)
+	}()
+	for {
+		functions.mutex.Lock()
+		subscribed := functions.callback != nil
+		functions.mutex.Unlock()
+		if subscribed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for range 4 {
+		functions.change(irc.StateChange{State: irc.StateConnecting})
+	}
+	<-stream.sent
+	// Let the handler's sends go through now
+	go func() {
+		for range stream.sent {
+		}
+	}()
+	select {
+	case err := <-done:
+		if status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("GetConnectionState() error = %v, want ResourceExhausted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetConnectionState() didn't return when it fell behind")
+	}
+}
@@ -0,0 +1,94 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/greboid/irc-bot/v5/irc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// ErrorDomain is the domain of the ErrorInfo details attached to errors returned by the bot
+const ErrorDomain = "irc-bot"
+
+// Reasons given in the ErrorInfo details attached to errors returned by the bot
+const (
+	ReasonInvalidTarget = "INVALID_TARGET"
+	ReasonInvalidText   = "INVALID_TEXT"
+	ReasonQueueFull     = "QUEUE_FULL"
+	ReasonDisconnected  = "DISCONNECTED"
+	ReasonNotInChannel  = "NOT_IN_CHANNEL"
+	ReasonReloadFailed  = "RELOAD_FAILED"
+	ReasonRejected      = "REJECTED"
+	ReasonOverflow      = "STREAM_OVERFLOW"
+)
+
+// statusError returns a gRPC status error with ErrorInfo details giving the reason, followed by any other details
+func statusError(code codes.Code, reason string, message string, details ...protoadapt.MessageV1) error {
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: ErrorDomain}
+	return detailedError(code, message, append([]protoadapt.MessageV1{info}, details...)...)
+}
+
+// detailedError returns a gRPC status error with the given details, or without them if they can't be encoded
+func detailedError(code codes.Code, message string, details ...protoadapt.MessageV1) error {
+	result, err := status.New(code, message).WithDetails(details...)
+	if err != nil {
+		return status.Error(code, message)
+	}
+	return result.Err()
+}
+
+// fieldError returns an InvalidArgument status error, with BadRequest details naming the request field if known
+func fieldError(reason string, field string, err error) error {
+	if len(field) == 0 {
+		return statusError(codes.InvalidArgument, reason, err.Error())
+	}
+	return statusError(codes.InvalidArgument, reason, err.Error(), &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: err.Error()}},
+	})
+}
+
+// sendError converts an error from sending a line into a gRPC status, so plugins can tell invalid input, a full
+// queue, a lost connection or the server refusing a message apart from other failures.  Invalid targets and text
+// are reported against the named request fields.
+func sendError(err error, targetField string, textField string) error {
+	var rejected *irc.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		return detailedError(codes.FailedPrecondition, err.Error(), &errdetails.ErrorInfo{
+			Reason:   ReasonRejected,
+			Domain:   ErrorDomain,
+			Metadata: map[string]string{"reply": rejected.Reply},
+		})
+	case errors.Is(err, irc.ErrInvalidTarget):
+		return fieldError(ReasonInvalidTarget, targetField, err)
+	case errors.Is(err, irc.ErrInvalidText):
+		return fieldError(ReasonInvalidText, textField, err)
+	case errors.Is(err, irc.ErrQueueFull):
+		return statusError(codes.ResourceExhausted, ReasonQueueFull, err.Error(), &errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{Subject: "queue-limit", Description: err.Error()}},
+		})
+	case errors.Is(err, irc.ErrDisconnected):
+		return statusError(codes.Unavailable, ReasonDisconnected, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return err
+}
+
+// legacyError wraps the result of an RPC in the Error message returned by the original IRCPlugin service
+func legacyError(err error) (*Error, error) {
+	if err != nil {
+		return &Error{
+			Message: status.Convert(err).Message(),
+		}, err
+	}
+	return &Error{
+		Message: "",
+	}, nil
+}
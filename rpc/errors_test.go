@@ -0,0 +1,72 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/greboid/irc-bot/v5/irc"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_sendError(t *testing.T) {
+	disconnected := irc.NewIRC("localhost:6667", "", "bot", "bot", false, false, "", "", zap.NewNop().Sugar(),
+		irc.FloodProfile{})
+	tests := []struct {
+		name       string
+		err        error
+		want       codes.Code
+		wantReason string
+		wantField  string
+	}{
+		{name: "invalid target", err: fmt.Errorf("%w: #a b", irc.ErrInvalidTarget), want: codes.InvalidArgument, wantReason: ReasonInvalidTarget, wantField: "channel"},
+		{name: "invalid text", err: irc.ErrInvalidText, want: codes.InvalidArgument, wantReason: ReasonInvalidText, wantField: "message"},
+		{name: "rejected", err: &irc.RejectedError{Reply: "404", Message: "Cannot send to channel"}, want: codes.FailedPrecondition, wantReason: ReasonRejected},
+		{name: "queue full", err: irc.ErrQueueFull, want: codes.ResourceExhausted, wantReason: ReasonQueueFull},
+		{name: "disconnected", err: fmt.Errorf("sending: %w", irc.ErrDisconnected), want: codes.Unavailable, wantReason: ReasonDisconnected},
+		{name: "send while disconnected", err: disconnected.SendMessageContext(context.Background(), "PRIVMSG", "#test", "hello"), want: codes.Unavailable, wantReason: ReasonDisconnected},
+		{name: "deadline", err: context.DeadlineExceeded, want: codes.DeadlineExceeded},
+		{name: "cancelled", err: context.Canceled, want: codes.Canceled},
+		{name: "other", err: errors.New("broken pipe"), want: codes.Unknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := status.Convert(sendError(tt.err, "channel", "message"))
+			if result.Code() != tt.want {
+				t.Errorf("sendError() code = %v, want %v", result.Code(), tt.want)
+			}
+			var reason, field string
+			for _, detail := range result.Details() {
+				switch detail := detail.(type) {
+				case *errdetails.ErrorInfo:
+					reason = detail.Reason
+				case *errdetails.BadRequest:
+					field = detail.FieldViolations[0].Field
+				}
+			}
+			if reason != tt.wantReason {
+				t.Errorf("sendError() reason = %v, want %v", reason, tt.wantReason)
+			}
+			if field != tt.wantField {
+				t.Errorf("sendError() field = %v, want %v", field, tt.wantField)
+			}
+		})
+	}
+}
+
+func Test_legacyError(t *testing.T) {
+	result, err := legacyError(statusError(codes.NotFound, ReasonNotInChannel, "not in channel: #test"))
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("legacyError() code = %v, want %v", status.Code(err), codes.NotFound)
+	}
+	if result.Message != "not in channel: #test" {
+		t.Errorf("legacyError() message = %q, want the status message", result.Message)
+	}
+	if result, err := legacyError(nil); err != nil || result.Message != "" {
+		t.Errorf("legacyError(nil) = %v, %v, want an empty message", result, err)
+	}
+}
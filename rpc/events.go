@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"strings"
+
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/bot"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ShutdownEvent is the type of the event sent to every events stream when the bot is shutting down, the stream closes
+// after it
+const ShutdownEvent = "SHUTDOWN"
+
+// defaultEventTypes are the events sent to plugins that don't ask for specific types
+var defaultEventTypes = []string{
+	"JOIN", "PART", "QUIT", "KICK", "NICK", "TOPIC", "MODE", "PRIVMSG", "NOTICE", "TAGMSG", "INVITE",
+}
+
+// eventChannel returns the channel an event relates to, or an empty string if it is not channel specific
+func eventChannel(message ircmsg.Message, currentNick string) string {
+	switch message.Command {
+	case "JOIN", "PART", "KICK", "TOPIC", "PRIVMSG", "NOTICE", "TAGMSG":
+		if len(message.Params) > 0 && !strings.EqualFold(message.Params[0], currentNick) {
+			return message.Params[0]
+		}
+	case "MODE":
+		if len(message.Params) > 0 && !strings.EqualFold(message.Params[0], currentNick) {
+			return message.Params[0]
+		}
+	case "INVITE":
+		if len(message.Params) > 1 {
+			return message.Params[1]
+		}
+	}
+	return ""
+}
+
+// matchesFilter checks if an event should be sent to a plugin, events that are not channel specific are not subject
+// to the channel filter.  Channels are compared after folding them with fold.
+func matchesFilter(filter *EventFilter, message ircmsg.Message, channel string, fold func(string) string) bool {
+	if len(filter.Channels) > 0 && len(channel) > 0 {
+		found := false
+		for _, filterChannel := range filter.Channels {
+			if filterChannel == "*" || fold(filterChannel) == fold(channel) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.Sources) > 0 {
+		for _, mask := range filter.Sources {
+			if bot.MatchMask(mask, message.Source) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// newEvent converts a message from the server into an event to send to plugins
+func newEvent(message ircmsg.Message, channel string) *Event {
+	event := &Event{
+		Type:    message.Command,
+		Params:  message.Params,
+		Tags:    message.AllTags(),
+		Time:    timestamppb.New(bot.MessageTime(message)),
+		Channel: channel,
+	}
+	if nuh, err := message.NUH(); err == nil {
+		event.Source = &Source{
+			Nick: nuh.Name,
+			User: nuh.User,
+			Host: nuh.Host,
+		}
+	}
+	return event
+}
+
+// eventTypes returns the types of events a filter asks for
+func eventTypes(filter *EventFilter) []string {
+	if len(filter.Types) == 0 {
+		return defaultEventTypes
+	}
+	return filter.Types
+}
+
+// matchesEvent returns true if the message matches the filter
+func (ps *pluginServer) matchesEvent(filter *EventFilter, message ircmsg.Message) bool {
+	return matchesFilter(filter, message, eventChannel(message, ps.functions.CurrentNick()), ps.functions.Fold)
+}
+
+// eventAllowed returns true if the plugin may see the event.  Channel events need the read scope for the channel,
+// private messages to the bot need the query scope, and events that aren't channel specific (eg QUIT and NICK) need
+// read access to every channel.
+func (ps *pluginServer) eventAllowed(plugin *Plugin, message ircmsg.Message, channel string) bool {
+	if plugin == nil {
+		return true
+	}
+	if len(channel) > 0 {
+		return plugin.Allowed(ScopeRead, channel)
+	}
+	switch message.Command {
+	case "PRIVMSG", "NOTICE", "TAGMSG":
+		if len(message.Params) > 0 && ps.isMe(message.Params[0]) {
+			return plugin.HasScope(ScopeQuery)
+		}
+	}
+	return plugin.Allowed(ScopeRead, "*")
+}
+
+// event converts a message into an event if it matches the filter, returning nil if it doesn't
+func (ps *pluginServer) event(filter *EventFilter, message ircmsg.Message) *Event {
+	if !ps.matchesEvent(filter, message) {
+		return nil
+	}
+	return newEvent(message, eventChannel(message, ps.functions.CurrentNick()))
+}
+
+func (ps *pluginServer) GetEvents(filter *EventFilter, stream IRCPlugin_GetEventsServer) error {
+	plugin, _ := PluginFromContext(stream.Context())
+	subscriber, unsubscribe := ps.bus.subscribe(stream.Context(), eventTypes(filter), func(message ircmsg.Message) bool {
+		return ps.matchesEvent(filter, message) &&
+			ps.eventAllowed(plugin, message, eventChannel(message, ps.functions.CurrentNick()))
+	})
+	defer unsubscribe()
+	defer ps.buffers.subscribe(stream.Context(), &bufferSubscription{
+		Kind:     subscriptionEvents,
+		Types:    filter.Types,
+		Channels: filter.Channels,
+		Sources:  filter.Sources,
+	})()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-subscriber.overflow:
+			return overflowError()
+		case <-ps.stopping:
+			return stream.Send(&Event{Type: ShutdownEvent, Time: timestamppb.Now()})
+		case message := <-subscriber.messages:
+			if err := stream.Send(newEvent(message, eventChannel(message, ps.functions.CurrentNick()))); err != nil {
+				return err
+			}
+		}
+	}
+}
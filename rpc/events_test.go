@@ -0,0 +1,226 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircmsg"
+)
+
+type fakeEventStream struct {
+	IRCPlugin_GetEventsServer
+	ctx  context.Context
+	sent []*Event
+}
+
+func (s *fakeEventStream) Context() context.Context { return s.ctx }
+
+func (s *fakeEventStream) Send(event *Event) error {
+	s.sent = append(s.sent, event)
+	return nil
+}
+
+func Test_matchesFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *EventFilter
+		line   string
+		want   bool
+	}{
+		{
+			name:   "empty filter",
+			filter: &EventFilter{},
+			line:   ":nick!user@host JOIN #test",
+			want:   true,
+		},
+		{
+			name:   "matching channel",
+			filter: &EventFilter{Channels: []string{"#Test"}},
+			line:   ":nick!user@host JOIN #test",
+			want:   true,
+		},
+		{
+			name:   "other channel",
+			filter: &EventFilter{Channels: []string{"#other"}},
+			line:   ":nick!user@host PART #test",
+			want:   false,
+		},
+		{
+			name:   "invite channel",
+			filter: &EventFilter{Channels: []string{"#other"}},
+			line:   ":nick!user@host INVITE bot #other",
+			want:   true,
+		},
+		{
+			name:   "non channel event",
+			filter: &EventFilter{Channels: []string{"#other"}},
+			line:   ":nick!user@host QUIT :bye",
+			want:   true,
+		},
+		{
+			name:   "non matching source",
+			filter: &EventFilter{Sources: []string{"admin!*@*"}},
+			line:   ":nick!user@host QUIT :bye",
+			want:   false,
+		},
+		{
+			name:   "rfc1459 casemapping",
+			filter: &EventFilter{Channels: []string{"#ops[1]"}},
+			line:   ":nick!user@host JOIN #OPS{1}",
+			want:   true,
+		},
+		{
+			name:   "user mode",
+			filter: &EventFilter{Channels: []string{"#other"}},
+			line:   ":bot MODE bot +B",
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := ircmsg.ParseLine(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchesFilter(tt.filter, message, eventChannel(message, "bot"), rfc1459Fold); got != tt.want {
+				t.Errorf("matchesFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pluginServer_eventAllowed(t *testing.T) {
+	restricted := &Plugin{Name: "ops", Permissions: Permissions{ScopeRead: {"#ops"}}}
+	query := &Plugin{Name: "ops", Permissions: Permissions{ScopeRead: {"#ops"}, ScopeQuery: nil}}
+	unrestricted := &Plugin{Name: "all", Permissions: Permissions{ScopeRead: nil}}
+	tests := []struct {
+		name   string
+		plugin *Plugin
+		line   string
+		want   bool
+	}{
+		{name: "allowed channel", plugin: restricted, line: ":nick!user@host JOIN #ops", want: true},
+		{name: "other channel", plugin: restricted, line: ":nick!user@host PRIVMSG #dev :hi", want: false},
+		{name: "quit when restricted", plugin: restricted, line: ":nick!user@host QUIT :bye", want: false},
+		{name: "nick when restricted", plugin: restricted, line: ":nick!user@host NICK other", want: false},
+		{name: "private message without query", plugin: restricted, line: ":nick!user@host PRIVMSG bot :hi", want: false},
+		{name: "private message to differently cased nick", plugin: restricted, line: ":nick!user@host NOTICE Bot :hi", want: false},
+		{name: "private message with query", plugin: query, line: ":nick!user@host PRIVMSG bot :hi", want: true},
+		{name: "quit when unrestricted", plugin: unrestricted, line: ":nick!user@host QUIT :bye", want: true},
+		{name: "private message when unrestricted", plugin: unrestricted, line: ":nick!user@host PRIVMSG bot :hi", want: false},
+		{name: "no permissions", plugin: &Plugin{Name: "legacy"}, line: ":nick!user@host PRIVMSG bot :hi", want: true},
+	}
+	ps := &pluginServer{functions: &fakeIRCFunctions{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := ircmsg.ParseLine(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ps.eventAllowed(tt.plugin, message, eventChannel(message, "bot")); got != tt.want {
+				t.Errorf("eventAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type channelEventStream struct {
+	IRCPlugin_GetEventsServer
+	ctx  context.Context
+	sent chan *Event
+}
+
+func (s *channelEventStream) Context() context.Context { return s.ctx }
+
+func (s *channelEventStream) Send(event *Event) error {
+	s.sent <- event
+	return nil
+}
+
+func Test_pluginServer_GetEvents_Restricted(t *testing.T) {
+	functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+	ps := &pluginServer{functions: functions, bus: newEventBus(), stopping: make(chan struct{})}
+	ps.bus.start(functions)
+	plugin := &Plugin{Name: "ops", Permissions: Permissions{ScopeRead: {"#ops"}}}
+	ctx, cancel := context.WithCancel(contextWithPlugin(context.Background(), plugin))
+	defer cancel()
+	stream := &channelEventStream{ctx: ctx, sent: make(chan *Event, 10)}
+	go func() {
+		_ = ps.GetEvents(&EventFilter{}, stream)
+	}()
+	for {
+		if _, ok := functions.subscribed("JOIN"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, line := range []string{
+		":nick!user@host PRIVMSG bot :secret",
+		":nick!user@host QUIT :bye",
+		":nick!user@host JOIN #dev",
+		":nick!user@host JOIN #ops",
+	} {
+		message, _ := ircmsg.ParseLine(line)
+		callback, _ := functions.subscribed(message.Command)
+		callback(message)
+	}
+	select {
+	case event := <-stream.sent:
+		if event.Type != "JOIN" || event.Channel != "#ops" {
+			t.Errorf("GetEvents() sent %v, want only the join to #ops", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetEvents() didn't send the join to #ops")
+	}
+}
+
+func Test_newEvent(t *testing.T) {
+	message, err := ircmsg.ParseLine("@time=2021-01-02T03:04:05.678Z;account=greboid :nick!user@host TOPIC #test :New topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := newEvent(message, "#test")
+	if event.Type != "TOPIC" || event.Channel != "#test" || len(event.Params) != 2 {
+		t.Errorf("newEvent() = %#+v", event)
+	}
+	if event.Source.Nick != "nick" || event.Source.User != "user" || event.Source.Host != "host" {
+		t.Errorf("newEvent() source = %#+v", event.Source)
+	}
+	if event.Tags["account"] != "greboid" {
+		t.Errorf("newEvent() tags = %#+v", event.Tags)
+	}
+	if got := event.Time.AsTime().UnixMilli(); got != 1609556645678 {
+		t.Errorf("newEvent() time = %d, want 1609556645678", got)
+	}
+}
+
+func Test_pluginServer_GetEvents_Shutdown(t *testing.T) {
+	functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+	stopping := make(chan struct{})
+	ps := &pluginServer{functions: functions, bus: newEventBus(), stopping: stopping}
+	ps.bus.start(functions)
+	stream := &fakeEventStream{ctx: context.Background()}
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.GetEvents(&EventFilter{Types: []string{"JOIN"}}, stream)
+	}()
+	for {
+		if _, ok := functions.subscribed("JOIN"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stopping)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("GetEvents() error = %v, want nil when shutting down", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetEvents() didn't return when shutting down")
+	}
+	if len(stream.sent) != 1 || stream.sent[0].Type != ShutdownEvent {
+		t.Errorf("GetEvents() sent %v, want a single %s event", stream.sent, ShutdownEvent)
+	}
+}
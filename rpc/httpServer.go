@@ -8,23 +8,23 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"os"
-	"os/signal"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/greboid/irc-bot/v5/irc"
-	grpcauth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 type httpServer struct {
-	WebPort int
-	plugins []Plugin
-	pathMap map[string]*descriptor
-	logger  irc.Logger
+	WebPort  int
+	plugins  *pluginList
+	mutex    sync.Mutex
+	pathMap  map[string]*descriptor
+	buffers  *bufferRegistry
+	logger   irc.Logger
+	server   *http.Server
+	stopping <-chan struct{}
 }
 
 func (h *httpServer) mustEmbedUnimplementedHTTPPluginServer() {
@@ -36,7 +36,7 @@ type descriptor struct {
 	receive chan *HttpResponse
 }
 
-func NewHttpServer(port int, plugin []Plugin, logger irc.Logger) *httpServer {
+func NewHttpServer(port int, plugin *pluginList, logger irc.Logger) *httpServer {
 	return &httpServer{
 		WebPort: port,
 		plugins: plugin,
@@ -47,113 +47,135 @@ func NewHttpServer(port int, plugin []Plugin, logger irc.Logger) *httpServer {
 }
 
 func (h *httpServer) Start() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.handleRequest)
+	h.server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", h.WebPort),
+		Handler: mux,
+	}
 	go func() {
-		mux := http.NewServeMux()
-		mux.HandleFunc("/", h.handleRequest)
-		server := http.Server{
-			Addr:    fmt.Sprintf(":%d", h.WebPort),
-			Handler: mux,
-		}
-		go func() {
-			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				h.logger.Errorf("Error starting HTTP: %s", err.Error())
-			}
-		}()
-		stop := make(chan os.Signal, 1)
-		signal.Notify(stop, os.Interrupt, os.Kill)
-		<-stop
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := server.Shutdown(ctx); err != nil {
-			h.logger.Errorf("Unable to shutdown: %s", err.Error())
+		if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			h.logger.Errorf("Error starting HTTP: %s", err.Error())
 		}
 	}()
 }
 
-func (h *httpServer) authPlugin(ctx context.Context) (context.Context, error) {
-	token, err := grpcauth.AuthFromMD(ctx, "bearer")
-	if err != nil {
-		return nil, status.Errorf(codes.Unauthenticated, "invalid auth token: %s", err.Error())
+// Shutdown stops accepting webhooks and waits for those in flight to be answered, or the context to be done
+func (h *httpServer) Shutdown(ctx context.Context) error {
+	if h.server == nil {
+		return nil
 	}
-	if !h.checkPlugin(token) {
-		return nil, status.Errorf(codes.Unauthenticated, "access denied")
-	}
-	return ctx, nil
+	return h.server.Shutdown(ctx)
 }
 
-func (h *httpServer) checkPlugin(token string) bool {
-	for _, plugin := range h.plugins {
-		if plugin.Token == token {
-			return true
+// route returns the plugin listening for the longest prefix matching whole segments of the path, or nil if there isn't
+// one
+func (h *httpServer) route(path string) *descriptor {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	var route *descriptor
+	for _, desc := range h.pathMap {
+		if matchesPath(desc.prefix, strings.TrimPrefix(path, "/")) &&
+			(route == nil || len(desc.prefix) > len(route.prefix)) {
+			route = desc
 		}
 	}
-	return false
+	return route
 }
 
 func (h *httpServer) handleRequest(writer http.ResponseWriter, request *http.Request) {
-	for k := range h.pathMap {
-		if strings.HasPrefix(request.URL.Path, fmt.Sprintf("/%s", h.pathMap[k].prefix)) {
-			stream := *h.pathMap[k].stream
-			if stream != nil {
-				rpcHttpc, err := ConvertHTTPToRPC(request)
-				if err != nil {
-					h.logger.Errorf("Unable to read input")
-					writer.WriteHeader(http.StatusInternalServerError)
-					_, _ = writer.Write([]byte("Unable to read input"))
-					return
-				}
-				err = stream.Send(rpcHttpc)
-				if err != nil {
-					h.logger.Errorf("Unable to send to plugin")
-					writer.WriteHeader(http.StatusInternalServerError)
-					_, _ = writer.Write([]byte("Unable to send to handler"))
-					return
-				}
-				select {
-				case response := <-h.pathMap[k].receive:
-					for index := range response.Header {
-						writer.Header().Add(response.Header[index].Key, response.Header[index].Value)
-					}
-					writer.WriteHeader(int(response.Status))
-					_, _ = writer.Write(response.Body)
-					return
-				case <-time.After(5 * time.Second):
-					h.logger.Errorf("Timeout waiting for plugin: %s", request.URL.Path)
-					writer.WriteHeader(http.StatusGatewayTimeout)
-					_, _ = writer.Write([]byte("Timeout waiting for handler"))
-					return
-				}
+	if desc := h.route(request.URL.Path); desc != nil && *desc.stream != nil {
+		stream := *desc.stream
+		rpcHttpc, err := ConvertHTTPToRPC(request)
+		if err != nil {
+			h.logger.Errorf("Unable to read input")
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte("Unable to read input"))
+			return
+		}
+		err = stream.Send(rpcHttpc)
+		if err != nil {
+			h.logger.Errorf("Unable to send to plugin")
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte("Unable to send to handler"))
+			return
+		}
+		select {
+		case response := <-desc.receive:
+			for index := range response.Header {
+				writer.Header().Add(response.Header[index].Key, response.Header[index].Value)
 			}
+			writer.WriteHeader(int(response.Status))
+			_, _ = writer.Write(response.Body)
+			return
+		case <-time.After(5 * time.Second):
+			h.logger.Errorf("Timeout waiting for plugin: %s", request.URL.Path)
+			writer.WriteHeader(http.StatusGatewayTimeout)
+			_, _ = writer.Write([]byte("Timeout waiting for handler"))
+			return
 		}
 	}
+	if buffer := h.buffers.webhookBuffer(request.URL.Path); buffer != nil {
+		rpcRequest, err := ConvertHTTPToRPC(request)
+		if err != nil {
+			h.logger.Errorf("Unable to read input")
+			writer.WriteHeader(http.StatusInternalServerError)
+			_, _ = writer.Write([]byte("Unable to read input"))
+			return
+		}
+		buffer.add(&BufferedItem{Item: &BufferedItem_Request{Request: rpcRequest}})
+		writer.WriteHeader(http.StatusAccepted)
+		_, _ = writer.Write([]byte("Buffered for handler"))
+		return
+	}
 	writer.WriteHeader(http.StatusNotFound)
 	_, _ = writer.Write([]byte("Handler not found"))
 }
 
 func (h *httpServer) GetRequest(stream HTTPPlugin_GetRequestServer) error {
 	path := metautils.ExtractIncoming(stream.Context()).Get("path")
-	if _, ok := h.pathMap[path]; ok {
-		return errors.New("prefix already registered")
-	}
-	h.logger.Debugf("Plugin listening for /%s/*", path)
-	h.pathMap[path] = &descriptor{
+	desc := &descriptor{
 		prefix:  path,
 		receive: make(chan *HttpResponse, 1),
 		stream:  &stream,
 	}
-	for {
-		in, err := stream.Recv()
+	h.mutex.Lock()
+	if _, ok := h.pathMap[path]; ok {
+		h.mutex.Unlock()
+		return errors.New("prefix already registered")
+	}
+	h.pathMap[path] = desc
+	h.mutex.Unlock()
+	h.logger.Debugf("Plugin listening for /%s/*", path)
+	defer func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		delete(h.pathMap, path)
+	}()
+	defer h.buffers.subscribe(stream.Context(), &bufferSubscription{Kind: subscriptionWebhook, Path: path})()
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			desc.receive <- in
+		}
+	}()
+	select {
+	case <-stream.Context().Done():
+		h.logger.Debugf("Plugin stopped listening for /%s/*", path)
+		return stream.Context().Err()
+	case <-h.stopping:
+		return nil
+	case err := <-errs:
+		h.logger.Debugf("Plugin stopped listening for /%s/*", path)
 		if err == io.EOF {
-			h.logger.Debugf("Plugin stopped listening for /%s/*", path)
-			delete(h.pathMap, path)
 			return nil
 		}
-		if err != nil {
-			h.logger.Debugf("Plugin stopped listening for /%s/*", path)
-			delete(h.pathMap, path)
-			return err
-		}
-		h.pathMap[path].receive <- in
+		return err
 	}
 }
 
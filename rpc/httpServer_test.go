@@ -0,0 +1,52 @@
+package rpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+type fakeRequestStream struct {
+	HTTPPlugin_GetRequestServer
+	name    string
+	receive chan *HttpResponse
+}
+
+func (s *fakeRequestStream) Send(*HttpRequest) error {
+	s.receive <- &HttpResponse{Status: http.StatusOK, Body: []byte(s.name)}
+	return nil
+}
+
+func Test_httpServer_handleRequest(t *testing.T) {
+	server := NewHttpServer(0, nil, zap.NewNop().Sugar())
+	for _, prefix := range []string{"hooks", "hooks/github"} {
+		receive := make(chan *HttpResponse, 1)
+		var stream HTTPPlugin_GetRequestServer = &fakeRequestStream{name: prefix, receive: receive}
+		server.pathMap[prefix] = &descriptor{prefix: prefix, stream: &stream, receive: receive}
+	}
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantPlugin string
+	}{
+		{path: "/hooks", wantStatus: http.StatusOK, wantPlugin: "hooks"},
+		{path: "/hooks/gitlab", wantStatus: http.StatusOK, wantPlugin: "hooks"},
+		{path: "/hooks/github/push", wantStatus: http.StatusOK, wantPlugin: "hooks/github"},
+		{path: "/hooksecret/push", wantStatus: http.StatusNotFound},
+		{path: "/hooks2", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			server.handleRequest(recorder, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("handleRequest() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if len(tt.wantPlugin) > 0 && recorder.Body.String() != tt.wantPlugin {
+				t.Errorf("handleRequest() routed to %s, want %s", recorder.Body.String(), tt.wantPlugin)
+			}
+		})
+	}
+}
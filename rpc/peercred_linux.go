@@ -0,0 +1,29 @@
+//go:build linux
+
+package rpc
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerUID returns the user of the process at the other end of a Unix socket, using SO_PEERCRED
+func peerUID(conn net.Conn) (int, bool) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var credentials *syscall.Ucred
+	var credentialsErr error
+	err = raw.Control(func(fd uintptr) {
+		credentials, credentialsErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || credentialsErr != nil {
+		return 0, false
+	}
+	return int(credentials.Uid), true
+}
@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func Test_GrpcServer_socketPeerCredentials(t *testing.T) {
+	uid := os.Getuid()
+	other := uid + 1
+	tests := []struct {
+		name     string
+		uid      int
+		token    string
+		wantCode codes.Code
+	}{
+		{name: "matching uid", uid: uid, wantCode: codes.OK},
+		{name: "other uid", uid: other, wantCode: codes.Unauthenticated},
+		{name: "other uid with token", uid: other, token: "abc", wantCode: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginUID := tt.uid
+			server := &GrpcServer{plugins: newPluginList([]Plugin{{Name: "webhook", Token: "abc", UID: &pluginUID}})}
+			path := filepath.Join(t.TempDir(), "rpc.sock")
+			listener, err := listenSocket(path, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			grpcServer := server.newServer(unixCredentials{})
+			RegisterIRCPluginServer(grpcServer, &pluginServer{})
+			go func() {
+				_ = grpcServer.Serve(listener)
+			}()
+			defer grpcServer.Stop()
+
+			conn, err := grpc.NewClient("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if len(tt.token) > 0 {
+				ctx = CtxWithToken(ctx, "bearer", tt.token)
+			}
+			_, err = NewIRCPluginClient(conn).Ping(ctx, &Empty{})
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("Ping() code = %v, want %v (%v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package rpc
+
+import (
+	"net"
+)
+
+// peerUID isn't supported on this platform, plugins connecting over the Unix socket must use a token
+func peerUID(net.Conn) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,281 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/greboid/irc-bot/v5/irc"
+	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Scope is a named capability a plugin may be granted
+type Scope string
+
+const (
+	// ScopeSend allows sending messages to the listed channels
+	ScopeSend Scope = "send"
+	// ScopeRelay allows relaying messages to the listed channels
+	ScopeRelay Scope = "relay"
+	// ScopeRaw allows sending arbitrary raw lines to the server
+	ScopeRaw Scope = "raw"
+	// ScopeJoin allows joining and leaving the listed channels
+	ScopeJoin Scope = "join"
+	// ScopeRead allows listing channels, and reading the messages, users, topics and modes of the listed channels
+	ScopeRead Scope = "read"
+	// ScopeHTTP allows registering webhooks under the listed path prefixes
+	ScopeHTTP Scope = "http"
+	// ScopeQuery allows receiving private messages and sending private messages to the listed nicks
+	ScopeQuery Scope = "query"
+	// ScopeCommands allows registering the listed chat commands
+	ScopeCommands Scope = "commands"
+	// ScopeAdmin allows reloading the configuration
+	ScopeAdmin Scope = "admin"
+)
+
+// Permissions maps a scope to the targets it applies to, an empty target list allows any target
+type Permissions map[Scope][]string
+
+// methodScopes lists the scope required by each RPC method, an empty scope only requires a valid token
+var methodScopes = map[string]Scope{
+	"/rpc.IRCPlugin/ping":               "",
+	"/rpc.IRCPlugin/sendChannelMessage": ScopeSend,
+	"/rpc.IRCPlugin/sendRelayMessage":   ScopeRelay,
+	"/rpc.IRCPlugin/sendRawMessage":     ScopeRaw,
+	"/rpc.IRCPlugin/joinChannel":        ScopeJoin,
+	"/rpc.IRCPlugin/leaveChannel":       ScopeJoin,
+	"/rpc.IRCPlugin/listChannel":        ScopeRead,
+	"/rpc.IRCPlugin/getMessages":        ScopeRead,
+	"/rpc.IRCPlugin/reload":             ScopeAdmin,
+	"/rpc.IRCPlugin/getEvents":          ScopeRead,
+	"/rpc.IRCPlugin/sendPrivateMessage": ScopeQuery,
+	"/rpc.IRCPlugin/getPrivateMessages": ScopeQuery,
+	"/rpc.IRCPlugin/registerCommands":   ScopeCommands,
+	"/rpc.IRCPlugin/checkPermission":    "",
+	"/rpc.IRCPlugin/getChannelUsers":    ScopeRead,
+	"/rpc.IRCPlugin/getChannelTopic":    ScopeRead,
+	"/rpc.IRCPlugin/getChannelModes":    ScopeRead,
+	"/rpc.IRCPlugin/getQueueDepth":      "",
+	"/rpc.IRCPlugin/getBuffered":        "",
+	"/rpc.IRCPlugin/acknowledge":        "",
+	"/rpc.IRCPlugin/getConnectionState": "",
+	"/rpc.HTTPPlugin/getRequest":        ScopeHTTP,
+}
+
+func init() {
+	// IRCPluginV2 has the same methods as IRCPlugin, so requires the same scopes
+	for method, scope := range methodScopes {
+		if name, ok := strings.CutPrefix(method, "/"+IRCPlugin_ServiceDesc.ServiceName+"/"); ok {
+			methodScopes["/"+IRCPluginV2_ServiceDesc.ServiceName+"/"+name] = scope
+		}
+	}
+}
+
+type pluginContextKey struct{}
+
+// PluginFromContext returns the authenticated plugin stored in the context by the auth interceptors
+func PluginFromContext(ctx context.Context) (*Plugin, bool) {
+	plugin, ok := ctx.Value(pluginContextKey{}).(*Plugin)
+	return plugin, ok
+}
+
+// contextWithPlugin stores the plugin in the context, and attributes any lines sent with the context to it
+func contextWithPlugin(ctx context.Context, plugin *Plugin) context.Context {
+	return irc.WithQueueOwner(context.WithValue(ctx, pluginContextKey{}, plugin), plugin.Name)
+}
+
+// HasScope returns true if the plugin has been granted the scope for any target.  Plugins without any configured
+// permissions are granted every scope.
+func (p *Plugin) HasScope(scope Scope) bool {
+	if p.Permissions == nil {
+		return true
+	}
+	_, ok := p.Permissions[scope]
+	return ok
+}
+
+// Allowed returns true if the plugin has been granted the scope for the given target
+func (p *Plugin) Allowed(scope Scope, target string) bool {
+	if p.Permissions == nil {
+		return true
+	}
+	targets, ok := p.Permissions[scope]
+	if !ok {
+		return false
+	}
+	if len(targets) == 0 {
+		return true
+	}
+	for _, allowed := range targets {
+		if allowed == "*" || matchesTarget(scope, allowed, target, p.fold) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTarget returns true if the target is the allowed one, channels and nicks are compared with fold or case
+// insensitively if it is nil
+func matchesTarget(scope Scope, allowed string, target string, fold func(string) string) bool {
+	if scope == ScopeHTTP {
+		return matchesPath(strings.TrimPrefix(allowed, "/"), strings.TrimPrefix(target, "/"))
+	}
+	if fold == nil {
+		return strings.EqualFold(allowed, target)
+	}
+	return fold(allowed) == fold(target)
+}
+
+// matchesPath returns true if the path is the prefix or below it, prefixes only match whole path segments
+func matchesPath(prefix string, path string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// ParsePermissions parses a semicolon separated list of scopes, each with an optional colon separated list of pipe
+// separated targets, eg send:#ops|#deploys;relay;read:#ops
+func ParsePermissions(permissionString string) (Permissions, error) {
+	permissions := Permissions{}
+	for _, value := range strings.Split(permissionString, ";") {
+		if len(value) == 0 {
+			continue
+		}
+		parts := strings.SplitN(value, ":", 2)
+		scope := Scope(parts[0])
+		if !scope.Valid() {
+			return nil, fmt.Errorf("invalid scope: %s", parts[0])
+		}
+		var targets []string
+		if len(parts) == 2 {
+			for _, target := range strings.Split(parts[1], "|") {
+				if len(target) > 0 {
+					targets = append(targets, target)
+				}
+			}
+		}
+		permissions[scope] = append(permissions[scope], targets...)
+	}
+	return permissions, nil
+}
+
+// Valid returns true if the scope is a known scope
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeSend, ScopeRelay, ScopeRaw, ScopeJoin, ScopeRead, ScopeHTTP, ScopeQuery, ScopeCommands, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// authorise checks the plugin is allowed to call the method in the context
+func authorise(ctx context.Context, plugin *Plugin) error {
+	method, ok := grpc.Method(ctx)
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "unknown method")
+	}
+	scope, ok := methodScopes[method]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "unknown method: %s", method)
+	}
+	if scope != "" && !plugin.HasScope(scope) {
+		return status.Errorf(codes.PermissionDenied, "plugin %s does not have the %s scope", plugin.Name, scope)
+	}
+	if scope == ScopeHTTP {
+		path := metautils.ExtractIncoming(ctx).Get("path")
+		if !plugin.Allowed(ScopeHTTP, path) {
+			return status.Errorf(codes.PermissionDenied, "plugin %s may not register /%s", plugin.Name, path)
+		}
+	}
+	return nil
+}
+
+// authoriseRequest checks the plugin is allowed to act on the target of the request
+func authoriseRequest(ctx context.Context, req interface{}) error {
+	plugin, ok := PluginFromContext(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "access denied")
+	}
+	var scope Scope
+	var target string
+	switch request := req.(type) {
+	case *ChannelMessage:
+		scope, target = ScopeSend, request.Channel
+	case *RelayMessage:
+		scope, target = ScopeRelay, request.Channel
+	case *PrivateMessage:
+		scope, target = ScopeQuery, request.Nick
+	case *Channel:
+		method, _ := grpc.Method(ctx)
+		scope, target = methodScopes[method], request.Name
+	case *EventFilter:
+		return authoriseChannels(plugin, ScopeRead, request.Channels)
+	case *CommandRegistration:
+		for _, command := range request.Commands {
+			for _, name := range append([]string{command.Name}, command.Aliases...) {
+				if !plugin.Allowed(ScopeCommands, name) {
+					return status.Errorf(codes.PermissionDenied, "plugin %s may not register %s", plugin.Name, name)
+				}
+			}
+		}
+		return nil
+	default:
+		return nil
+	}
+	if !plugin.Allowed(scope, target) {
+		return status.Errorf(codes.PermissionDenied, "plugin %s may not use %s on %s", plugin.Name, scope, target)
+	}
+	return nil
+}
+
+// authoriseChannels checks the plugin is allowed to use the scope on all the channels, an empty list of channels
+// requires access to every channel
+func authoriseChannels(plugin *Plugin, scope Scope, channels []string) error {
+	if len(channels) == 0 {
+		channels = []string{"*"}
+	}
+	for _, channel := range channels {
+		if !plugin.Allowed(scope, channel) {
+			return status.Errorf(codes.PermissionDenied, "plugin %s may not use %s on %s", plugin.Name, scope, channel)
+		}
+	}
+	return nil
+}
+
+func (s *GrpcServer) unaryTargetInterceptor(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if err := authoriseRequest(ctx, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *GrpcServer) streamTargetInterceptor(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	if plugin, ok := PluginFromContext(ctx); ok {
+		defer s.plugins.track(plugin.credential(), cancel)()
+	}
+	return handler(srv, &authorisedStream{ServerStream: stream, ctx: ctx})
+}
+
+// authorisedStream checks each message received from a plugin against its permissions, its context is cancelled if
+// the plugin's token or identity is revoked
+type authorisedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authorisedStream) Context() context.Context {
+	return a.ctx
+}
+
+func (a *authorisedStream) RecvMsg(m interface{}) error {
+	if err := a.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return authoriseRequest(a.Context(), m)
+}
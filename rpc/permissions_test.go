@@ -0,0 +1,125 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParsePluginString(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        string
+		wantPlugins []Plugin
+		wantErr     bool
+	}{
+		{
+			name:        "legacy plugin",
+			args:        "webhook=token",
+			wantPlugins: []Plugin{{Name: "webhook", Token: "token"}},
+		},
+		{
+			name: "plugin with scopes",
+			args: "webhook=token=send:#ops|#deploys;relay;http:github",
+			wantPlugins: []Plugin{{Name: "webhook", Token: "token", Permissions: Permissions{
+				ScopeSend:  {"#ops", "#deploys"},
+				ScopeRelay: nil,
+				ScopeHTTP:  {"github"},
+			}}},
+		},
+		{
+			name:    "invalid scope",
+			args:    "webhook=token=superuser",
+			wantErr: true,
+		},
+		{
+			name:    "missing token",
+			args:    "webhook",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPlugins, err := ParsePluginString(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePluginString() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotPlugins, tt.wantPlugins) {
+				t.Errorf("ParsePluginString() got = %#+v, want %#+v", gotPlugins, tt.wantPlugins)
+			}
+		})
+	}
+}
+
+func TestPlugin_Allowed(t *testing.T) {
+	plugin := &Plugin{Name: "webhook", Token: "token", Permissions: Permissions{
+		ScopeSend:  {"#ops", "#deploys"},
+		ScopeRelay: {},
+		ScopeHTTP:  {"github"},
+	}}
+	folded := &Plugin{Name: "ops", Permissions: Permissions{ScopeSend: {"#ops[1]"}}, fold: rfc1459Fold}
+	tests := []struct {
+		name   string
+		plugin *Plugin
+		scope  Scope
+		target string
+		want   bool
+	}{
+		{name: "listed channel", plugin: plugin, scope: ScopeSend, target: "#ops", want: true},
+		{name: "listed channel different case", plugin: plugin, scope: ScopeSend, target: "#OPS", want: true},
+		{name: "unlisted channel", plugin: plugin, scope: ScopeSend, target: "#secret", want: false},
+		{name: "scope without targets", plugin: plugin, scope: ScopeRelay, target: "#secret", want: true},
+		{name: "missing scope", plugin: plugin, scope: ScopeRaw, target: "", want: false},
+		{name: "http prefix", plugin: plugin, scope: ScopeHTTP, target: "github/hook", want: true},
+		{name: "other http prefix", plugin: plugin, scope: ScopeHTTP, target: "gitlab", want: false},
+		{name: "exact http prefix", plugin: plugin, scope: ScopeHTTP, target: "/github", want: true},
+		{name: "http prefix without segment boundary", plugin: plugin, scope: ScopeHTTP, target: "githubevil", want: false},
+		{name: "legacy plugin", plugin: &Plugin{Name: "old"}, scope: ScopeRaw, target: "", want: true},
+		{name: "server casemapping", plugin: folded, scope: ScopeSend, target: "#OPS{1}", want: true},
+		{name: "different channel with server casemapping", plugin: folded, scope: ScopeSend, target: "#ops[2]", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.plugin.Allowed(tt.scope, tt.target); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_methodScopes(t *testing.T) {
+	for _, method := range IRCPluginV2_ServiceDesc.Methods {
+		if _, ok := methodScopes["/rpc.IRCPluginV2/"+method.MethodName]; !ok {
+			t.Errorf("no scope for IRCPluginV2 method %s", method.MethodName)
+		}
+	}
+	for _, stream := range IRCPluginV2_ServiceDesc.Streams {
+		if _, ok := methodScopes["/rpc.IRCPluginV2/"+stream.StreamName]; !ok {
+			t.Errorf("no scope for IRCPluginV2 stream %s", stream.StreamName)
+		}
+	}
+}
+
+func Test_authoriseRequest_CommandRegistration(t *testing.T) {
+	plugin := &Plugin{Name: "deploy", Permissions: Permissions{ScopeCommands: {"deploy", "ship"}}}
+	tests := []struct {
+		name    string
+		command *Command
+		wantErr bool
+	}{
+		{name: "allowed", command: &Command{Name: "deploy"}},
+		{name: "allowed alias", command: &Command{Name: "deploy", Aliases: []string{"ship"}}},
+		{name: "other command", command: &Command{Name: "op"}, wantErr: true},
+		{name: "other alias", command: &Command{Name: "deploy", Aliases: []string{"ship", "help"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authoriseRequest(contextWithPlugin(context.Background(), plugin),
+				&CommandRegistration{Commands: []*Command{tt.command}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authoriseRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
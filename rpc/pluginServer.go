@@ -3,64 +3,100 @@ package rpc
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/ergochat/irc-go/ircevent"
 	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/bot"
+	"github.com/greboid/irc-bot/v5/irc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type IRCFunctions interface {
 	GetChannels() []string
+	JoinChannel(ctx context.Context, name string, key string) error
+	PartChannel(ctx context.Context, name string) error
 	CurrentNick() string
 	RemoveCallback(id ircevent.CallbackID)
 	AddCallback(string, func(ircmsg.Message)) ircevent.CallbackID
+	RegisterCommands(owner string, commands []bot.Command, handler func(bot.CommandInvocation)) (func(), error)
+	Account(nick string) string
+	Permissions(source string, account string, channel string) []string
+	HasPermission(source string, account string, channel string, permission string) bool
+	ChannelUsers(channel string) ([]bot.ChannelUser, bool)
+	ChannelTopic(channel string) (bot.ChannelTopic, bool)
+	ChannelModes(channel string) (map[string]string, bool)
+	ChannelHistory(ctx context.Context, channel string, afterID string, after time.Time) ([]ircmsg.Message, error)
+	Fold(name string) string
+	ConnectionState() irc.StateChange
+	AddStateCallback(func(irc.StateChange)) func()
 }
 
 type IRCSender interface {
-	Join(string) error
-	Part(string) error
-	SendRawf(string, ...interface{}) error
-	SendRelayMessage(channel string, nickname string, message string) error
+	SendRawfContext(ctx context.Context, format string, args ...interface{}) error
+	SendMessageContext(ctx context.Context, command string, target string, message string) error
+	SendMessageConfirmed(ctx context.Context, command string, target string, message string) (irc.Delivery, error)
+	ValidateChannel(name string) error
+	ValidateNick(name string) error
+	SendRelayMessageContext(ctx context.Context, channel string, nickname string, message string) error
+	SendRelayMessageConfirmed(ctx context.Context, channel string, nickname string, message string) (irc.Delivery, error)
+	QueueDepth(owner string) (owned int, total int)
 }
 
 type pluginServer struct {
 	sender    IRCSender
 	functions IRCFunctions
+	reload    func() error
+	buffers   *bufferRegistry
+	bus       *eventBus
+	stopping  <-chan struct{}
 }
 
-func (ps *pluginServer) SendRelayMessage(_ context.Context, message *RelayMessage) (*Error, error) {
-	err := ps.sender.SendRelayMessage(message.Channel, message.Nick, message.Message)
+func (ps *pluginServer) SendRelayMessage(ctx context.Context, message *RelayMessage) (*Error, error) {
+	_, err := ps.sendRelayMessage(ctx, message, false)
+	return legacyError(err)
+}
+
+// sendRelayMessage relays the message, waiting for the server to confirm it was delivered if confirm is set
+func (ps *pluginServer) sendRelayMessage(ctx context.Context, message *RelayMessage, confirm bool) (*Delivery, error) {
+	if err := ps.sender.ValidateChannel(message.Channel); err != nil {
+		return nil, sendError(err, "channel", "")
+	}
+	var delivery irc.Delivery
+	var err error
+	if confirm {
+		delivery, err = ps.sender.SendRelayMessageConfirmed(ctx, message.Channel, message.Nick, message.Message)
+	} else {
+		err = ps.sender.SendRelayMessageContext(ctx, message.Channel, message.Nick, message.Message)
+	}
 	if err != nil {
-		return &Error{
-			Message: err.Error(),
-		}, err
+		return nil, sendError(err, "nick", "message")
 	}
-	return &Error{
-		Message: "",
-	}, nil
+	return newDelivery(delivery), nil
 }
 
-func (ps *pluginServer) JoinChannel(_ context.Context, channel *Channel) (*Error, error) {
-	err := ps.sender.Join(channel.Name)
-	if err != nil {
-		return &Error{
-			Message: channel.Name,
-		}, err
+func (ps *pluginServer) JoinChannel(ctx context.Context, channel *Channel) (*Error, error) {
+	return legacyError(ps.joinChannel(ctx, channel))
+}
+
+func (ps *pluginServer) joinChannel(ctx context.Context, channel *Channel) error {
+	if err := ps.functions.JoinChannel(ctx, channel.Name, channel.Key); err != nil {
+		return sendError(err, "name", "key")
 	}
-	return &Error{
-		Message: "",
-	}, nil
+	return nil
 }
 
-func (ps *pluginServer) LeaveChannel(_ context.Context, channel *Channel) (*Error, error) {
-	err := ps.sender.Part(channel.Name)
-	if err != nil {
-		return &Error{
-			Message: channel.Name,
-		}, err
+func (ps *pluginServer) LeaveChannel(ctx context.Context, channel *Channel) (*Error, error) {
+	return legacyError(ps.leaveChannel(ctx, channel))
+}
+
+func (ps *pluginServer) leaveChannel(ctx context.Context, channel *Channel) error {
+	if err := ps.functions.PartChannel(ctx, channel.Name); err != nil {
+		return sendError(err, "name", "")
 	}
-	return &Error{
-		Message: "",
-	}, nil
+	return nil
 }
 
 func (ps *pluginServer) ListChannel(_ context.Context, _ *Empty) (*ChannelList, error) {
@@ -69,68 +105,257 @@ func (ps *pluginServer) ListChannel(_ context.Context, _ *Empty) (*ChannelList,
 	}, nil
 }
 
+func (ps *pluginServer) Reload(ctx context.Context, empty *Empty) (*Error, error) {
+	return legacyError(ps.reloadConfig(ctx, empty))
+}
+
+func (ps *pluginServer) reloadConfig(_ context.Context, _ *Empty) error {
+	if ps.reload == nil {
+		return status.Error(codes.Unimplemented, "reloading is not supported")
+	}
+	if err := ps.reload(); err != nil {
+		return statusError(codes.FailedPrecondition, ReasonReloadFailed, err.Error())
+	}
+	return nil
+}
+
 func (ps *pluginServer) mustEmbedUnimplementedIRCPluginServer() {
 }
 
-func (ps *pluginServer) SendChannelMessage(_ context.Context, req *ChannelMessage) (*Error, error) {
-	err := ps.sender.SendRawf("PRIVMSG %s :%s", req.Channel, req.Message)
+func (ps *pluginServer) SendChannelMessage(ctx context.Context, req *ChannelMessage) (*Error, error) {
+	_, err := ps.sendChannelMessage(ctx, req, false)
+	return legacyError(err)
+}
+
+// sendChannelMessage sends the message, waiting for the server to confirm it was delivered if confirm is set
+func (ps *pluginServer) sendChannelMessage(ctx context.Context, req *ChannelMessage, confirm bool) (*Delivery, error) {
+	if err := ps.sender.ValidateChannel(req.Channel); err != nil {
+		return nil, sendError(err, "channel", "")
+	}
+	delivery, err := ps.sendMessage(ctx, "PRIVMSG", req.Channel, req.Message, confirm)
 	if err != nil {
-		return &Error{
-			Message: err.Error(),
-		}, err
+		return nil, sendError(err, "channel", "message")
 	}
-	return &Error{
-		Message: "",
-	}, nil
+	return newDelivery(delivery), nil
 }
-func (ps *pluginServer) SendRawMessage(_ context.Context, req *RawMessage) (*Error, error) {
-	err := ps.sender.SendRawf("%s", req.Message)
-	if err != nil {
-		return &Error{
-			Message: err.Error(),
-		}, err
+
+func (ps *pluginServer) SendRawMessage(ctx context.Context, req *RawMessage) (*Error, error) {
+	return legacyError(ps.sendRawMessage(ctx, req))
+}
+
+func (ps *pluginServer) sendRawMessage(ctx context.Context, req *RawMessage) error {
+	if err := ps.sender.SendRawfContext(ctx, "%s", req.Message); err != nil {
+		return sendError(err, "", "message")
 	}
-	return &Error{
-		Message: "",
-	}, nil
+	return nil
 }
 
 func (ps *pluginServer) GetMessages(channel *Channel, stream IRCPlugin_GetMessagesServer) error {
-	exitLoop := make(chan bool, 1)
-	chanMessage := make(chan *ircmsg.Message, 1)
 	channelName := channel.Name
-	defer ps.functions.RemoveCallback(ps.functions.AddCallback("PART", func(message ircmsg.Message) {
-		if message.Params[1] == channelName {
-			exitLoop <- true
+	plugin, _ := PluginFromContext(stream.Context())
+	subscriber, unsubscribe := ps.bus.subscribe(stream.Context(), []string{"PRIVMSG", "PART", "KICK"}, func(message ircmsg.Message) bool {
+		switch message.Command {
+		case "PART":
+			return len(message.Params) > 0 && ps.isMe(message.Nick()) && ps.sameName(message.Params[0], channelName)
+		case "KICK":
+			return len(message.Params) > 1 && ps.isMe(message.Params[1]) && ps.sameName(message.Params[0], channelName)
 		}
-	}))
-	defer ps.functions.RemoveCallback(ps.functions.AddCallback("KICK", func(message ircmsg.Message) {
-		if message.Params[1] == ps.functions.CurrentNick() && message.Params[1] == channelName {
-			exitLoop <- true
+		return len(message.Params) > 1 && ps.matchesChannel(plugin, channelName, message)
+	})
+	defer unsubscribe()
+	defer ps.buffers.subscribe(stream.Context(), &bufferSubscription{Kind: subscriptionMessages, Channel: channelName})()
+	// Messages arriving while history is replayed wait in the subscriber's buffer, any that were also replayed are
+	// skipped
+	replayed := map[string]bool{}
+	if len(channel.AfterMsgid) > 0 || channel.After != nil {
+		var err error
+		if replayed, err = ps.replayMessages(channel, stream); err != nil {
+			return err
 		}
-	}))
-	defer ps.functions.RemoveCallback(ps.functions.AddCallback("PRIVMSG", func(message ircmsg.Message) {
-		if channelName == "*" || strings.ToLower(message.Params[0]) == strings.ToLower(channelName) {
-			chanMessage <- &message
-		}
-	}))
+	}
 	for {
 		select {
-		case <-exitLoop:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-subscriber.overflow:
+			return overflowError()
+		case <-ps.stopping:
 			return nil
-		case msg := <-chanMessage:
-			if err := stream.Send(&ChannelMessage{
-				Channel: strings.ToLower(msg.Params[0]),
-				Message: strings.Join(msg.Params[1:], " "),
-				Tags:    msg.AllTags(),
-				Source:  msg.Source,
-			}); err != nil {
+		case msg := <-subscriber.messages:
+			if msg.Command != "PRIVMSG" {
+				// The bot has left the channel
+				return nil
+			}
+			if _, msgid := msg.GetTag("msgid"); len(msgid) > 0 && replayed[msgid] {
+				continue
+			}
+			if err := stream.Send(ps.channelMessage(&msg)); err != nil {
 				return err
 			}
 		}
 	}
 }
 
+// replayMessages sends the history of the channels a plugin subscribed to from the point it asked to resume from,
+// returning the msgids sent so they aren't sent again if they also arrived while replaying
+func (ps *pluginServer) replayMessages(channel *Channel, stream IRCPlugin_GetMessagesServer) (map[string]bool, error) {
+	channels := []string{channel.Name}
+	if channel.Name == "*" {
+		channels = ps.functions.GetChannels()
+	}
+	var after time.Time
+	if channel.After != nil {
+		after = channel.After.AsTime()
+	}
+	replayed := map[string]bool{}
+	for _, name := range channels {
+		messages, err := ps.functions.ChannelHistory(stream.Context(), name, channel.AfterMsgid, after)
+		if err != nil {
+			return nil, sendError(err, "name", "after_msgid")
+		}
+		for index := range messages {
+			if _, msgid := messages[index].GetTag("msgid"); len(msgid) > 0 {
+				replayed[msgid] = true
+			}
+			if err := stream.Send(ps.channelMessage(&messages[index])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return replayed, nil
+}
+
+// matchesChannel returns true if the message was sent to the channel.  If the name is * it matches everything the bot
+// receives, but messages sent to the bot are only included if the plugin may read them.
+func (ps *pluginServer) matchesChannel(plugin *Plugin, channelName string, message ircmsg.Message) bool {
+	if channelName != "*" {
+		return ps.sameName(message.Params[0], channelName)
+	}
+	return !ps.isPrivate(message) || plugin == nil || plugin.HasScope(ScopeQuery)
+}
+
+// sameName returns true if the server treats the channel or nick names as the same
+func (ps *pluginServer) sameName(name string, other string) bool {
+	return ps.functions.Fold(name) == ps.functions.Fold(other)
+}
+
+// channelMessage converts a message sent to a channel into the message sent to plugins
+func (ps *pluginServer) channelMessage(msg *ircmsg.Message) *ChannelMessage {
+	account := ps.account(msg)
+	_, msgid := msg.GetTag("msgid")
+	return &ChannelMessage{
+		Channel:     strings.ToLower(msg.Params[0]),
+		Message:     strings.Join(msg.Params[1:], " "),
+		Tags:        msg.AllTags(),
+		Source:      msg.Source,
+		Account:     account,
+		Permissions: ps.functions.Permissions(msg.Source, account, msg.Params[0]),
+		Msgid:       msgid,
+		Time:        timestamppb.New(bot.MessageTime(*msg)),
+	}
+}
+
 func (ps *pluginServer) Ping(context.Context, *Empty) (*Empty, error) {
 	return &Empty{}, nil
 }
+
+func (ps *pluginServer) SendPrivateMessage(ctx context.Context, req *PrivateMessage) (*Error, error) {
+	_, err := ps.sendPrivateMessage(ctx, req, false)
+	return legacyError(err)
+}
+
+// sendPrivateMessage sends the message, waiting for the server to confirm it was delivered if confirm is set
+func (ps *pluginServer) sendPrivateMessage(ctx context.Context, req *PrivateMessage, confirm bool) (*Delivery, error) {
+	command := "PRIVMSG"
+	if req.Notice {
+		command = "NOTICE"
+	}
+	if err := ps.sender.ValidateNick(req.Nick); err != nil {
+		return nil, sendError(err, "nick", "")
+	}
+	delivery, err := ps.sendMessage(ctx, command, req.Nick, req.Message, confirm)
+	if err != nil {
+		return nil, sendError(err, "nick", "message")
+	}
+	return newDelivery(delivery), nil
+}
+
+// sendMessage sends the message, only waiting for the server to confirm it was delivered if confirm is set
+func (ps *pluginServer) sendMessage(ctx context.Context, command string, target string, message string,
+	confirm bool) (irc.Delivery, error) {
+	if confirm {
+		return ps.sender.SendMessageConfirmed(ctx, command, target, message)
+	}
+	return irc.Delivery{}, ps.sender.SendMessageContext(ctx, command, target, message)
+}
+
+// newDelivery converts the result of sending a message into its RPC form
+func newDelivery(delivery irc.Delivery) *Delivery {
+	return &Delivery{
+		Confirmed: delivery.Confirmed,
+		Msgids:    delivery.MsgIDs,
+	}
+}
+
+// isPrivate returns true if the message was sent to the bot rather than a channel
+func (ps *pluginServer) isPrivate(message ircmsg.Message) bool {
+	return len(message.Params) > 1 && ps.isMe(message.Params[0])
+}
+
+// isMe returns true if the nickname is the bot's
+func (ps *pluginServer) isMe(nick string) bool {
+	return strings.EqualFold(nick, ps.functions.CurrentNick())
+}
+
+// privateMessage converts a message sent to the bot into the message sent to plugins, returning nil if it was sent
+// to a channel
+func (ps *pluginServer) privateMessage(message ircmsg.Message) *PrivateMessage {
+	if !ps.isPrivate(message) {
+		return nil
+	}
+	account := ps.account(&message)
+	return &PrivateMessage{
+		Nick:        message.Nick(),
+		Message:     message.Params[1],
+		Source:      message.Source,
+		Tags:        message.AllTags(),
+		Notice:      message.Command == "NOTICE",
+		Account:     account,
+		Permissions: ps.functions.Permissions(message.Source, account, ""),
+	}
+}
+
+func (ps *pluginServer) GetPrivateMessages(_ *Empty, stream IRCPlugin_GetPrivateMessagesServer) error {
+	subscriber, unsubscribe := ps.bus.subscribe(stream.Context(), []string{"PRIVMSG", "NOTICE"}, ps.isPrivate)
+	defer unsubscribe()
+	defer ps.buffers.subscribe(stream.Context(), &bufferSubscription{Kind: subscriptionPrivate})()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-subscriber.overflow:
+			return overflowError()
+		case <-ps.stopping:
+			return nil
+		case msg := <-subscriber.messages:
+			if err := stream.Send(ps.privateMessage(msg)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (ps *pluginServer) CheckPermission(_ context.Context, check *PermissionCheck) (*PermissionResult, error) {
+	return &PermissionResult{
+		Allowed:     ps.functions.HasPermission(check.Source, check.Account, check.Channel, check.Permission),
+		Permissions: ps.functions.Permissions(check.Source, check.Account, check.Channel),
+	}, nil
+}
+
+// account returns the services account of the sender of a message, from its tags or the bot's last known account
+func (ps *pluginServer) account(message *ircmsg.Message) string {
+	if ok, account := message.GetTag("account"); ok {
+		return account
+	}
+	return ps.functions.Account(message.Nick())
+}
@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+)
+
+// pluginServerV2 implements the IRCPluginV2 service, which has the same methods as IRCPlugin but reports failures
+// only through gRPC status codes rather than also returning an Error
+type pluginServerV2 struct {
+	*pluginServer
+}
+
+func (ps *pluginServerV2) mustEmbedUnimplementedIRCPluginV2Server() {
+}
+
+// empty returns the response for an RPC that has nothing to return but whether it succeeded
+func empty(err error) (*Empty, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &Empty{}, nil
+}
+
+func (ps *pluginServerV2) SendChannelMessage(ctx context.Context, req *ChannelMessage) (*Delivery, error) {
+	return ps.sendChannelMessage(ctx, req, true)
+}
+
+func (ps *pluginServerV2) SendRelayMessage(ctx context.Context, req *RelayMessage) (*Delivery, error) {
+	return ps.sendRelayMessage(ctx, req, true)
+}
+
+func (ps *pluginServerV2) SendRawMessage(ctx context.Context, req *RawMessage) (*Empty, error) {
+	return empty(ps.sendRawMessage(ctx, req))
+}
+
+func (ps *pluginServerV2) SendPrivateMessage(ctx context.Context, req *PrivateMessage) (*Delivery, error) {
+	return ps.sendPrivateMessage(ctx, req, true)
+}
+
+func (ps *pluginServerV2) JoinChannel(ctx context.Context, channel *Channel) (*Empty, error) {
+	return empty(ps.joinChannel(ctx, channel))
+}
+
+func (ps *pluginServerV2) LeaveChannel(ctx context.Context, channel *Channel) (*Empty, error) {
+	return empty(ps.leaveChannel(ctx, channel))
+}
+
+func (ps *pluginServerV2) Reload(ctx context.Context, req *Empty) (*Empty, error) {
+	return empty(ps.reloadConfig(ctx, req))
+}
+
+func (ps *pluginServerV2) GetMessages(channel *Channel, stream IRCPluginV2_GetMessagesServer) error {
+	return ps.pluginServer.GetMessages(channel, stream)
+}
+
+func (ps *pluginServerV2) GetEvents(filter *EventFilter, stream IRCPluginV2_GetEventsServer) error {
+	return ps.pluginServer.GetEvents(filter, stream)
+}
+
+func (ps *pluginServerV2) GetPrivateMessages(req *Empty, stream IRCPluginV2_GetPrivateMessagesServer) error {
+	return ps.pluginServer.GetPrivateMessages(req, stream)
+}
+
+func (ps *pluginServerV2) GetBuffered(req *Empty, stream IRCPluginV2_GetBufferedServer) error {
+	return ps.pluginServer.GetBuffered(req, stream)
+}
+
+func (ps *pluginServerV2) RegisterCommands(registration *CommandRegistration, stream IRCPluginV2_RegisterCommandsServer) error {
+	return ps.pluginServer.RegisterCommands(registration, stream)
+}
+
+func (ps *pluginServerV2) GetConnectionState(req *Empty, stream IRCPluginV2_GetConnectionStateServer) error {
+	return ps.pluginServer.GetConnectionState(req, stream)
+}
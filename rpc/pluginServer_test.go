@@ -4,26 +4,61 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/ergochat/irc-go/ircevent"
+	"github.com/ergochat/irc-go/ircmsg"
+	"github.com/greboid/irc-bot/v5/irc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type fakeIRCSender struct {
 	sendMessages []string
 }
 
-func (s *fakeIRCSender) SendRelayMessage(channel string, nickname string, message string) error {
+func (s *fakeIRCSender) SendRelayMessageConfirmed(ctx context.Context, channel string, nickname string, message string) (irc.Delivery, error) {
 	panic("implement me")
 }
 
-func (s *fakeIRCSender) Join(s2 string) error {
+func (s *fakeIRCSender) QueueDepth(owner string) (int, int) {
 	panic("implement me")
 }
 
-func (s *fakeIRCSender) Part(s2 string) error {
+func (s *fakeIRCSender) ValidateChannel(name string) error {
+	if !strings.HasPrefix(name, "#") || strings.ContainsAny(name, " \r\n") {
+		return fmt.Errorf("%w: %q", irc.ErrInvalidTarget, name)
+	}
+	return nil
+}
+
+func (s *fakeIRCSender) ValidateNick(name string) error {
+	if strings.HasPrefix(name, "#") || strings.ContainsAny(name, " \r\n") {
+		return fmt.Errorf("%w: %q", irc.ErrInvalidTarget, name)
+	}
+	return nil
+}
+
+func (s *fakeIRCSender) SendMessageConfirmed(ctx context.Context, command string, target string, message string) (irc.Delivery, error) {
+	if strings.Contains(message, "banned") {
+		return irc.Delivery{}, &irc.RejectedError{Reply: "404", Message: "Cannot send to channel"}
+	}
+	return irc.Delivery{Confirmed: true, MsgIDs: []string{"msgid"}}, s.SendRawfContext(ctx, "%s %s :%s", command, target, message)
+}
+
+func (s *fakeIRCSender) SendMessageContext(ctx context.Context, command string, target string, message string) error {
+	return s.SendRawfContext(ctx, "%s %s :%s", command, target, message)
+}
+
+func (s *fakeIRCSender) SendRelayMessageContext(ctx context.Context, channel string, nickname string, message string) error {
 	panic("implement me")
 }
 
-func (s *fakeIRCSender) SendRawf(string string, i ...interface{}) error {
+func (s *fakeIRCSender) SendRawfContext(ctx context.Context, string string, i ...interface{}) error {
 	fmt.Printf("----\n")
 	fmt.Printf(string, i...)
 	fmt.Printf("\n")
@@ -52,6 +87,24 @@ func Test_pluginServer_SendChannelMessage(t *testing.T) {
 			wantErr:      false,
 			wantMessages: []string{"PRIVMSG #test :This is a test"},
 		},
+		{
+			name:   "Injected channel",
+			sender: &fakeIRCSender{},
+			req: &ChannelMessage{
+				Channel: "#test :hi\r\nQUIT",
+				Message: "This is a test",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Nickname instead of channel",
+			sender: &fakeIRCSender{},
+			req: &ChannelMessage{
+				Channel: "greboid",
+				Message: "This is a test",
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -64,9 +117,268 @@ func Test_pluginServer_SendChannelMessage(t *testing.T) {
 				t.Errorf("SendChannelMessage() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr && status.Code(err) != codes.InvalidArgument {
+				t.Errorf("SendChannelMessage() code = %v, want %v", status.Code(err), codes.InvalidArgument)
+			}
 			if !reflect.DeepEqual(tt.sender.sendMessages, tt.wantMessages) {
 				t.Errorf("SendChannelMessage() got = %#+v, want %#+v", tt.sender.sendMessages, tt.wantMessages)
 			}
 		})
 	}
 }
+
+func Test_pluginServer_SendPrivateMessage(t *testing.T) {
+	tests := []struct {
+		name         string
+		sender       *fakeIRCSender
+		req          *PrivateMessage
+		wantErr      bool
+		wantMessages []string
+	}{
+		{
+			name:   "Send private message",
+			sender: &fakeIRCSender{},
+			req: &PrivateMessage{
+				Nick:    "greboid",
+				Message: "This is a test",
+			},
+			wantErr:      false,
+			wantMessages: []string{"PRIVMSG greboid :This is a test"},
+		},
+		{
+			name:   "Send private notice",
+			sender: &fakeIRCSender{},
+			req: &PrivateMessage{
+				Nick:    "greboid",
+				Message: "This is a test",
+				Notice:  true,
+			},
+			wantErr:      false,
+			wantMessages: []string{"NOTICE greboid :This is a test"},
+		},
+		{
+			name:   "Injected nick",
+			sender: &fakeIRCSender{},
+			req: &PrivateMessage{
+				Nick:    "greboid :hi\r\nQUIT",
+				Message: "This is a test",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := &pluginServer{
+				sender:    tt.sender,
+				functions: nil,
+			}
+			_, err := ps.SendPrivateMessage(context.Background(), tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SendPrivateMessage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr && status.Code(err) != codes.InvalidArgument {
+				t.Errorf("SendPrivateMessage() code = %v, want %v", status.Code(err), codes.InvalidArgument)
+			}
+			if !reflect.DeepEqual(tt.sender.sendMessages, tt.wantMessages) {
+				t.Errorf("SendPrivateMessage() got = %#+v, want %#+v", tt.sender.sendMessages, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func Test_pluginServerV2_SendChannelMessage(t *testing.T) {
+	sender := &fakeIRCSender{}
+	ps := &pluginServerV2{pluginServer: &pluginServer{sender: sender}}
+	delivery, err := ps.SendChannelMessage(context.Background(), &ChannelMessage{Channel: "#test", Message: "hello"})
+	if err != nil {
+		t.Fatalf("SendChannelMessage() error = %v", err)
+	}
+	if !delivery.Confirmed || !reflect.DeepEqual(delivery.Msgids, []string{"msgid"}) {
+		t.Errorf("SendChannelMessage() = %v, want the confirmed delivery", delivery)
+	}
+	if _, err := ps.SendChannelMessage(context.Background(), &ChannelMessage{Channel: "#test", Message: "banned"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("SendChannelMessage() error = %v, want FailedPrecondition when the server rejects it", err)
+	}
+	response, err := ps.SendChannelMessage(context.Background(), &ChannelMessage{Channel: "#test\r\nQUIT", Message: "hello"})
+	if response != nil || status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("SendChannelMessage() = %v, %v, want InvalidArgument", response, err)
+	}
+	details := status.Convert(err).Details()
+	if len(details) != 2 {
+		t.Fatalf("SendChannelMessage() details = %v, want ErrorInfo and BadRequest", details)
+	}
+	if violation := details[1].(*errdetails.BadRequest).FieldViolations[0]; violation.Field != "channel" {
+		t.Errorf("SendChannelMessage() field = %v, want channel", violation.Field)
+	}
+	if !reflect.DeepEqual(sender.sendMessages, []string{"PRIVMSG #test :hello"}) {
+		t.Errorf("SendChannelMessage() sent %#v, want only the valid message", sender.sendMessages)
+	}
+}
+
+func Test_pluginServer_SendChannelMessage_Unconfirmed(t *testing.T) {
+	sender := &fakeIRCSender{}
+	ps := &pluginServer{sender: sender}
+	response, err := ps.SendChannelMessage(context.Background(), &ChannelMessage{Channel: "#test", Message: "banned"})
+	if err != nil || response.Message != "" {
+		t.Fatalf("SendChannelMessage() = %v, %v, want success without waiting for the server", response, err)
+	}
+	if !reflect.DeepEqual(sender.sendMessages, []string{"PRIVMSG #test :banned"}) {
+		t.Errorf("SendChannelMessage() sent %#v, want the message", sender.sendMessages)
+	}
+}
+
+type fakeIRCFunctions struct {
+	IRCFunctions
+	mutex     sync.Mutex
+	callbacks map[string]func(ircmsg.Message)
+	history   []ircmsg.Message
+	removed   int
+}
+
+func (f *fakeIRCFunctions) AddCallback(command string, callback func(ircmsg.Message)) ircevent.CallbackID {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.callbacks[command] = callback
+	return ircevent.CallbackID{}
+}
+
+func (f *fakeIRCFunctions) RemoveCallback(ircevent.CallbackID) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.removed++
+}
+
+func (f *fakeIRCFunctions) subscribed(command string) (func(ircmsg.Message), bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	callback, ok := f.callbacks[command]
+	return callback, ok
+}
+
+func (f *fakeIRCFunctions) CurrentNick() string { return "bot" }
+
+func (f *fakeIRCFunctions) Fold(name string) string { return rfc1459Fold(name) }
+
+// rfc1459Fold folds names like a server with the rfc1459 CASEMAPPING, where []\~ are the upper case forms of {}|^
+func rfc1459Fold(name string) string {
+	return strings.NewReplacer("[", "{", "]", "}", "\\", "|", "~", "^").Replace(strings.ToLower(name))
+}
+
+func (f *fakeIRCFunctions) Account(string) string { return "" }
+
+func (f *fakeIRCFunctions) Permissions(string, string, string) []string { return nil }
+
+func (f *fakeIRCFunctions) ChannelHistory(_ context.Context, _ string, afterID string, _ time.Time) ([]ircmsg.Message, error) {
+	if afterID != "a" {
+		return nil, fmt.Errorf("%w: unexpected msgid %q", irc.ErrInvalidText, afterID)
+	}
+	// Messages arriving while the history is fetched are held until it has been sent
+	for _, line := range []string{"@msgid=c :nick!user@host PRIVMSG #test :three", "@msgid=d :nick!user@host PRIVMSG #test :four"} {
+		message, _ := ircmsg.ParseLine(line)
+		f.callbacks["PRIVMSG"](message)
+	}
+	return f.history, nil
+}
+
+type fakeMessageStream struct {
+	IRCPlugin_GetMessagesServer
+	ctx    context.Context
+	cancel func()
+	sent   []string
+	want   int
+}
+
+func (s *fakeMessageStream) Context() context.Context { return s.ctx }
+
+func (s *fakeMessageStream) Send(message *ChannelMessage) error {
+	s.sent = append(s.sent, message.Msgid+" "+message.Message)
+	if len(s.sent) == s.want {
+		s.cancel()
+	}
+	return nil
+}
+
+func Test_pluginServer_GetMessages_Resume(t *testing.T) {
+	var history []ircmsg.Message
+	for _, line := range []string{"@msgid=b :nick!user@host PRIVMSG #test :two", "@msgid=c :nick!user@host PRIVMSG #test :three"} {
+		message, _ := ircmsg.ParseLine(line)
+		history = append(history, message)
+	}
+	functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}, history: history}
+	ps := &pluginServer{functions: functions, bus: newEventBus()}
+	ps.bus.start(functions)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeMessageStream{ctx: ctx, cancel: cancel, want: 3}
+	if err := ps.GetMessages(&Channel{Name: "#test", AfterMsgid: "a"}, stream); err != context.Canceled {
+		t.Errorf("GetMessages() error = %v, want %v", err, context.Canceled)
+	}
+	if want := []string{"b two", "c three", "d four"}; !reflect.DeepEqual(stream.sent, want) {
+		t.Errorf("GetMessages() sent %v, want %v", stream.sent, want)
+	}
+	stream = &fakeMessageStream{ctx: context.Background()}
+	if err := ps.GetMessages(&Channel{Name: "#test", AfterMsgid: "bad id"}, stream); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetMessages() error = %v, want InvalidArgument", err)
+	}
+}
+
+func Test_pluginServer_GetMessages_Leave(t *testing.T) {
+	functions := &fakeIRCFunctions{callbacks: map[string]func(ircmsg.Message){}}
+	ps := &pluginServer{functions: functions, bus: newEventBus()}
+	ps.bus.start(functions)
+	stream := &fakeMessageStream{ctx: context.Background()}
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.GetMessages(&Channel{Name: "#test"}, stream)
+	}()
+	for {
+		if _, ok := functions.subscribed("PART"); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for _, line := range []string{":nick!user@host PART #test :bye", ":bot!bot@host PART #other", ":bot!bot@host PART #Test :bye"} {
+		message, _ := ircmsg.ParseLine(line)
+		callback, _ := functions.subscribed(message.Command)
+		callback(message)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("GetMessages() error = %v, want nil once the bot has left", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetMessages() didn't return after the bot left the channel")
+	}
+}
+
+func Test_pluginServer_matchesChannel(t *testing.T) {
+	reader := &Plugin{Name: "reader", Permissions: Permissions{ScopeRead: nil}}
+	query := &Plugin{Name: "query", Permissions: Permissions{ScopeRead: nil, ScopeQuery: nil}}
+	tests := []struct {
+		name    string
+		plugin  *Plugin
+		channel string
+		line    string
+		want    bool
+	}{
+		{name: "named channel", plugin: reader, channel: "#test", line: ":nick!user@host PRIVMSG #Test :hi", want: true},
+		{name: "other channel", plugin: reader, channel: "#test", line: ":nick!user@host PRIVMSG #other :hi", want: false},
+		{name: "any channel", plugin: reader, channel: "*", line: ":nick!user@host PRIVMSG #other :hi", want: true},
+		{name: "private message without query", plugin: reader, channel: "*", line: ":nick!user@host PRIVMSG bot :hi", want: false},
+		{name: "private message with query", plugin: query, channel: "*", line: ":nick!user@host PRIVMSG Bot :hi", want: true},
+		{name: "private message without a plugin", channel: "*", line: ":nick!user@host PRIVMSG bot :hi", want: true},
+	}
+	ps := &pluginServer{functions: &fakeIRCFunctions{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := ircmsg.ParseLine(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ps.matchesChannel(tt.plugin, tt.channel, message); got != tt.want {
+				t.Errorf("matchesChannel() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -26,6 +26,19 @@ type IRCPluginClient interface {
 	JoinChannel(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*Error, error)
 	LeaveChannel(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*Error, error)
 	ListChannel(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ChannelList, error)
+	Reload(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Error, error)
+	GetEvents(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (IRCPlugin_GetEventsClient, error)
+	SendPrivateMessage(ctx context.Context, in *PrivateMessage, opts ...grpc.CallOption) (*Error, error)
+	GetPrivateMessages(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPlugin_GetPrivateMessagesClient, error)
+	RegisterCommands(ctx context.Context, in *CommandRegistration, opts ...grpc.CallOption) (IRCPlugin_RegisterCommandsClient, error)
+	CheckPermission(ctx context.Context, in *PermissionCheck, opts ...grpc.CallOption) (*PermissionResult, error)
+	GetChannelUsers(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelUserList, error)
+	GetChannelTopic(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelTopic, error)
+	GetChannelModes(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelModes, error)
+	GetQueueDepth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*QueueDepth, error)
+	GetBuffered(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPlugin_GetBufferedClient, error)
+	Acknowledge(ctx context.Context, in *Acknowledgement, opts ...grpc.CallOption) (*Empty, error)
+	GetConnectionState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPlugin_GetConnectionStateClient, error)
 }
 
 type iRCPluginClient struct {
@@ -131,6 +144,238 @@ func (c *iRCPluginClient) ListChannel(ctx context.Context, in *Empty, opts ...gr
 	return out, nil
 }
 
+func (c *iRCPluginClient) Reload(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Error, error) {
+	out := new(Error)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/reload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetEvents(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (IRCPlugin_GetEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPlugin_ServiceDesc.Streams[1], "/rpc.IRCPlugin/getEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginGetEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPlugin_GetEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type iRCPluginGetEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginGetEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginClient) SendPrivateMessage(ctx context.Context, in *PrivateMessage, opts ...grpc.CallOption) (*Error, error) {
+	out := new(Error)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/sendPrivateMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetPrivateMessages(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPlugin_GetPrivateMessagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPlugin_ServiceDesc.Streams[2], "/rpc.IRCPlugin/getPrivateMessages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginGetPrivateMessagesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPlugin_GetPrivateMessagesClient interface {
+	Recv() (*PrivateMessage, error)
+	grpc.ClientStream
+}
+
+type iRCPluginGetPrivateMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginGetPrivateMessagesClient) Recv() (*PrivateMessage, error) {
+	m := new(PrivateMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginClient) RegisterCommands(ctx context.Context, in *CommandRegistration, opts ...grpc.CallOption) (IRCPlugin_RegisterCommandsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPlugin_ServiceDesc.Streams[3], "/rpc.IRCPlugin/registerCommands", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginRegisterCommandsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPlugin_RegisterCommandsClient interface {
+	Recv() (*CommandInvocation, error)
+	grpc.ClientStream
+}
+
+type iRCPluginRegisterCommandsClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginRegisterCommandsClient) Recv() (*CommandInvocation, error) {
+	m := new(CommandInvocation)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginClient) CheckPermission(ctx context.Context, in *PermissionCheck, opts ...grpc.CallOption) (*PermissionResult, error) {
+	out := new(PermissionResult)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/checkPermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetChannelUsers(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelUserList, error) {
+	out := new(ChannelUserList)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/getChannelUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetChannelTopic(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelTopic, error) {
+	out := new(ChannelTopic)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/getChannelTopic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetChannelModes(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelModes, error) {
+	out := new(ChannelModes)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/getChannelModes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetQueueDepth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*QueueDepth, error) {
+	out := new(QueueDepth)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/getQueueDepth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetBuffered(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPlugin_GetBufferedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPlugin_ServiceDesc.Streams[4], "/rpc.IRCPlugin/getBuffered", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginGetBufferedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPlugin_GetBufferedClient interface {
+	Recv() (*BufferedItem, error)
+	grpc.ClientStream
+}
+
+type iRCPluginGetBufferedClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginGetBufferedClient) Recv() (*BufferedItem, error) {
+	m := new(BufferedItem)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginClient) Acknowledge(ctx context.Context, in *Acknowledgement, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPlugin/acknowledge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginClient) GetConnectionState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPlugin_GetConnectionStateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPlugin_ServiceDesc.Streams[5], "/rpc.IRCPlugin/getConnectionState", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginGetConnectionStateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPlugin_GetConnectionStateClient interface {
+	Recv() (*ConnectionState, error)
+	grpc.ClientStream
+}
+
+type iRCPluginGetConnectionStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginGetConnectionStateClient) Recv() (*ConnectionState, error) {
+	m := new(ConnectionState)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // IRCPluginServer is the server API for IRCPlugin service.
 // All implementations must embed UnimplementedIRCPluginServer
 // for forward compatibility
@@ -143,6 +388,19 @@ type IRCPluginServer interface {
 	JoinChannel(context.Context, *Channel) (*Error, error)
 	LeaveChannel(context.Context, *Channel) (*Error, error)
 	ListChannel(context.Context, *Empty) (*ChannelList, error)
+	Reload(context.Context, *Empty) (*Error, error)
+	GetEvents(*EventFilter, IRCPlugin_GetEventsServer) error
+	SendPrivateMessage(context.Context, *PrivateMessage) (*Error, error)
+	GetPrivateMessages(*Empty, IRCPlugin_GetPrivateMessagesServer) error
+	RegisterCommands(*CommandRegistration, IRCPlugin_RegisterCommandsServer) error
+	CheckPermission(context.Context, *PermissionCheck) (*PermissionResult, error)
+	GetChannelUsers(context.Context, *Channel) (*ChannelUserList, error)
+	GetChannelTopic(context.Context, *Channel) (*ChannelTopic, error)
+	GetChannelModes(context.Context, *Channel) (*ChannelModes, error)
+	GetQueueDepth(context.Context, *Empty) (*QueueDepth, error)
+	GetBuffered(*Empty, IRCPlugin_GetBufferedServer) error
+	Acknowledge(context.Context, *Acknowledgement) (*Empty, error)
+	GetConnectionState(*Empty, IRCPlugin_GetConnectionStateServer) error
 	mustEmbedUnimplementedIRCPluginServer()
 }
 
@@ -174,6 +432,45 @@ func (UnimplementedIRCPluginServer) LeaveChannel(context.Context, *Channel) (*Er
 func (UnimplementedIRCPluginServer) ListChannel(context.Context, *Empty) (*ChannelList, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListChannel not implemented")
 }
+func (UnimplementedIRCPluginServer) Reload(context.Context, *Empty) (*Error, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reload not implemented")
+}
+func (UnimplementedIRCPluginServer) GetEvents(*EventFilter, IRCPlugin_GetEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetEvents not implemented")
+}
+func (UnimplementedIRCPluginServer) SendPrivateMessage(context.Context, *PrivateMessage) (*Error, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendPrivateMessage not implemented")
+}
+func (UnimplementedIRCPluginServer) GetPrivateMessages(*Empty, IRCPlugin_GetPrivateMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetPrivateMessages not implemented")
+}
+func (UnimplementedIRCPluginServer) RegisterCommands(*CommandRegistration, IRCPlugin_RegisterCommandsServer) error {
+	return status.Errorf(codes.Unimplemented, "method RegisterCommands not implemented")
+}
+func (UnimplementedIRCPluginServer) CheckPermission(context.Context, *PermissionCheck) (*PermissionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPermission not implemented")
+}
+func (UnimplementedIRCPluginServer) GetChannelUsers(context.Context, *Channel) (*ChannelUserList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelUsers not implemented")
+}
+func (UnimplementedIRCPluginServer) GetChannelTopic(context.Context, *Channel) (*ChannelTopic, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelTopic not implemented")
+}
+func (UnimplementedIRCPluginServer) GetChannelModes(context.Context, *Channel) (*ChannelModes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelModes not implemented")
+}
+func (UnimplementedIRCPluginServer) GetQueueDepth(context.Context, *Empty) (*QueueDepth, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQueueDepth not implemented")
+}
+func (UnimplementedIRCPluginServer) GetBuffered(*Empty, IRCPlugin_GetBufferedServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBuffered not implemented")
+}
+func (UnimplementedIRCPluginServer) Acknowledge(context.Context, *Acknowledgement) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Acknowledge not implemented")
+}
+func (UnimplementedIRCPluginServer) GetConnectionState(*Empty, IRCPlugin_GetConnectionStateServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetConnectionState not implemented")
+}
 func (UnimplementedIRCPluginServer) mustEmbedUnimplementedIRCPluginServer() {}
 
 // UnsafeIRCPluginServer may be embedded to opt out of forward compatibility for this service.
@@ -334,46 +631,1321 @@ func _IRCPlugin_ListChannel_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
-// IRCPlugin_ServiceDesc is the grpc.ServiceDesc for IRCPlugin service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var IRCPlugin_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "rpc.IRCPlugin",
-	HandlerType: (*IRCPluginServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "ping",
-			Handler:    _IRCPlugin_Ping_Handler,
-		},
-		{
-			MethodName: "sendChannelMessage",
-			Handler:    _IRCPlugin_SendChannelMessage_Handler,
-		},
-		{
-			MethodName: "sendRelayMessage",
-			Handler:    _IRCPlugin_SendRelayMessage_Handler,
-		},
-		{
-			MethodName: "sendRawMessage",
-			Handler:    _IRCPlugin_SendRawMessage_Handler,
-		},
-		{
-			MethodName: "joinChannel",
-			Handler:    _IRCPlugin_JoinChannel_Handler,
-		},
-		{
-			MethodName: "leaveChannel",
-			Handler:    _IRCPlugin_LeaveChannel_Handler,
-		},
-		{
-			MethodName: "listChannel",
-			Handler:    _IRCPlugin_ListChannel_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "getMessages",
-			Handler:       _IRCPlugin_GetMessages_Handler,
+func _IRCPlugin_Reload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).Reload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/reload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).Reload(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginServer).GetEvents(m, &iRCPluginGetEventsServer{stream})
+}
+
+type IRCPlugin_GetEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type iRCPluginGetEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginGetEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPlugin_SendPrivateMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrivateMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).SendPrivateMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/sendPrivateMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).SendPrivateMessage(ctx, req.(*PrivateMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetPrivateMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginServer).GetPrivateMessages(m, &iRCPluginGetPrivateMessagesServer{stream})
+}
+
+type IRCPlugin_GetPrivateMessagesServer interface {
+	Send(*PrivateMessage) error
+	grpc.ServerStream
+}
+
+type iRCPluginGetPrivateMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginGetPrivateMessagesServer) Send(m *PrivateMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPlugin_RegisterCommands_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CommandRegistration)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginServer).RegisterCommands(m, &iRCPluginRegisterCommandsServer{stream})
+}
+
+type IRCPlugin_RegisterCommandsServer interface {
+	Send(*CommandInvocation) error
+	grpc.ServerStream
+}
+
+type iRCPluginRegisterCommandsServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginRegisterCommandsServer) Send(m *CommandInvocation) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPlugin_CheckPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PermissionCheck)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).CheckPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/checkPermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).CheckPermission(ctx, req.(*PermissionCheck))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetChannelUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).GetChannelUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/getChannelUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).GetChannelUsers(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetChannelTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).GetChannelTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/getChannelTopic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).GetChannelTopic(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetChannelModes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).GetChannelModes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/getChannelModes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).GetChannelModes(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetQueueDepth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).GetQueueDepth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/getQueueDepth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).GetQueueDepth(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetBuffered_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginServer).GetBuffered(m, &iRCPluginGetBufferedServer{stream})
+}
+
+type IRCPlugin_GetBufferedServer interface {
+	Send(*BufferedItem) error
+	grpc.ServerStream
+}
+
+type iRCPluginGetBufferedServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginGetBufferedServer) Send(m *BufferedItem) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPlugin_Acknowledge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Acknowledgement)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginServer).Acknowledge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPlugin/acknowledge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginServer).Acknowledge(ctx, req.(*Acknowledgement))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPlugin_GetConnectionState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginServer).GetConnectionState(m, &iRCPluginGetConnectionStateServer{stream})
+}
+
+type IRCPlugin_GetConnectionStateServer interface {
+	Send(*ConnectionState) error
+	grpc.ServerStream
+}
+
+type iRCPluginGetConnectionStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginGetConnectionStateServer) Send(m *ConnectionState) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// IRCPlugin_ServiceDesc is the grpc.ServiceDesc for IRCPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IRCPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.IRCPlugin",
+	HandlerType: (*IRCPluginServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ping",
+			Handler:    _IRCPlugin_Ping_Handler,
+		},
+		{
+			MethodName: "sendChannelMessage",
+			Handler:    _IRCPlugin_SendChannelMessage_Handler,
+		},
+		{
+			MethodName: "sendRelayMessage",
+			Handler:    _IRCPlugin_SendRelayMessage_Handler,
+		},
+		{
+			MethodName: "sendRawMessage",
+			Handler:    _IRCPlugin_SendRawMessage_Handler,
+		},
+		{
+			MethodName: "joinChannel",
+			Handler:    _IRCPlugin_JoinChannel_Handler,
+		},
+		{
+			MethodName: "leaveChannel",
+			Handler:    _IRCPlugin_LeaveChannel_Handler,
+		},
+		{
+			MethodName: "listChannel",
+			Handler:    _IRCPlugin_ListChannel_Handler,
+		},
+		{
+			MethodName: "reload",
+			Handler:    _IRCPlugin_Reload_Handler,
+		},
+		{
+			MethodName: "sendPrivateMessage",
+			Handler:    _IRCPlugin_SendPrivateMessage_Handler,
+		},
+		{
+			MethodName: "checkPermission",
+			Handler:    _IRCPlugin_CheckPermission_Handler,
+		},
+		{
+			MethodName: "getChannelUsers",
+			Handler:    _IRCPlugin_GetChannelUsers_Handler,
+		},
+		{
+			MethodName: "getChannelTopic",
+			Handler:    _IRCPlugin_GetChannelTopic_Handler,
+		},
+		{
+			MethodName: "getChannelModes",
+			Handler:    _IRCPlugin_GetChannelModes_Handler,
+		},
+		{
+			MethodName: "getQueueDepth",
+			Handler:    _IRCPlugin_GetQueueDepth_Handler,
+		},
+		{
+			MethodName: "acknowledge",
+			Handler:    _IRCPlugin_Acknowledge_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "getMessages",
+			Handler:       _IRCPlugin_GetMessages_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getEvents",
+			Handler:       _IRCPlugin_GetEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getPrivateMessages",
+			Handler:       _IRCPlugin_GetPrivateMessages_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "registerCommands",
+			Handler:       _IRCPlugin_RegisterCommands_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getBuffered",
+			Handler:       _IRCPlugin_GetBuffered_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getConnectionState",
+			Handler:       _IRCPlugin_GetConnectionState_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "plugin.proto",
+}
+
+// IRCPluginV2Client is the client API for IRCPluginV2 service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type IRCPluginV2Client interface {
+	Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	SendChannelMessage(ctx context.Context, in *ChannelMessage, opts ...grpc.CallOption) (*Delivery, error)
+	SendRelayMessage(ctx context.Context, in *RelayMessage, opts ...grpc.CallOption) (*Delivery, error)
+	SendRawMessage(ctx context.Context, in *RawMessage, opts ...grpc.CallOption) (*Empty, error)
+	GetMessages(ctx context.Context, in *Channel, opts ...grpc.CallOption) (IRCPluginV2_GetMessagesClient, error)
+	JoinChannel(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*Empty, error)
+	LeaveChannel(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*Empty, error)
+	ListChannel(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ChannelList, error)
+	Reload(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error)
+	GetEvents(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (IRCPluginV2_GetEventsClient, error)
+	SendPrivateMessage(ctx context.Context, in *PrivateMessage, opts ...grpc.CallOption) (*Delivery, error)
+	GetPrivateMessages(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPluginV2_GetPrivateMessagesClient, error)
+	RegisterCommands(ctx context.Context, in *CommandRegistration, opts ...grpc.CallOption) (IRCPluginV2_RegisterCommandsClient, error)
+	CheckPermission(ctx context.Context, in *PermissionCheck, opts ...grpc.CallOption) (*PermissionResult, error)
+	GetChannelUsers(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelUserList, error)
+	GetChannelTopic(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelTopic, error)
+	GetChannelModes(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelModes, error)
+	GetQueueDepth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*QueueDepth, error)
+	GetBuffered(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPluginV2_GetBufferedClient, error)
+	Acknowledge(ctx context.Context, in *Acknowledgement, opts ...grpc.CallOption) (*Empty, error)
+	GetConnectionState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPluginV2_GetConnectionStateClient, error)
+}
+
+type iRCPluginV2Client struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIRCPluginV2Client(cc grpc.ClientConnInterface) IRCPluginV2Client {
+	return &iRCPluginV2Client{cc}
+}
+
+func (c *iRCPluginV2Client) Ping(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/ping", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) SendChannelMessage(ctx context.Context, in *ChannelMessage, opts ...grpc.CallOption) (*Delivery, error) {
+	out := new(Delivery)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/sendChannelMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) SendRelayMessage(ctx context.Context, in *RelayMessage, opts ...grpc.CallOption) (*Delivery, error) {
+	out := new(Delivery)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/sendRelayMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) SendRawMessage(ctx context.Context, in *RawMessage, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/sendRawMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetMessages(ctx context.Context, in *Channel, opts ...grpc.CallOption) (IRCPluginV2_GetMessagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPluginV2_ServiceDesc.Streams[0], "/rpc.IRCPluginV2/getMessages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginV2GetMessagesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPluginV2_GetMessagesClient interface {
+	Recv() (*ChannelMessage, error)
+	grpc.ClientStream
+}
+
+type iRCPluginV2GetMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginV2GetMessagesClient) Recv() (*ChannelMessage, error) {
+	m := new(ChannelMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginV2Client) JoinChannel(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/joinChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) LeaveChannel(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/leaveChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) ListChannel(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*ChannelList, error) {
+	out := new(ChannelList)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/listChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) Reload(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/reload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetEvents(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (IRCPluginV2_GetEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPluginV2_ServiceDesc.Streams[1], "/rpc.IRCPluginV2/getEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginV2GetEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPluginV2_GetEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type iRCPluginV2GetEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginV2GetEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginV2Client) SendPrivateMessage(ctx context.Context, in *PrivateMessage, opts ...grpc.CallOption) (*Delivery, error) {
+	out := new(Delivery)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/sendPrivateMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetPrivateMessages(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPluginV2_GetPrivateMessagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPluginV2_ServiceDesc.Streams[2], "/rpc.IRCPluginV2/getPrivateMessages", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginV2GetPrivateMessagesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPluginV2_GetPrivateMessagesClient interface {
+	Recv() (*PrivateMessage, error)
+	grpc.ClientStream
+}
+
+type iRCPluginV2GetPrivateMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginV2GetPrivateMessagesClient) Recv() (*PrivateMessage, error) {
+	m := new(PrivateMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginV2Client) RegisterCommands(ctx context.Context, in *CommandRegistration, opts ...grpc.CallOption) (IRCPluginV2_RegisterCommandsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPluginV2_ServiceDesc.Streams[3], "/rpc.IRCPluginV2/registerCommands", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginV2RegisterCommandsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPluginV2_RegisterCommandsClient interface {
+	Recv() (*CommandInvocation, error)
+	grpc.ClientStream
+}
+
+type iRCPluginV2RegisterCommandsClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginV2RegisterCommandsClient) Recv() (*CommandInvocation, error) {
+	m := new(CommandInvocation)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginV2Client) CheckPermission(ctx context.Context, in *PermissionCheck, opts ...grpc.CallOption) (*PermissionResult, error) {
+	out := new(PermissionResult)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/checkPermission", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetChannelUsers(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelUserList, error) {
+	out := new(ChannelUserList)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/getChannelUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetChannelTopic(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelTopic, error) {
+	out := new(ChannelTopic)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/getChannelTopic", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetChannelModes(ctx context.Context, in *Channel, opts ...grpc.CallOption) (*ChannelModes, error) {
+	out := new(ChannelModes)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/getChannelModes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetQueueDepth(ctx context.Context, in *Empty, opts ...grpc.CallOption) (*QueueDepth, error) {
+	out := new(QueueDepth)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/getQueueDepth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetBuffered(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPluginV2_GetBufferedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPluginV2_ServiceDesc.Streams[4], "/rpc.IRCPluginV2/getBuffered", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginV2GetBufferedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPluginV2_GetBufferedClient interface {
+	Recv() (*BufferedItem, error)
+	grpc.ClientStream
+}
+
+type iRCPluginV2GetBufferedClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginV2GetBufferedClient) Recv() (*BufferedItem, error) {
+	m := new(BufferedItem)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *iRCPluginV2Client) Acknowledge(ctx context.Context, in *Acknowledgement, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, "/rpc.IRCPluginV2/acknowledge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *iRCPluginV2Client) GetConnectionState(ctx context.Context, in *Empty, opts ...grpc.CallOption) (IRCPluginV2_GetConnectionStateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &IRCPluginV2_ServiceDesc.Streams[5], "/rpc.IRCPluginV2/getConnectionState", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &iRCPluginV2GetConnectionStateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type IRCPluginV2_GetConnectionStateClient interface {
+	Recv() (*ConnectionState, error)
+	grpc.ClientStream
+}
+
+type iRCPluginV2GetConnectionStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *iRCPluginV2GetConnectionStateClient) Recv() (*ConnectionState, error) {
+	m := new(ConnectionState)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// IRCPluginV2Server is the server API for IRCPluginV2 service.
+// All implementations must embed UnimplementedIRCPluginV2Server
+// for forward compatibility
+type IRCPluginV2Server interface {
+	Ping(context.Context, *Empty) (*Empty, error)
+	SendChannelMessage(context.Context, *ChannelMessage) (*Delivery, error)
+	SendRelayMessage(context.Context, *RelayMessage) (*Delivery, error)
+	SendRawMessage(context.Context, *RawMessage) (*Empty, error)
+	GetMessages(*Channel, IRCPluginV2_GetMessagesServer) error
+	JoinChannel(context.Context, *Channel) (*Empty, error)
+	LeaveChannel(context.Context, *Channel) (*Empty, error)
+	ListChannel(context.Context, *Empty) (*ChannelList, error)
+	Reload(context.Context, *Empty) (*Empty, error)
+	GetEvents(*EventFilter, IRCPluginV2_GetEventsServer) error
+	SendPrivateMessage(context.Context, *PrivateMessage) (*Delivery, error)
+	GetPrivateMessages(*Empty, IRCPluginV2_GetPrivateMessagesServer) error
+	RegisterCommands(*CommandRegistration, IRCPluginV2_RegisterCommandsServer) error
+	CheckPermission(context.Context, *PermissionCheck) (*PermissionResult, error)
+	GetChannelUsers(context.Context, *Channel) (*ChannelUserList, error)
+	GetChannelTopic(context.Context, *Channel) (*ChannelTopic, error)
+	GetChannelModes(context.Context, *Channel) (*ChannelModes, error)
+	GetQueueDepth(context.Context, *Empty) (*QueueDepth, error)
+	GetBuffered(*Empty, IRCPluginV2_GetBufferedServer) error
+	Acknowledge(context.Context, *Acknowledgement) (*Empty, error)
+	GetConnectionState(*Empty, IRCPluginV2_GetConnectionStateServer) error
+	mustEmbedUnimplementedIRCPluginV2Server()
+}
+
+// UnimplementedIRCPluginV2Server must be embedded to have forward compatible implementations.
+type UnimplementedIRCPluginV2Server struct {
+}
+
+func (UnimplementedIRCPluginV2Server) Ping(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
+func (UnimplementedIRCPluginV2Server) SendChannelMessage(context.Context, *ChannelMessage) (*Delivery, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendChannelMessage not implemented")
+}
+func (UnimplementedIRCPluginV2Server) SendRelayMessage(context.Context, *RelayMessage) (*Delivery, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendRelayMessage not implemented")
+}
+func (UnimplementedIRCPluginV2Server) SendRawMessage(context.Context, *RawMessage) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendRawMessage not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetMessages(*Channel, IRCPluginV2_GetMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetMessages not implemented")
+}
+func (UnimplementedIRCPluginV2Server) JoinChannel(context.Context, *Channel) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method JoinChannel not implemented")
+}
+func (UnimplementedIRCPluginV2Server) LeaveChannel(context.Context, *Channel) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaveChannel not implemented")
+}
+func (UnimplementedIRCPluginV2Server) ListChannel(context.Context, *Empty) (*ChannelList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListChannel not implemented")
+}
+func (UnimplementedIRCPluginV2Server) Reload(context.Context, *Empty) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reload not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetEvents(*EventFilter, IRCPluginV2_GetEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetEvents not implemented")
+}
+func (UnimplementedIRCPluginV2Server) SendPrivateMessage(context.Context, *PrivateMessage) (*Delivery, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendPrivateMessage not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetPrivateMessages(*Empty, IRCPluginV2_GetPrivateMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetPrivateMessages not implemented")
+}
+func (UnimplementedIRCPluginV2Server) RegisterCommands(*CommandRegistration, IRCPluginV2_RegisterCommandsServer) error {
+	return status.Errorf(codes.Unimplemented, "method RegisterCommands not implemented")
+}
+func (UnimplementedIRCPluginV2Server) CheckPermission(context.Context, *PermissionCheck) (*PermissionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckPermission not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetChannelUsers(context.Context, *Channel) (*ChannelUserList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelUsers not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetChannelTopic(context.Context, *Channel) (*ChannelTopic, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelTopic not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetChannelModes(context.Context, *Channel) (*ChannelModes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannelModes not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetQueueDepth(context.Context, *Empty) (*QueueDepth, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQueueDepth not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetBuffered(*Empty, IRCPluginV2_GetBufferedServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBuffered not implemented")
+}
+func (UnimplementedIRCPluginV2Server) Acknowledge(context.Context, *Acknowledgement) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Acknowledge not implemented")
+}
+func (UnimplementedIRCPluginV2Server) GetConnectionState(*Empty, IRCPluginV2_GetConnectionStateServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetConnectionState not implemented")
+}
+func (UnimplementedIRCPluginV2Server) mustEmbedUnimplementedIRCPluginV2Server() {}
+
+// UnsafeIRCPluginV2Server may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IRCPluginV2Server will
+// result in compilation errors.
+type UnsafeIRCPluginV2Server interface {
+	mustEmbedUnimplementedIRCPluginV2Server()
+}
+
+func RegisterIRCPluginV2Server(s grpc.ServiceRegistrar, srv IRCPluginV2Server) {
+	s.RegisterService(&IRCPluginV2_ServiceDesc, srv)
+}
+
+func _IRCPluginV2_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/ping",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).Ping(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_SendChannelMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChannelMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).SendChannelMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/sendChannelMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).SendChannelMessage(ctx, req.(*ChannelMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_SendRelayMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RelayMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).SendRelayMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/sendRelayMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).SendRelayMessage(ctx, req.(*RelayMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_SendRawMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RawMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).SendRawMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/sendRawMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).SendRawMessage(ctx, req.(*RawMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Channel)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginV2Server).GetMessages(m, &iRCPluginV2GetMessagesServer{stream})
+}
+
+type IRCPluginV2_GetMessagesServer interface {
+	Send(*ChannelMessage) error
+	grpc.ServerStream
+}
+
+type iRCPluginV2GetMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginV2GetMessagesServer) Send(m *ChannelMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPluginV2_JoinChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).JoinChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/joinChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).JoinChannel(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_LeaveChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).LeaveChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/leaveChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).LeaveChannel(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_ListChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).ListChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/listChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).ListChannel(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_Reload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).Reload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/reload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).Reload(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginV2Server).GetEvents(m, &iRCPluginV2GetEventsServer{stream})
+}
+
+type IRCPluginV2_GetEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type iRCPluginV2GetEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginV2GetEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPluginV2_SendPrivateMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PrivateMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).SendPrivateMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/sendPrivateMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).SendPrivateMessage(ctx, req.(*PrivateMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetPrivateMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginV2Server).GetPrivateMessages(m, &iRCPluginV2GetPrivateMessagesServer{stream})
+}
+
+type IRCPluginV2_GetPrivateMessagesServer interface {
+	Send(*PrivateMessage) error
+	grpc.ServerStream
+}
+
+type iRCPluginV2GetPrivateMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginV2GetPrivateMessagesServer) Send(m *PrivateMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPluginV2_RegisterCommands_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(CommandRegistration)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginV2Server).RegisterCommands(m, &iRCPluginV2RegisterCommandsServer{stream})
+}
+
+type IRCPluginV2_RegisterCommandsServer interface {
+	Send(*CommandInvocation) error
+	grpc.ServerStream
+}
+
+type iRCPluginV2RegisterCommandsServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginV2RegisterCommandsServer) Send(m *CommandInvocation) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPluginV2_CheckPermission_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PermissionCheck)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).CheckPermission(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/checkPermission",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).CheckPermission(ctx, req.(*PermissionCheck))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetChannelUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).GetChannelUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/getChannelUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).GetChannelUsers(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetChannelTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).GetChannelTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/getChannelTopic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).GetChannelTopic(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetChannelModes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).GetChannelModes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/getChannelModes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).GetChannelModes(ctx, req.(*Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetQueueDepth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).GetQueueDepth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/getQueueDepth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).GetQueueDepth(ctx, req.(*Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetBuffered_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginV2Server).GetBuffered(m, &iRCPluginV2GetBufferedServer{stream})
+}
+
+type IRCPluginV2_GetBufferedServer interface {
+	Send(*BufferedItem) error
+	grpc.ServerStream
+}
+
+type iRCPluginV2GetBufferedServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginV2GetBufferedServer) Send(m *BufferedItem) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _IRCPluginV2_Acknowledge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Acknowledgement)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IRCPluginV2Server).Acknowledge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/rpc.IRCPluginV2/acknowledge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IRCPluginV2Server).Acknowledge(ctx, req.(*Acknowledgement))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _IRCPluginV2_GetConnectionState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(IRCPluginV2Server).GetConnectionState(m, &iRCPluginV2GetConnectionStateServer{stream})
+}
+
+type IRCPluginV2_GetConnectionStateServer interface {
+	Send(*ConnectionState) error
+	grpc.ServerStream
+}
+
+type iRCPluginV2GetConnectionStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *iRCPluginV2GetConnectionStateServer) Send(m *ConnectionState) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// IRCPluginV2_ServiceDesc is the grpc.ServiceDesc for IRCPluginV2 service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IRCPluginV2_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rpc.IRCPluginV2",
+	HandlerType: (*IRCPluginV2Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ping",
+			Handler:    _IRCPluginV2_Ping_Handler,
+		},
+		{
+			MethodName: "sendChannelMessage",
+			Handler:    _IRCPluginV2_SendChannelMessage_Handler,
+		},
+		{
+			MethodName: "sendRelayMessage",
+			Handler:    _IRCPluginV2_SendRelayMessage_Handler,
+		},
+		{
+			MethodName: "sendRawMessage",
+			Handler:    _IRCPluginV2_SendRawMessage_Handler,
+		},
+		{
+			MethodName: "joinChannel",
+			Handler:    _IRCPluginV2_JoinChannel_Handler,
+		},
+		{
+			MethodName: "leaveChannel",
+			Handler:    _IRCPluginV2_LeaveChannel_Handler,
+		},
+		{
+			MethodName: "listChannel",
+			Handler:    _IRCPluginV2_ListChannel_Handler,
+		},
+		{
+			MethodName: "reload",
+			Handler:    _IRCPluginV2_Reload_Handler,
+		},
+		{
+			MethodName: "sendPrivateMessage",
+			Handler:    _IRCPluginV2_SendPrivateMessage_Handler,
+		},
+		{
+			MethodName: "checkPermission",
+			Handler:    _IRCPluginV2_CheckPermission_Handler,
+		},
+		{
+			MethodName: "getChannelUsers",
+			Handler:    _IRCPluginV2_GetChannelUsers_Handler,
+		},
+		{
+			MethodName: "getChannelTopic",
+			Handler:    _IRCPluginV2_GetChannelTopic_Handler,
+		},
+		{
+			MethodName: "getChannelModes",
+			Handler:    _IRCPluginV2_GetChannelModes_Handler,
+		},
+		{
+			MethodName: "getQueueDepth",
+			Handler:    _IRCPluginV2_GetQueueDepth_Handler,
+		},
+		{
+			MethodName: "acknowledge",
+			Handler:    _IRCPluginV2_Acknowledge_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "getMessages",
+			Handler:       _IRCPluginV2_GetMessages_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getEvents",
+			Handler:       _IRCPluginV2_GetEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getPrivateMessages",
+			Handler:       _IRCPluginV2_GetPrivateMessages_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "registerCommands",
+			Handler:       _IRCPluginV2_RegisterCommands_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getBuffered",
+			Handler:       _IRCPluginV2_GetBuffered_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "getConnectionState",
+			Handler:       _IRCPluginV2_GetConnectionState_Handler,
 			ServerStreams: true,
 		},
 	},
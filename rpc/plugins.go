@@ -0,0 +1,124 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"sync"
+)
+
+// pluginList is the set of plugins allowed to connect, shared by the gRPC and HTTP servers so it can be replaced
+// while running
+type pluginList struct {
+	mutex    sync.RWMutex
+	plugins  []Plugin
+	streams  map[string]map[int]context.CancelFunc
+	streamID int
+}
+
+func newPluginList(plugins []Plugin) *pluginList {
+	return &pluginList{
+		plugins: plugins,
+		streams: make(map[string]map[int]context.CancelFunc),
+	}
+}
+
+// find returns a copy of the plugin with the given token, or nil if there isn't one
+func (l *pluginList) find(token string) *Plugin {
+	if len(token) == 0 {
+		return nil
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for index := range l.plugins {
+		if l.plugins[index].Token == token {
+			plugin := l.plugins[index]
+			return &plugin
+		}
+	}
+	return nil
+}
+
+// findIdentity returns a copy of the plugin with the given client certificate identity, or nil if there isn't one
+func (l *pluginList) findIdentity(identity string) *Plugin {
+	if len(identity) == 0 {
+		return nil
+	}
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for index := range l.plugins {
+		if l.plugins[index].Identity == identity {
+			plugin := l.plugins[index]
+			return &plugin
+		}
+	}
+	return nil
+}
+
+// all returns a copy of the plugins
+func (l *pluginList) all() []Plugin {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return append([]Plugin(nil), l.plugins...)
+}
+
+// findUID returns a copy of the plugin allowed to connect to the Unix socket as the given user, or nil if there isn't
+// one
+func (l *pluginList) findUID(uid int) *Plugin {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	for index := range l.plugins {
+		if l.plugins[index].UID != nil && *l.plugins[index].UID == uid {
+			plugin := l.plugins[index]
+			return &plugin
+		}
+	}
+	return nil
+}
+
+// set replaces the plugins, cancelling the streams of any plugin whose token or identity is no longer valid or whose
+// permissions changed, so they reconnect with the new permissions.  It returns the names of the plugins that were
+// disconnected.
+func (l *pluginList) set(plugins []Plugin) (revoked []string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	credentials := make(map[string]Permissions)
+	for index := range plugins {
+		credentials[plugins[index].credential()] = plugins[index].Permissions
+	}
+	for index := range l.plugins {
+		credential := l.plugins[index].credential()
+		if permissions, ok := credentials[credential]; ok && reflect.DeepEqual(permissions, l.plugins[index].Permissions) {
+			continue
+		}
+		if len(l.streams[credential]) > 0 {
+			revoked = append(revoked, l.plugins[index].Name)
+		}
+		for _, cancel := range l.streams[credential] {
+			cancel()
+		}
+		delete(l.streams, credential)
+	}
+	l.plugins = plugins
+	return
+}
+
+// track records a stream opened by a plugin with the given credential so it can be cancelled if the credential is
+// revoked, the returned function must be called when the stream finishes
+func (l *pluginList) track(credential string, cancel context.CancelFunc) func() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.streamID++
+	id := l.streamID
+	if l.streams[credential] == nil {
+		l.streams[credential] = make(map[int]context.CancelFunc)
+	}
+	l.streams[credential][id] = cancel
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		delete(l.streams[credential], id)
+		if len(l.streams[credential]) == 0 {
+			delete(l.streams, credential)
+		}
+	}
+}
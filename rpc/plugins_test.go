@@ -0,0 +1,46 @@
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_pluginList_set(t *testing.T) {
+	list := newPluginList([]Plugin{
+		{Name: "webhook", Token: "abc"},
+		{Name: "github", Token: "def"},
+		{Name: "deploy", Token: "jkl", Permissions: Permissions{ScopeRead: {"#ops"}}},
+	})
+	deployCtx, deployCancel := context.WithCancel(context.Background())
+	defer list.track(list.find("jkl").credential(), deployCancel)()
+	webhookCtx, webhookCancel := context.WithCancel(context.Background())
+	defer list.track(list.find("abc").credential(), webhookCancel)()
+	githubCtx, githubCancel := context.WithCancel(context.Background())
+	defer list.track(list.find("def").credential(), githubCancel)()
+
+	revoked := list.set([]Plugin{
+		{Name: "webhook", Token: "abc", Permissions: Permissions{ScopeSend: nil}},
+		{Name: "github", Token: "ghi"},
+		{Name: "deploy", Token: "jkl", Permissions: Permissions{ScopeRead: {"#ops"}}},
+	})
+
+	if !reflect.DeepEqual(revoked, []string{"webhook", "github"}) {
+		t.Errorf("set() revoked = %v, want [webhook github]", revoked)
+	}
+	if deployCtx.Err() != nil {
+		t.Errorf("set() cancelled stream for unchanged plugin")
+	}
+	if webhookCtx.Err() == nil {
+		t.Errorf("set() did not cancel stream for changed permissions")
+	}
+	if githubCtx.Err() == nil {
+		t.Errorf("set() did not cancel stream for revoked token")
+	}
+	if list.find("def") != nil {
+		t.Errorf("find() returned plugin for revoked token")
+	}
+	if plugin := list.find("abc"); plugin == nil || plugin.HasScope(ScopeRaw) {
+		t.Errorf("find() = %#+v, want updated permissions", plugin)
+	}
+}
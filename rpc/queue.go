@@ -0,0 +1,18 @@
+package rpc
+
+import (
+	"context"
+)
+
+func (ps *pluginServer) GetQueueDepth(ctx context.Context, _ *Empty) (*QueueDepth, error) {
+	owner := ""
+	if plugin, ok := PluginFromContext(ctx); ok {
+		owner = plugin.Name
+	}
+	owned, total := ps.sender.QueueDepth(owner)
+	return &QueueDepth{
+		Plugin:        int32(owned),
+		Total:         int32(total),
+		DroppedEvents: ps.bus.droppedEvents(owner),
+	}, nil
+}
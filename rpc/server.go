@@ -3,7 +3,12 @@ package rpc
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
 
 	"github.com/greboid/irc-bot/v5/bot"
 	"github.com/greboid/irc-bot/v5/irc"
@@ -11,73 +16,313 @@ import (
 	grpcauth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-func NewGrpcServer(rpcPort int, pluginString string, webPort int, logger irc.Logger) (*GrpcServer, error) {
-	plugins, err := ParsePluginString(pluginString)
-	if err != nil {
-		return nil, err
-	}
+func NewGrpcServer(rpcPort int, plugins []Plugin, webPort int, logger irc.Logger) (*GrpcServer, error) {
 	return &GrpcServer{
-		rpcPort: rpcPort,
-		plugins: plugins,
-		webPort: webPort,
-		logger:  logger,
+		rpcPort:  rpcPort,
+		plugins:  newPluginList(plugins),
+		buffers:  newBufferRegistry(logger),
+		bus:      newEventBus(),
+		stopping: make(chan struct{}),
+		webPort:  webPort,
+		logger:   logger,
 	}, nil
 }
 
 type GrpcServer struct {
-	rpcPort int
-	plugins []Plugin
-	webPort int
-	logger  irc.Logger
+	rpcPort     int
+	plugins     *pluginList
+	buffers     *bufferRegistry
+	bus         *eventBus
+	webPort     int
+	logger      irc.Logger
+	reload      func() error
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	bindAddress string
+	socket      string
+	socketMode  os.FileMode
+	mutex       sync.Mutex
+	stopping    chan struct{}
+	http        *httpServer
+	servers     []*grpc.Server
+	fold        func(string) string
 }
 
-func (s *GrpcServer) StartGRPC(bot *bot.Bot) {
-	certificate, err := generateSelfSignedCert()
+// SetPlugins replaces the plugins allowed to connect, disconnecting any plugin whose token is no longer valid
+func (s *GrpcServer) SetPlugins(plugins []Plugin) {
+	for _, name := range s.plugins.set(plugins) {
+		s.logger.Infof("Disconnected plugin with revoked credentials or changed permissions: %s", name)
+	}
+	if err := s.buffers.set(plugins); err != nil {
+		s.logger.Errorf("Unable to load plugin buffers: %s", err)
+	}
+}
+
+// SetEventBuffer sets how many messages each plugin stream may have waiting, and what happens when a stream falls so
+// far behind that they don't fit.  It applies to streams opened after it is called.
+func (s *GrpcServer) SetEventBuffer(size int, policy OverflowPolicy) {
+	s.bus.setLimits(size, policy)
+}
+
+// SetBufferDir sets the directory plugin buffers are kept in and loads the buffers of the current plugins, buffering
+// is disabled until it is set
+func (s *GrpcServer) SetBufferDir(dir string) error {
+	if err := s.buffers.setDir(dir); err != nil {
+		return err
+	}
+	return s.buffers.set(s.plugins.all())
+}
+
+// SetCertificate sets the certificate and key the RPC server uses, generating a self-signed certificate and saving it
+// to the files if neither exists.  If it isn't called a new certificate is generated every time the server starts.
+func (s *GrpcServer) SetCertificate(certFile string, keyFile string) error {
+	certificate, err := loadCertificate(certFile, keyFile)
 	if err != nil {
-		s.logger.Fatalf("failed to generate certificate: %s", err.Error())
-		return
+		return err
 	}
-	s.logger.Infof("Starting RPC server: %d", s.rpcPort)
-	lis, err := tls.Listen("tcp", fmt.Sprintf(":%d", s.rpcPort), &tls.Config{Certificates: []tls.Certificate{*certificate}})
+	s.certificate = certificate
+	return nil
+}
+
+// SetClientCA sets the certificate authorities trusted to sign plugins' client certificates.  Plugins presenting a
+// certificate signed by one of them are authenticated as the plugin with the certificate's identity, without a token.
+func (s *GrpcServer) SetClientCA(file string) error {
+	pool, err := loadCertPool(file)
 	if err != nil {
-		s.logger.Fatalf("failed to listen: %v", err)
-		return
+		return err
 	}
-	grpcServer := grpc.NewServer(
-		grpc.StreamInterceptor(grpcmiddleware.ChainStreamServer(grpcauth.StreamServerInterceptor(s.authPlugin))),
-		grpc.UnaryInterceptor(grpcmiddleware.ChainUnaryServer(grpcauth.UnaryServerInterceptor(s.authPlugin))),
-	)
+	s.clientCAs = pool
+	return nil
+}
+
+// SetBindAddress sets the address the RPC server listens on, by default it listens on every interface
+func (s *GrpcServer) SetBindAddress(address string) {
+	s.bindAddress = address
+}
+
+// SetSocket makes the RPC server also listen on a Unix socket at the given path with the given file mode.  On Linux
+// plugins connecting to it are authenticated as the plugin with their process's UID, without a token.
+func (s *GrpcServer) SetSocket(path string, mode os.FileMode) {
+	s.socket = path
+	s.socketMode = mode
+}
+
+// tlsConfig returns the TLS config for the RPC server, generating a certificate if one hasn't been set
+func (s *GrpcServer) tlsConfig() (*tls.Config, error) {
+	certificate := s.certificate
+	if certificate == nil {
+		certPEM, keyPEM, err := generateSelfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		generated, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		certificate = &generated
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{*certificate}}
+	if s.clientCAs != nil {
+		config.ClientCAs = s.clientCAs
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// SetReloadHandler sets the function called when a plugin requests the configuration be reloaded
+func (s *GrpcServer) SetReloadHandler(reload func() error) {
+	s.reload = reload
+}
+
+// StartGRPC serves plugins on the RPC port, unless it is zero, and the Unix socket if one is set, returning when
+// they stop
+func (s *GrpcServer) StartGRPC(bot *bot.Bot) {
 	httpsServer := NewHttpServer(s.webPort, s.plugins, s.logger)
-	RegisterIRCPluginServer(grpcServer, &pluginServer{bot.Connection, bot})
-	RegisterHTTPPluginServer(grpcServer, httpsServer)
+	httpsServer.buffers = s.buffers
+	httpsServer.stopping = s.stopping
+	plugins := &pluginServer{
+		sender:    bot.Connection,
+		functions: bot,
+		reload:    s.reload,
+		buffers:   s.buffers,
+		bus:       s.bus,
+		stopping:  s.stopping,
+	}
+	s.fold = bot.Fold
+	s.bus.start(bot)
+	s.buffers.start(plugins)
+	newServer := func(creds credentials.TransportCredentials) *grpc.Server {
+		grpcServer := s.newServer(creds)
+		RegisterIRCPluginServer(grpcServer, plugins)
+		RegisterIRCPluginV2Server(grpcServer, &pluginServerV2{pluginServer: plugins})
+		RegisterHTTPPluginServer(grpcServer, httpsServer)
+		return grpcServer
+	}
+	servers := map[net.Listener]*grpc.Server{}
+	if s.rpcPort > 0 {
+		config, err := s.tlsConfig()
+		if err != nil {
+			s.logger.Fatalf("failed to generate certificate: %s", err.Error())
+			return
+		}
+		s.logger.Infof("RPC certificate fingerprint: %s", irc.CertificateFingerprint(config.Certificates[0].Certificate[0]))
+		s.logger.Infof("Starting RPC server: %d", s.rpcPort)
+		lis, err := net.Listen("tcp", net.JoinHostPort(s.bindAddress, strconv.Itoa(s.rpcPort)))
+		if err != nil {
+			s.logger.Fatalf("failed to listen: %v", err)
+			return
+		}
+		servers[lis] = newServer(credentials.NewTLS(config))
+	}
+	if len(s.socket) > 0 {
+		s.logger.Infof("Starting RPC server: %s", s.socket)
+		lis, err := listenSocket(s.socket, s.socketMode)
+		if err != nil {
+			s.logger.Fatalf("failed to listen: %v", err)
+			return
+		}
+		servers[lis] = newServer(unixCredentials{})
+	}
+	s.mutex.Lock()
+	select {
+	case <-s.stopping:
+		s.mutex.Unlock()
+		for lis := range servers {
+			_ = lis.Close()
+		}
+		return
+	default:
+	}
+	s.http = httpsServer
+	for _, grpcServer := range servers {
+		s.servers = append(s.servers, grpcServer)
+	}
 	s.logger.Infof("Starting HTTP Server: %d", s.webPort)
 	httpsServer.Start()
-	err = grpcServer.Serve(lis)
-	if err != nil {
-		s.logger.Errorf("Error listening: %s", err.Error())
-		return
+	s.mutex.Unlock()
+	wg := sync.WaitGroup{}
+	for lis, grpcServer := range servers {
+		wg.Add(1)
+		go func(lis net.Listener, grpcServer *grpc.Server) {
+			defer wg.Done()
+			if err := grpcServer.Serve(lis); err != nil {
+				s.logger.Errorf("Error listening: %s", err.Error())
+			}
+		}(lis, grpcServer)
 	}
+	wg.Wait()
+}
+
+// Shutdown waits for webhooks in flight to be answered, tells plugins the bot is shutting down and closes their
+// streams, then stops the RPC servers once their calls have finished and writes the plugins' buffers.  Anything still
+// running when the context is done is stopped immediately.
+func (s *GrpcServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	select {
+	case <-s.stopping:
+		return nil
+	default:
+	}
+	var err error
+	if s.http != nil {
+		if httpErr := s.http.Shutdown(ctx); httpErr != nil {
+			err = fmt.Errorf("http: %w", httpErr)
+		}
+	}
+	close(s.stopping)
+	stopped := make(chan struct{})
+	go func() {
+		for _, grpcServer := range s.servers {
+			grpcServer.GracefulStop()
+		}
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		for _, grpcServer := range s.servers {
+			grpcServer.Stop()
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	if bufferErr := s.buffers.flush(); bufferErr != nil && err == nil {
+		err = fmt.Errorf("buffers: %w", bufferErr)
+	}
+	return err
+}
+
+// newServer returns a gRPC server that authenticates and authorises plugins
+func (s *GrpcServer) newServer(creds credentials.TransportCredentials) *grpc.Server {
+	return grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.StreamInterceptor(grpcmiddleware.ChainStreamServer(
+			grpcauth.StreamServerInterceptor(s.authPlugin),
+			s.streamTargetInterceptor,
+		)),
+		grpc.UnaryInterceptor(grpcmiddleware.ChainUnaryServer(
+			grpcauth.UnaryServerInterceptor(s.authPlugin),
+			s.unaryTargetInterceptor,
+		)),
+	)
 }
 
 func (s *GrpcServer) authPlugin(ctx context.Context) (context.Context, error) {
+	if plugin := s.peerPlugin(ctx); plugin != nil {
+		plugin.fold = s.fold
+		if err := authorise(ctx, plugin); err != nil {
+			return nil, err
+		}
+		return contextWithPlugin(ctx, plugin), nil
+	}
 	token, err := grpcauth.AuthFromMD(ctx, "bearer")
 	if err != nil {
 		return nil, status.Errorf(codes.Unauthenticated, "invalid auth token: %s", err.Error())
 	}
-	if !s.checkPlugin(token) {
+	plugin := s.checkPlugin(token)
+	if plugin == nil {
 		return nil, status.Errorf(codes.Unauthenticated, "access denied")
 	}
-	return ctx, nil
+	plugin.fold = s.fold
+	if err := authorise(ctx, plugin); err != nil {
+		return nil, err
+	}
+	return contextWithPlugin(ctx, plugin), nil
 }
 
-func (s *GrpcServer) checkPlugin(token string) bool {
-	for _, plugin := range s.plugins {
-		if plugin.Token == token {
-			return true
-		}
+func (s *GrpcServer) checkPlugin(token string) *Plugin {
+	return s.plugins.find(token)
+}
+
+// peerPlugin returns the plugin authenticated by its client certificate or the user it connected to the Unix socket
+// as, or nil if neither identifies a plugin
+func (s *GrpcServer) peerPlugin(ctx context.Context) *Plugin {
+	if plugin := s.plugins.findIdentity(peerIdentity(ctx)); plugin != nil {
+		return plugin
+	}
+	if uid, ok := peerUser(ctx); ok {
+		return s.plugins.findUID(uid)
+	}
+	return nil
+}
+
+// peerIdentity returns the common name of the plugin's client certificate, or an empty string if it didn't present
+// one that was verified
+func peerIdentity(ctx context.Context) string {
+	client, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := client.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
 	}
-	return false
+	return info.State.VerifiedChains[0][0].Subject.CommonName
 }
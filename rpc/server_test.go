@@ -0,0 +1,132 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/greboid/irc-bot/v5/bot"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// methodStream gives a context the method name the interceptors authorise against
+type methodStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (m *methodStream) Method() string {
+	return m.method
+}
+
+func Test_GrpcServer_authPlugin(t *testing.T) {
+	server := &GrpcServer{plugins: newPluginList([]Plugin{
+		{Name: "webhook", Token: "abc"},
+		{Name: "github", Identity: "github.plugins"},
+	})}
+	tests := []struct {
+		name     string
+		token    string
+		identity string
+		verified bool
+		want     string
+	}{
+		{name: "token", token: "abc", want: "webhook"},
+		{name: "verified certificate", identity: "github.plugins", verified: true, want: "github"},
+		{name: "certificate takes precedence", token: "abc", identity: "github.plugins", verified: true, want: "github"},
+		{name: "unverified certificate", identity: "github.plugins"},
+		{name: "unknown identity falls back to token", token: "abc", identity: "other", verified: true, want: "webhook"},
+		{name: "unknown identity", identity: "other", verified: true},
+		{name: "unknown token", token: "def"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), &methodStream{method: "/rpc.IRCPlugin/ping"})
+			if len(tt.token) > 0 {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "bearer "+tt.token))
+			}
+			if len(tt.identity) > 0 {
+				state := tls.ConnectionState{}
+				certificate := &x509.Certificate{Subject: pkix.Name{CommonName: tt.identity}}
+				if tt.verified {
+					state.VerifiedChains = [][]*x509.Certificate{{certificate}}
+				} else {
+					state.PeerCertificates = []*x509.Certificate{certificate}
+				}
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+			}
+			ctx, err := server.authPlugin(ctx)
+			if len(tt.want) == 0 {
+				if err == nil {
+					t.Errorf("authPlugin() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("authPlugin() error = %v", err)
+			}
+			if plugin, _ := PluginFromContext(ctx); plugin == nil || plugin.Name != tt.want {
+				t.Errorf("authPlugin() plugin = %#+v, want %s", plugin, tt.want)
+			}
+		})
+	}
+}
+
+func Test_GrpcServer_Shutdown(t *testing.T) {
+	server, _ := NewGrpcServer(0, []Plugin{{Name: "deploy", Token: "abc"}}, 0, zap.NewNop().Sugar())
+	functions := &commandFunctions{handler: make(chan func(bot.CommandInvocation), 1)}
+	grpcServer := server.newServer(insecure.NewCredentials())
+	RegisterIRCPluginServer(grpcServer, &pluginServer{
+		functions: functions,
+		buffers:   server.buffers,
+		bus:       server.bus,
+		stopping:  server.stopping,
+	})
+	server.servers = append(server.servers, grpcServer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := NewIRCPluginClient(conn).RegisterCommands(CtxWithToken(ctx, "bearer", "abc"),
+		&CommandRegistration{Commands: []*Command{{Name: "deploy"}}})
+	if err != nil {
+		t.Fatalf("RegisterCommands() error = %v", err)
+	}
+	select {
+	case <-functions.handler:
+	case <-ctx.Done():
+		t.Fatalf("RegisterCommands() never registered the commands")
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v, want the open stream closed without waiting for the timeout", err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("Recv() error = %v, want the stream to end", err)
+	}
+}
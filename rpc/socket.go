@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// DefaultSocketMode is the file mode of the RPC socket if none is given, allowing the bot's user and group to connect
+const DefaultSocketMode os.FileMode = 0660
+
+// listenSocket listens on a Unix socket at the given path with the given file mode, replacing a socket left behind
+// by a previous run
+func listenSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(path + ": exists and isn't a socket")
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// unixAuthInfo describes a plugin connected over the Unix socket, with the user of its process if the platform
+// reports peer credentials
+type unixAuthInfo struct {
+	credentials.CommonAuthInfo
+	uid      int
+	hasCreds bool
+}
+
+func (unixAuthInfo) AuthType() string {
+	return "unix"
+}
+
+// unixCredentials are the transport credentials of the Unix socket.  Connections aren't encrypted, as they never
+// leave the host, but the user of the connecting process is recorded so it can authenticate the plugin.
+type unixCredentials struct{}
+
+func (unixCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return conn, unixAuthInfo{CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity}}, nil
+}
+
+func (unixCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	uid, ok := peerUID(conn)
+	return conn, unixAuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		uid:            uid,
+		hasCreds:       ok,
+	}, nil
+}
+
+func (unixCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "unix"}
+}
+
+func (c unixCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (unixCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+// peerUser returns the user of the plugin's process if it connected over the Unix socket and the platform reports it
+func peerUser(ctx context.Context) (int, bool) {
+	client, ok := peer.FromContext(ctx)
+	if !ok {
+		return 0, false
+	}
+	info, ok := client.AuthInfo.(unixAuthInfo)
+	if !ok || !info.hasCreds {
+		return 0, false
+	}
+	return info.uid, true
+}
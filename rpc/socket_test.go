@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_listenSocket(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(path string) error
+		wantErr bool
+	}{
+		{name: "new socket", setup: func(string) error { return nil }},
+		{
+			name: "stale socket",
+			setup: func(path string) error {
+				listener, err := listenSocket(path, 0600)
+				if err != nil {
+					return err
+				}
+				return listener.Close()
+			},
+		},
+		{
+			name:    "regular file",
+			setup:   func(path string) error { return os.WriteFile(path, []byte("data"), 0600) },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rpc.sock")
+			if err := tt.setup(path); err != nil {
+				t.Fatal(err)
+			}
+			listener, err := listenSocket(path, 0640)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listenSocket() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = listener.Close()
+			}()
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("listenSocket() mode = %v, want 0640", info.Mode().Perm())
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/grpc-ecosystem/go-grpc-middleware/util/metautils"
 	"google.golang.org/grpc/metadata"
+	"strconv"
 	"strings"
 )
 
@@ -27,16 +28,42 @@ func ParsePluginString(pluginString string) (plugins []Plugin, err error) {
 		if len(value) == 0 {
 			break
 		}
-		pluginString := strings.Split(value, "=")
-		if len(pluginString) != 2 {
+		pluginString := strings.SplitN(value, "=", 3)
+		if len(pluginString) < 2 {
 			return nil, errors.New("invalid plugin definition")
 		}
-		plugins = append(plugins, Plugin{Name: pluginString[0], Token: pluginString[1]})
+		plugin := Plugin{Name: pluginString[0], Token: pluginString[1]}
+		if len(pluginString) == 3 {
+			plugin.Permissions, err = ParsePermissions(pluginString[2])
+			if err != nil {
+				return nil, err
+			}
+		}
+		plugins = append(plugins, plugin)
 	}
 	return
 }
 
+// Plugin describes a plugin allowed to connect, a nil Permissions grants every scope.  Buffer is how many items are
+// kept for the plugin while its streams are closed, zero disables buffering.  Identity is the common name of the
+// client certificate the plugin may authenticate with instead of its token, and UID the user it may connect to the
+// Unix socket as instead.
 type Plugin struct {
-	Name  string
-	Token string
+	Name        string
+	Token       string
+	Identity    string
+	UID         *int
+	Permissions Permissions
+	Buffer      int
+	// fold compares channels and nicks the way the server does, it is set when the plugin authenticates
+	fold func(string) string
+}
+
+// credential identifies what the plugin authenticates with, so its streams can be closed if that changes
+func (p *Plugin) credential() string {
+	uid := ""
+	if p.UID != nil {
+		uid = strconv.Itoa(*p.UID)
+	}
+	return p.Token + "\x00" + p.Identity + "\x00" + uid
 }